@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/attachments"
+	"github.com/kentapp/kent/server/internal/images"
+	"github.com/kentapp/kent/server/internal/users"
+)
+
+const quarantinePageSize = 200
+
+// adminMiddleware admits requests bearing the operator's ADMIN_TOKEN rather
+// than a user's access token.
+func adminMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if len(header) < 7 || !strings.EqualFold(header[:7], "bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimSpace(header[7:])), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Admin routes are operator tooling, mounted under /v1/admin only when an
+// admin token is configured.
+func registerAdminRoutes(g *gin.RouterGroup, attSvc *attachments.Service, imgSvc *images.Service, userRepo *users.Repository) {
+	g.POST("/storage/recompute", func(c *gin.Context) {
+		var userID *uuid.UUID
+		if raw := c.Query("userId"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+			userID = &id
+		}
+
+		nUsers, nChats, err := attSvc.RecomputeUsage(c.Request.Context(), userID)
+		if err != nil {
+			log.Printf("storage recompute failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "recompute_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"users": nUsers, "chats": nChats})
+	})
+
+	g.PUT("/users/:userId/tier", func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		var req struct {
+			Tier users.Tier `json:"tier"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		found, err := userRepo.SetTier(c.Request.Context(), userID, req.Tier)
+		if errors.Is(err, users.ErrInvalidTier) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_tier"})
+			return
+		}
+		if err != nil {
+			log.Printf("set tier failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "set_tier_failed"})
+			return
+		}
+		if !found {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tier": req.Tier})
+	})
+
+	g.GET("/quarantine", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		atts, err := attSvc.Quarantined(ctx, quarantinePageSize)
+		if err != nil {
+			log.Printf("list quarantine failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "quarantine_failed"})
+			return
+		}
+		imgs, err := imgSvc.Quarantined(ctx, quarantinePageSize)
+		if err != nil {
+			log.Printf("list quarantine failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "quarantine_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"attachments": atts, "images": imgs})
+	})
+
+	g.POST("/attachments/:attachmentId/release", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("attachmentId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		if err := attSvc.Release(c.Request.Context(), id); err != nil {
+			respondAttachmentError(c, err, "release")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.DELETE("/attachments/:attachmentId", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("attachmentId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		if err := attSvc.Remove(c.Request.Context(), id); err != nil {
+			respondAttachmentError(c, err, "remove")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.POST("/images/:digest/release", func(c *gin.Context) {
+		digest, ok := digestParam(c)
+		if !ok {
+			return
+		}
+		if err := imgSvc.Release(c.Request.Context(), digest); err != nil {
+			respondImageError(c, err, "image_release")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.DELETE("/images/:digest", func(c *gin.Context) {
+		digest, ok := digestParam(c)
+		if !ok {
+			return
+		}
+		if err := imgSvc.Remove(c.Request.Context(), digest); err != nil {
+			respondImageError(c, err, "image_remove")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
+
+func digestParam(c *gin.Context) ([]byte, bool) {
+	digest, err := hex.DecodeString(c.Param("digest"))
+	if err != nil || len(digest) != sha256.Size {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+		return nil, false
+	}
+	return digest, true
+}
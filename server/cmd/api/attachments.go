@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/attachments"
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+// Attachment routes take resumable uploads of client-encrypted blobs,
+// tus-style: create the upload, then PATCH chunks at Upload-Offset until the
+// whole size is stored. HEAD tells a client where to resume. Downloads go
+// through signed URLs to the media routes. Uploads count against the user's
+// storage quota from creation.
+func registerAttachmentRoutes(g *gin.RouterGroup, attSvc *attachments.Service, signer *attachments.Signer) {
+	g.POST("/attachments", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			Size   int64  `json:"size"`
+			SHA256 []byte `json:"sha256"`
+			Public bool   `json:"public"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		a, err := attSvc.Create(c.Request.Context(), userID, req.Size, req.SHA256, req.Public)
+		if err != nil {
+			respondAttachmentError(c, err, "create")
+			return
+		}
+		c.JSON(http.StatusOK, a)
+	})
+
+	g.GET("/attachments/:attachmentId", func(c *gin.Context) {
+		a, ok := loadUpload(c, attSvc)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, a)
+	})
+
+	g.HEAD("/attachments/:attachmentId", func(c *gin.Context) {
+		a, ok := loadUpload(c, attSvc)
+		if !ok {
+			return
+		}
+		c.Header("Upload-Offset", strconv.FormatInt(a.Offset, 10))
+		c.Header("Upload-Length", strconv.FormatInt(a.Size, 10))
+		c.Header("Cache-Control", "no-store")
+		c.Status(http.StatusOK)
+	})
+
+	g.PATCH("/attachments/:attachmentId", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		id, err := uuid.Parse(c.Param("attachmentId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		size := c.Request.ContentLength
+		if size < 0 {
+			c.JSON(http.StatusLengthRequired, gin.H{"error": "length_required"})
+			return
+		}
+		if size > attachments.MaxChunkSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "chunk_too_large"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, size)
+		a, err := attSvc.Append(ctx, userID, id, offset, body, size)
+		if errors.Is(err, attachments.ErrOffsetMismatch) {
+			if current, getErr := attSvc.Get(ctx, userID, id); getErr == nil {
+				c.Header("Upload-Offset", strconv.FormatInt(current.Offset, 10))
+				c.JSON(http.StatusConflict, gin.H{"error": "offset_mismatch", "offset": current.Offset})
+				return
+			}
+		}
+		if err != nil {
+			respondAttachmentError(c, err, "upload")
+			return
+		}
+		c.Header("Upload-Offset", strconv.FormatInt(a.Offset, 10))
+		c.JSON(http.StatusOK, a)
+	})
+
+	g.GET("/attachments/:attachmentId/url", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		id, err := uuid.Parse(c.Param("attachmentId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := attSvc.Authorize(c.Request.Context(), userID, id); err != nil {
+			respondAttachmentError(c, err, "url")
+			return
+		}
+		url, expiresAt := signer.URL(id, time.Now())
+		c.JSON(http.StatusOK, gin.H{"url": url, "expiresAt": expiresAt})
+	})
+
+	g.GET("/storage", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		u, err := attSvc.Usage(c.Request.Context(), userID)
+		if err != nil {
+			respondAttachmentError(c, err, "usage")
+			return
+		}
+		c.JSON(http.StatusOK, u)
+	})
+
+	g.GET("/chats/:chatId/storage", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		n, err := attSvc.ChatUsage(c.Request.Context(), userID, chatID)
+		if err != nil {
+			respondAttachmentError(c, err, "usage")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"bytes": n})
+	})
+}
+
+func loadUpload(c *gin.Context, attSvc *attachments.Service) (*attachments.Attachment, bool) {
+	userID, _, ok := identity(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+		return nil, false
+	}
+	id, err := uuid.Parse(c.Param("attachmentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+		return nil, false
+	}
+	a, err := attSvc.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		respondAttachmentError(c, err, "get")
+		return nil, false
+	}
+	return a, true
+}
+
+// respondAttachmentError maps attachment service errors to the API's error
+// codes.
+func respondAttachmentError(c *gin.Context, err error, op string) {
+	switch {
+	case errors.Is(err, attachments.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+	case errors.Is(err, attachments.ErrInvalidSize):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_size"})
+	case errors.Is(err, attachments.ErrInvalidChunk):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_chunk"})
+	case errors.Is(err, attachments.ErrOffsetMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": "offset_mismatch"})
+	case errors.Is(err, attachments.ErrCompleted):
+		c.JSON(http.StatusConflict, gin.H{"error": "upload_completed"})
+	case errors.Is(err, attachments.ErrDigestMismatch):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "digest_mismatch"})
+	case errors.Is(err, attachments.ErrQuarantined):
+		c.JSON(http.StatusConflict, gin.H{"error": "quarantined"})
+	case errors.Is(err, attachments.ErrQuotaExceeded):
+		c.JSON(http.StatusForbidden, gin.H{"error": "quota_exceeded"})
+	case errors.Is(err, chats.ErrNotParticipant), errors.Is(err, chats.ErrNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	case errors.Is(err, chats.ErrBanned):
+		c.JSON(http.StatusForbidden, gin.H{"error": "banned"})
+	default:
+		log.Printf("attachment %s failed: %v", op, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "attachment_" + op + "_failed"})
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/images"
+	"github.com/kentapp/kent/server/internal/realtime"
+)
+
+// Avatar routes take a JPEG, PNG or GIF as the raw request body and replace
+// the user's or chat's avatar with the processed renditions.
+func registerAvatarRoutes(g *gin.RouterGroup, imgSvc *images.Service, chatRepo *chats.Repository, hub *realtime.Hub) {
+	g.PUT("/users/me/avatar", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		data, ok := readImage(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+		a, err := imgSvc.SetUserAvatar(ctx, userID, data)
+		if err != nil {
+			respondImageError(c, err, "avatar_update")
+			return
+		}
+		publishUserAvatar(ctx, chatRepo, hub, userID, &a.ID)
+		c.JSON(http.StatusOK, a)
+	})
+
+	g.DELETE("/users/me/avatar", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		if err := imgSvc.ClearUserAvatar(ctx, userID); err != nil {
+			respondImageError(c, err, "avatar_delete")
+			return
+		}
+		publishUserAvatar(ctx, chatRepo, hub, userID, nil)
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.PUT("/chats/:chatId/avatar", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		data, ok := readImage(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+		a, err := imgSvc.SetChatAvatar(ctx, userID, chatID, data)
+		if err != nil {
+			respondImageError(c, err, "avatar_update")
+			return
+		}
+		notifyChat(ctx, chatRepo, hub, chatID, "chat.avatar", gin.H{"chatId": chatID, "avatarId": a.ID, "changedBy": userID})
+		c.JSON(http.StatusOK, a)
+	})
+
+	g.DELETE("/chats/:chatId/avatar", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		if err := imgSvc.ClearChatAvatar(ctx, userID, chatID); err != nil {
+			respondImageError(c, err, "avatar_delete")
+			return
+		}
+		notifyChat(ctx, chatRepo, hub, chatID, "chat.avatar", gin.H{"chatId": chatID, "avatarId": nil, "changedBy": userID})
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.GET("/avatars/:avatarId", func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("avatarId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		a, err := imgSvc.Avatar(c.Request.Context(), id)
+		if err != nil {
+			respondImageError(c, err, "avatar")
+			return
+		}
+		c.JSON(http.StatusOK, a)
+	})
+}
+
+// Image routes serve renditions by content digest without a bearer token.
+// A name's bytes never change, so caches may keep them for a year.
+func registerImageRoutes(g *gin.RouterGroup, imgSvc *images.Service) {
+	serve := func(c *gin.Context) {
+		name := c.Param("name")
+		hexDigest, _, _ := strings.Cut(name, ".")
+		digest, err := hex.DecodeString(hexDigest)
+		if err != nil || len(digest) != 32 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+			return
+		}
+
+		img, err := imgSvc.Open(c.Request.Context(), digest)
+		if err == nil && images.Name(img.Digest, img.ContentType) != name {
+			err = images.ErrNotFound
+		}
+		if err != nil {
+			respondImageError(c, err, "image")
+			return
+		}
+		defer img.Content.Close()
+
+		c.Header("ETag", `"`+hexDigest+`"`)
+		c.Header("Content-Type", img.ContentType)
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+		c.Header("X-Content-Type-Options", "nosniff")
+		http.ServeContent(c.Writer, c.Request, "", img.CreatedAt, img.Content)
+	}
+	g.GET("/images/:name", serve)
+	g.HEAD("/images/:name", serve)
+}
+
+// readImage reads an avatar upload, rejecting bodies over the size limit
+// before they are buffered.
+func readImage(c *gin.Context) ([]byte, bool) {
+	if c.Request.ContentLength > images.MaxUploadSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image_too_large"})
+		return nil, false
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, images.MaxUploadSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image_too_large"})
+		return nil, false
+	}
+	if err != nil || len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+		return nil, false
+	}
+	return data, true
+}
+
+// publishUserAvatar tells the user's devices and everyone sharing a direct
+// or group chat with them that their avatar changed.
+func publishUserAvatar(ctx context.Context, chatRepo *chats.Repository, hub *realtime.Hub, userID uuid.UUID, avatarID *uuid.UUID) {
+	peers, err := chatRepo.PeerIDs(ctx, userID)
+	if err != nil {
+		log.Printf("load peers failed: %v", err)
+		return
+	}
+	if err := hub.PublishToUsers(ctx, append(peers, userID), "user.avatar", gin.H{"userId": userID, "avatarId": avatarID}); err != nil {
+		log.Printf("publish user.avatar failed: %v", err)
+	}
+}
+
+// respondImageError maps image service errors to the API's error codes.
+func respondImageError(c *gin.Context, err error, op string) {
+	switch {
+	case errors.Is(err, images.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+	case errors.Is(err, images.ErrUnsupported):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported_image"})
+	case errors.Is(err, images.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "image_too_large"})
+	case errors.Is(err, images.ErrCorrupt):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_image"})
+	case errors.Is(err, chats.ErrNotParticipant), errors.Is(err, chats.ErrNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	case errors.Is(err, chats.ErrBanned):
+		c.JSON(http.StatusForbidden, gin.H{"error": "banned"})
+	default:
+		log.Printf("%s failed: %v", op, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": op + "_failed"})
+	}
+}
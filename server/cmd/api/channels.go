@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/messages"
+)
+
+// Channel posts are sent through POST /chats/:chatId/messages with a body;
+// these routes cover discovery, subscription and the pull-based feed.
+func registerChannelRoutes(g *gin.RouterGroup, chatRepo *chats.Repository, msgSvc *messages.Service) {
+	g.POST("/channels", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			Title    string `json:"title"`
+			IsPublic bool   `json:"isPublic"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Title == "" || len(req.Title) > 128 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		chat, err := chatRepo.Create(c.Request.Context(), userID, nil, chats.KindChannel, &req.Title, req.IsPublic)
+		if err != nil {
+			respondChatError(c, err, "channel_create")
+			return
+		}
+		c.JSON(http.StatusOK, chat)
+	})
+
+	g.GET("/channels/:chatId", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		info, err := chatRepo.Channel(c.Request.Context(), chatID, userID)
+		if err != nil {
+			respondChatError(c, err, "channel")
+			return
+		}
+		c.JSON(http.StatusOK, info)
+	})
+
+	g.POST("/channels/:chatId/subscription", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := chatRepo.Subscribe(c.Request.Context(), chatID, userID); err != nil {
+			respondChatError(c, err, "channel_subscribe")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.DELETE("/channels/:chatId/subscription", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := chatRepo.Unsubscribe(c.Request.Context(), chatID, userID); err != nil {
+			respondChatError(c, err, "channel_unsubscribe")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.GET("/channels/:chatId/posts", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		q, err := parseHistoryQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		posts, err := msgSvc.ChannelPosts(c.Request.Context(), userID, chatID, q)
+		if err != nil {
+			respondMessageError(c, err, "posts")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"posts": posts})
+	})
+
+	g.POST("/channels/:chatId/views", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			MessageIDs []uuid.UUID `json:"messageIds"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.MessageIDs) == 0 || len(req.MessageIDs) > messages.MaxViewBatch {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		counts, err := msgSvc.RecordViews(c.Request.Context(), userID, chatID, req.MessageIDs)
+		if err != nil {
+			respondMessageError(c, err, "views")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"views": counts})
+	})
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/messages"
+	"github.com/kentapp/kent/server/internal/realtime"
+)
+
+const maxMembersPerRequest = 200
+
+func registerChatRoutes(g *gin.RouterGroup, chatRepo *chats.Repository, hub *realtime.Hub) {
+	g.POST("/chats", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			MemberIDs []uuid.UUID `json:"memberIds"`
+			Kind      chats.Kind  `json:"kind"`
+			IsGroup   bool        `json:"isGroup"`
+			IsPublic  bool        `json:"isPublic"`
+			Title     string      `json:"title"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.MemberIDs) > maxMembersPerRequest || len(req.Title) > 128 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		// Older clients only send isGroup.
+		if req.Kind == "" {
+			req.Kind = chats.KindDirect
+			if req.IsGroup {
+				req.Kind = chats.KindGroup
+			}
+		}
+		if !req.Kind.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		chat, err := chatRepo.Create(c.Request.Context(), userID, req.MemberIDs, req.Kind, optionalString(req.Title), req.IsPublic)
+		if err != nil {
+			if errors.Is(err, chats.ErrInvalidMembers) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_members"})
+				return
+			}
+			log.Printf("create chat failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "chat_create_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, chat)
+	})
+
+	g.GET("/chats", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		list, err := chatRepo.List(c.Request.Context(), userID, c.Query("archived") == "true")
+		if err != nil {
+			log.Printf("list chats failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "chat_list_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"chats": list})
+	})
+
+	g.PUT("/chats/:chatId/ttl", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Seconds int `json:"seconds"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		ttl := time.Duration(req.Seconds) * time.Second
+		if req.Seconds != 0 && (ttl < messages.MinTTL || ttl > messages.MaxTTL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_ttl"})
+			return
+		}
+
+		var seconds *int
+		if req.Seconds != 0 {
+			seconds = &req.Seconds
+		}
+		ctx := c.Request.Context()
+		if err := chatRepo.SetMessageTTL(ctx, chatID, userID, seconds); err != nil {
+			respondChatError(c, err, "chat_ttl")
+			return
+		}
+
+		notifyChat(ctx, chatRepo, hub, chatID, "chat.ttl", gin.H{"chatId": chatID, "messageTtlSeconds": seconds, "changedBy": userID})
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.PUT("/chats/:chatId/state", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var st chats.State
+		if err := c.ShouldBindJSON(&st); err != nil || (st.PinOrder != nil && *st.PinOrder < 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		if err := chatRepo.SetState(ctx, chatID, userID, st); err != nil {
+			respondChatError(c, err, "chat_state")
+			return
+		}
+
+		if err := hub.PublishToUsers(ctx, []uuid.UUID{userID}, "chat.state", gin.H{"chatId": chatID, "state": st}); err != nil {
+			log.Printf("chat event chat.state failed: %v", err)
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.GET("/chats/:chatId/draft", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		draft, err := chatRepo.Draft(c.Request.Context(), chatID, userID)
+		if err != nil {
+			respondChatError(c, err, "draft")
+			return
+		}
+		c.JSON(http.StatusOK, draft)
+	})
+
+	g.PUT("/chats/:chatId/draft", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Ciphertext []byte `json:"ciphertext"`
+			Version    int64  `json:"version"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		draft, err := chatRepo.SaveDraft(ctx, chatID, userID, deviceID, req.Ciphertext, req.Version)
+		if errors.Is(err, chats.ErrDraftConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "draft_conflict", "draft": draft})
+			return
+		}
+		if err != nil {
+			respondChatError(c, err, "draft")
+			return
+		}
+
+		if err := hub.PublishToUsers(ctx, []uuid.UUID{userID}, chats.EventDraft, gin.H{"chatId": chatID, "draft": draft}); err != nil {
+			log.Printf("chat event %s failed: %v", chats.EventDraft, err)
+		}
+		c.JSON(http.StatusOK, draft)
+	})
+}
+
+// notifyChat publishes a chat-level event to every participant; failures are
+// logged because the change itself has already been stored.
+func notifyChat(ctx context.Context, chatRepo *chats.Repository, hub *realtime.Hub, chatID uuid.UUID, eventType string, data any) {
+	recipients, err := chatRepo.RecipientIDs(ctx, chatID)
+	if err == nil {
+		err = hub.PublishToUsers(ctx, recipients, eventType, data)
+	}
+	if err != nil {
+		log.Printf("chat event %s failed: %v", eventType, err)
+	}
+}
+
+func notifyAdmins(ctx context.Context, chatRepo *chats.Repository, hub *realtime.Hub, chatID uuid.UUID, eventType string, data any) {
+	recipients, err := chatRepo.AdminIDs(ctx, chatID)
+	if err == nil {
+		err = hub.PublishToUsers(ctx, recipients, eventType, data)
+	}
+	if err != nil {
+		log.Printf("chat event %s failed: %v", eventType, err)
+	}
+}
+
+// respondChatError maps chats package errors to the API's error codes.
+func respondChatError(c *gin.Context, err error, op string) {
+	switch {
+	case errors.Is(err, chats.ErrNotParticipant), errors.Is(err, chats.ErrNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	case errors.Is(err, chats.ErrRestricted):
+		c.JSON(http.StatusForbidden, gin.H{"error": "restricted"})
+	case errors.Is(err, chats.ErrBanned):
+		c.JSON(http.StatusForbidden, gin.H{"error": "banned"})
+	case errors.Is(err, chats.ErrInvalidRole), errors.Is(err, chats.ErrInvalidPermission):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_role"})
+	case errors.Is(err, chats.ErrInviteInvalid):
+		c.JSON(http.StatusNotFound, gin.H{"error": "invite_invalid"})
+	case errors.Is(err, chats.ErrRequestNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+	case errors.Is(err, chats.ErrInvalidMembers):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_members"})
+	case errors.Is(err, chats.ErrInvalidDraft):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_draft"})
+	case errors.Is(err, chats.ErrNotChannel):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_a_channel"})
+	default:
+		log.Printf("chat %s failed: %v", op, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": op + "_failed"})
+	}
+}
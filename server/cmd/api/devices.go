@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/realtime"
+	"github.com/kentapp/kent/server/internal/users"
+)
+
+// Device routes let the primary device approve and revoke the account's
+// other devices by signing its device list, and let contacts fetch that list
+// to check who they are encrypting to.
+func registerDeviceRoutes(g *gin.RouterGroup, userRepo *users.Repository, chatRepo *chats.Repository, hub *realtime.Hub) {
+	g.GET("/devices", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		devices, err := userRepo.Devices(c.Request.Context(), userID)
+		if err != nil {
+			respondDeviceError(c, err, "devices")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"devices": devices})
+	})
+
+	g.PUT("/devices/list", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			Version   int64       `json:"version"`
+			DeviceIDs []uuid.UUID `json:"deviceIds"`
+			Signature []byte      `json:"signature"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Version <= 0 || len(req.DeviceIDs) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		revoked, err := userRepo.SignDeviceList(ctx, userID, deviceID, req.Version, req.DeviceIDs, req.Signature)
+		if err != nil {
+			respondDeviceError(c, err, "device_list")
+			return
+		}
+		publishDevicesChanged(ctx, chatRepo, hub, userID)
+		c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+	})
+
+	g.GET("/users/:userId/devices", func(c *gin.Context) {
+		if _, _, ok := identity(c); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		userID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		list, err := userRepo.SignedDeviceList(c.Request.Context(), userID)
+		if err != nil {
+			respondDeviceError(c, err, "device_list")
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+}
+
+// publishDevicesChanged tells the user's contacts, and the user's own other
+// devices, that the safety number changed.
+func publishDevicesChanged(ctx context.Context, chatRepo *chats.Repository, hub *realtime.Hub, userID uuid.UUID) {
+	peers, err := chatRepo.PeerIDs(ctx, userID)
+	if err != nil {
+		log.Printf("load peers failed: %v", err)
+		return
+	}
+	if err := hub.PublishToUsers(ctx, append(peers, userID), users.EventDevicesChanged, gin.H{"userId": userID}); err != nil {
+		log.Printf("publish %s failed: %v", users.EventDevicesChanged, err)
+	}
+}
+
+// respondDeviceError maps device list errors to the API's error codes.
+func respondDeviceError(c *gin.Context, err error, op string) {
+	switch {
+	case errors.Is(err, users.ErrNotPrimary):
+		c.JSON(http.StatusForbidden, gin.H{"error": "not_primary"})
+	case errors.Is(err, users.ErrStaleDeviceList):
+		c.JSON(http.StatusConflict, gin.H{"error": "stale_version"})
+	case errors.Is(err, users.ErrInvalidDeviceList):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_device_list"})
+	case errors.Is(err, users.ErrBadListSignature):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_signature"})
+	case errors.Is(err, users.ErrNoDeviceList):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+	default:
+		log.Printf("%s failed: %v", op, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": op + "_failed"})
+	}
+}
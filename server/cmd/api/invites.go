@@ -0,0 +1,234 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/realtime"
+)
+
+func registerInviteRoutes(g *gin.RouterGroup, chatRepo *chats.Repository, hub *realtime.Hub) {
+	g.POST("/chats/:chatId/invites", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			ExpiresAt        *time.Time `json:"expiresAt"`
+			MaxUses          *int       `json:"maxUses"`
+			RequiresApproval bool       `json:"requiresApproval"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil ||
+			(req.MaxUses != nil && *req.MaxUses <= 0) ||
+			(req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now())) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		link, err := chatRepo.CreateInvite(c.Request.Context(), chatID, userID, chats.InviteOptions{
+			ExpiresAt:        req.ExpiresAt,
+			MaxUses:          req.MaxUses,
+			RequiresApproval: req.RequiresApproval,
+		})
+		if err != nil {
+			respondChatError(c, err, "invite_create")
+			return
+		}
+		c.JSON(http.StatusOK, link)
+	})
+
+	g.GET("/chats/:chatId/invites", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		links, err := chatRepo.ListInvites(c.Request.Context(), chatID, userID)
+		if err != nil {
+			respondChatError(c, err, "invite_list")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"invites": links})
+	})
+
+	g.DELETE("/chats/:chatId/invites/:linkId", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		linkID, err := uuid.Parse(c.Param("linkId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := chatRepo.RevokeInvite(c.Request.Context(), chatID, userID, linkID); err != nil {
+			respondChatError(c, err, "invite_revoke")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.GET("/chats/:chatId/invite-joins", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		var linkID *uuid.UUID
+		if raw := c.Query("linkId"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+			linkID = &id
+		}
+
+		joins, err := chatRepo.InviteJoins(c.Request.Context(), chatID, userID, linkID)
+		if err != nil {
+			respondChatError(c, err, "invite_joins")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"joins": joins})
+	})
+
+	// The token is a bearer secret, so it travels in the body rather than
+	// the path, where access logs and proxies would record it.
+	g.POST("/invites/preview", func(c *gin.Context) {
+		token, ok := inviteToken(c)
+		if !ok {
+			return
+		}
+
+		preview, err := chatRepo.PreviewInvite(c.Request.Context(), token)
+		if err != nil {
+			respondChatError(c, err, "invite_preview")
+			return
+		}
+		c.JSON(http.StatusOK, preview)
+	})
+
+	g.POST("/invites/join", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		token, ok := inviteToken(c)
+		if !ok {
+			return
+		}
+
+		ctx := c.Request.Context()
+		chatID, status, err := chatRepo.JoinByInvite(ctx, token, userID)
+		if err != nil {
+			respondChatError(c, err, "invite_join")
+			return
+		}
+
+		switch status {
+		case chats.JoinStatusJoined:
+			notifyChat(ctx, chatRepo, hub, chatID, "chat.member_joined", gin.H{"chatId": chatID, "userId": userID})
+		case chats.JoinStatusPending:
+			notifyAdmins(ctx, chatRepo, hub, chatID, "chat.join_request", gin.H{"chatId": chatID, "userId": userID})
+		}
+		c.JSON(http.StatusOK, gin.H{"chatId": chatID, "status": status})
+	})
+
+	g.GET("/chats/:chatId/join-requests", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		list, err := chatRepo.ListJoinRequests(c.Request.Context(), chatID, userID)
+		if err != nil {
+			respondChatError(c, err, "join_requests")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"requests": list})
+	})
+
+	decide := func(approve bool) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			adminID, _, ok := identity(c)
+			if !ok {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+				return
+			}
+			chatID, userID, ok := chatAndUserParams(c)
+			if !ok {
+				return
+			}
+
+			ctx := c.Request.Context()
+			if err := chatRepo.DecideJoinRequest(ctx, chatID, adminID, userID, approve); err != nil {
+				respondChatError(c, err, "join_decision")
+				return
+			}
+
+			if approve {
+				notifyChat(ctx, chatRepo, hub, chatID, "chat.member_joined", gin.H{"chatId": chatID, "userId": userID, "approvedBy": adminID})
+			} else if err := hub.PublishToUsers(ctx, []uuid.UUID{userID}, "chat.join_rejected", gin.H{"chatId": chatID}); err != nil {
+				log.Printf("chat event chat.join_rejected failed: %v", err)
+			}
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+		}
+	}
+	g.POST("/chats/:chatId/join-requests/:userId/approve", decide(true))
+	g.POST("/chats/:chatId/join-requests/:userId/reject", decide(false))
+}
+
+// inviteToken reads { token } from the request body.
+func inviteToken(c *gin.Context) (string, bool) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+		return "", false
+	}
+	token := strings.TrimSpace(req.Token)
+	if token == "" || len(token) > 64 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+		return "", false
+	}
+	return token, true
+}
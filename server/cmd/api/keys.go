@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/keys"
+	"github.com/kentapp/kent/server/internal/ratelimit"
+	"github.com/kentapp/kent/server/internal/realtime"
+	"github.com/kentapp/kent/server/internal/users"
+)
+
+// Key routes publish the calling device's public keys and hand out other
+// users' prekey bundles for X3DH session setup.
+func registerKeyRoutes(g *gin.RouterGroup, keySvc *keys.Service, chatRepo *chats.Repository, hub *realtime.Hub, limiter *ratelimit.Limiter) {
+	g.PUT("/keys/identity", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			IdentityKey []byte `json:"identityKey"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		changed, reset, err := keySvc.SetIdentityKey(ctx, userID, deviceID, req.IdentityKey)
+		if err != nil {
+			respondKeyError(c, err, "identity_key")
+			return
+		}
+		if reset {
+			if err := hub.PublishToUsers(ctx, []uuid.UUID{userID}, users.EventDeviceListReset, gin.H{"deviceId": deviceID}); err != nil {
+				log.Printf("publish %s failed: %v", users.EventDeviceListReset, err)
+			}
+		}
+		if changed {
+			publishDevicesChanged(ctx, chatRepo, hub, userID)
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.PUT("/keys/signed-prekey", func(c *gin.Context) {
+		_, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req keys.SignedPrekey
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := keySvc.SetSignedPrekey(c.Request.Context(), deviceID, req); err != nil {
+			respondKeyError(c, err, "signed_prekey")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.POST("/keys/prekeys", func(c *gin.Context) {
+		_, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			Prekeys []keys.Prekey `json:"prekeys"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		count, err := keySvc.AddPrekeys(c.Request.Context(), deviceID, req.Prekeys)
+		if err != nil {
+			respondKeyError(c, err, "prekeys")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"count": count})
+	})
+
+	g.GET("/keys/prekeys/count", func(c *gin.Context) {
+		_, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		count, err := keySvc.PrekeyCount(c.Request.Context(), deviceID)
+		if err != nil {
+			respondKeyError(c, err, "prekeys")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"count": count, "low": count < keys.LowPrekeyThreshold})
+	})
+
+	g.GET("/users/:userId/keys", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		targetID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		var targetDevice *uuid.UUID
+		if raw := c.Query("deviceId"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+			targetDevice = &id
+		}
+		if !allowKeyFetch(c, limiter, "prekeys", userID, targetID) {
+			return
+		}
+
+		bundles, err := keySvc.Bundles(c.Request.Context(), deviceID, targetID, targetDevice)
+		if err != nil {
+			respondKeyError(c, err, "bundle")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"bundles": bundles})
+	})
+}
+
+// respondKeyError maps key service errors to the API's error codes.
+func respondKeyError(c *gin.Context, err error, op string) {
+	switch {
+	case errors.Is(err, keys.ErrInvalidKey):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_key"})
+	case errors.Is(err, keys.ErrBadSignature):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_signature"})
+	case errors.Is(err, keys.ErrNoIdentity):
+		c.JSON(http.StatusConflict, gin.H{"error": "no_identity_key"})
+	case errors.Is(err, keys.ErrTooManyPrekeys):
+		c.JSON(http.StatusConflict, gin.H{"error": "too_many_prekeys"})
+	case errors.Is(err, keys.ErrNoBundle):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no_keys"})
+	default:
+		log.Printf("key %s failed: %v", op, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": op + "_failed"})
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
 	"log"
 	"net/http"
@@ -11,10 +13,23 @@ import (
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 
+	"github.com/kentapp/kent/server/internal/attachments"
 	"github.com/kentapp/kent/server/internal/auth"
+	"github.com/kentapp/kent/server/internal/blob"
+	"github.com/kentapp/kent/server/internal/chats"
 	"github.com/kentapp/kent/server/internal/config"
 	"github.com/kentapp/kent/server/internal/db"
+	"github.com/kentapp/kent/server/internal/images"
+	"github.com/kentapp/kent/server/internal/keys"
+	"github.com/kentapp/kent/server/internal/messages"
+	"github.com/kentapp/kent/server/internal/mls"
 	"github.com/kentapp/kent/server/internal/otp"
+	"github.com/kentapp/kent/server/internal/presence"
+	"github.com/kentapp/kent/server/internal/push"
+	"github.com/kentapp/kent/server/internal/ratelimit"
+	"github.com/kentapp/kent/server/internal/realtime"
+	"github.com/kentapp/kent/server/internal/scan"
+	"github.com/kentapp/kent/server/internal/transparency"
 	"github.com/kentapp/kent/server/internal/users"
 )
 
@@ -44,6 +59,31 @@ func main() {
 
 	userRepo := users.NewRepository(pool)
 	authSvc := auth.NewService(pool, []byte(cfg.AccessSecret), []byte(cfg.RefreshSecret), cfg.AccessTTL, cfg.RefreshTTL)
+	chatRepo := chats.NewRepository(pool)
+	hub := realtime.NewHub(rdb)
+	limiter := ratelimit.New(rdb)
+	presenceSvc := presence.NewService(rdb, userRepo, chatRepo, hub, cfg.PresenceTTL, cfg.TypingTTL)
+	pushDispatcher := push.NewDispatcher(pool, newPushSender(cfg))
+	keySvc := keys.NewService(pool, hub)
+	ktSeed, _ := base64.StdEncoding.DecodeString(cfg.KTSigningKey)
+	ktSvc := transparency.NewService(pool, ed25519.NewKeyFromSeed(ktSeed))
+	mlsSvc := mls.NewService(pool, chatRepo, hub)
+	msgSvc := messages.NewService(pool, chatRepo, hub, pushDispatcher, cfg.MessageEditWindow)
+	blobStore := newBlobStore(cfg)
+	attSvc := attachments.NewService(pool, blobStore, chatRepo, attachments.Quotas{
+		string(users.TierFree):    cfg.StorageQuotaFree,
+		string(users.TierPremium): cfg.StorageQuotaPremium,
+	})
+	mediaSigner := attachments.NewSigner([]byte(cfg.MediaURLSecret), cfg.MediaURLTTL, cfg.MediaBaseURL)
+	go msgSvc.RunExpirySweeper(ctx, cfg.ExpirySweepInterval)
+	go msgSvc.RunScheduler(ctx, cfg.SchedulerInterval)
+	go msgSvc.RunCounterFlush(ctx, cfg.CounterFlushInterval)
+	imgSvc := images.NewService(pool, blobStore, chatRepo, cfg.ImageBaseURL)
+	go attSvc.RunGC(ctx, cfg.AttachmentGCInterval, cfg.AttachmentGCGrace)
+	go imgSvc.RunGC(ctx, cfg.AttachmentGCInterval, cfg.AttachmentGCGrace)
+	scanner := newScanner(cfg)
+	go attSvc.RunScanner(ctx, scanner, cfg.ScanInterval)
+	go imgSvc.RunScanner(ctx, scanner, cfg.ScanInterval)
 
 	r := gin.Default()
 	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
@@ -129,11 +169,24 @@ func main() {
 			return
 		}
 
+		// Every login adds a device that is pending until the primary
+		// approves it, but the login alone changes what contacts see, so it
+		// is never silent.
+		ctx := c.Request.Context()
+		if !device.Primary {
+			if err := hub.PublishToUsers(ctx, []uuid.UUID{user.ID}, users.EventDeviceAdded, gin.H{"deviceId": device.ID, "label": device.Label}); err != nil {
+				log.Printf("publish %s failed: %v", users.EventDeviceAdded, err)
+			}
+		}
+		publishDevicesChanged(ctx, chatRepo, hub, user.ID)
+
 		resp := gin.H{
 			"user": gin.H{
 				"id":          user.ID,
 				"phone":       user.Phone,
+				"username":    user.Username,
 				"displayName": user.DisplayName,
+				"avatarId":    user.AvatarID,
 			},
 			"access": gin.H{
 				"token":     tokens.AccessToken,
@@ -145,8 +198,10 @@ func main() {
 				"sessionId": tokens.SessionID,
 			},
 			"device": gin.H{
-				"id":    device.ID,
-				"label": device.Label,
+				"id":       device.ID,
+				"label":    device.Label,
+				"verified": device.Verified,
+				"primary":  device.Primary,
 			},
 		}
 
@@ -186,6 +241,14 @@ func main() {
 		})
 	})
 
+	registerMediaRoutes(r.Group("/v1"), attSvc, mediaSigner)
+	registerImageRoutes(r.Group("/v1"), imgSvc)
+	if cfg.AdminToken != "" {
+		adminGroup := r.Group("/v1/admin")
+		adminGroup.Use(adminMiddleware(cfg.AdminToken))
+		registerAdminRoutes(adminGroup, attSvc, imgSvc, userRepo)
+	}
+
 	authGroup := r.Group("/v1")
 	authGroup.Use(authMiddleware(authSvc))
 
@@ -239,11 +302,34 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{
 			"id":          user.ID,
 			"phone":       user.Phone,
+			"username":    user.Username,
 			"displayName": user.DisplayName,
+			"avatarId":    user.AvatarID,
 			"createdAt":   user.CreatedAt,
 		})
 	})
 
+	registerRealtimeRoutes(authGroup, hub, presenceSvc)
+	registerChatRoutes(authGroup, chatRepo, hub)
+	registerMessageRoutes(authGroup, msgSvc)
+	registerPinRoutes(authGroup, msgSvc)
+	registerInviteRoutes(authGroup, chatRepo, hub)
+	registerMemberRoutes(authGroup, chatRepo, hub)
+	registerChannelRoutes(authGroup, chatRepo, msgSvc)
+	registerScheduledRoutes(authGroup, msgSvc)
+	registerPollRoutes(authGroup, msgSvc)
+	registerMentionRoutes(authGroup, msgSvc, userRepo)
+	registerSettingsRoutes(authGroup, userRepo)
+	registerSavedRoutes(authGroup, chatRepo, msgSvc)
+	registerSearchRoutes(authGroup, userRepo, chatRepo, msgSvc)
+	registerKeyRoutes(authGroup, keySvc, chatRepo, hub, limiter)
+	registerSenderKeyRoutes(authGroup, msgSvc)
+	registerMLSRoutes(authGroup, mlsSvc, limiter)
+	registerTransparencyRoutes(authGroup, ktSvc)
+	registerDeviceRoutes(authGroup, userRepo, chatRepo, hub)
+	registerAttachmentRoutes(authGroup, attSvc, mediaSigner)
+	registerAvatarRoutes(authGroup, imgSvc, chatRepo, hub)
+
 	if err := r.Run(":" + cfg.Port); err != nil {
 		log.Fatalf("server stopped: %v", err)
 	}
@@ -274,6 +360,20 @@ func authMiddleware(authSvc *auth.Service) gin.HandlerFunc {
 	}
 }
 
+// identity extracts the authenticated user and device from the access token claims.
+func identity(c *gin.Context) (userID, deviceID uuid.UUID, ok bool) {
+	claims := c.MustGet(claimsKey).(*auth.Claims)
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, false
+	}
+	deviceID, err = uuid.Parse(claims.DeviceID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, deviceID, true
+}
+
 func optionalString(v string) *string {
 	trimmed := strings.TrimSpace(v)
 	if trimmed == "" {
@@ -289,3 +389,24 @@ func parseSessionID(refresh string) (uuid.UUID, error) {
 	}
 	return uuid.Parse(parts[0])
 }
+
+func newBlobStore(cfg config.Config) blob.Store {
+	if cfg.BlobBackend == "s3" {
+		return blob.NewS3(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey)
+	}
+	return blob.NewFS(cfg.BlobDir)
+}
+
+func newPushSender(cfg config.Config) push.Sender {
+	if cfg.PushSender == "log" {
+		return push.LogSender{}
+	}
+	return push.Noop{}
+}
+
+func newScanner(cfg config.Config) scan.Scanner {
+	if cfg.Scanner == "clamav" {
+		return scan.NewClamAV(cfg.ClamAVAddr, cfg.ClamAVTimeout)
+	}
+	return scan.Noop{}
+}
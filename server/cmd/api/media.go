@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/attachments"
+)
+
+// Media routes serve attachments to holders of a signed URL, without a
+// bearer token, so that a CDN can sit in front of them. Ranges, ETags and
+// conditional requests are handled by http.ServeContent.
+func registerMediaRoutes(g *gin.RouterGroup, attSvc *attachments.Service, signer *attachments.Signer) {
+	serve := func(c *gin.Context) {
+		id, err := uuid.Parse(c.Param("attachmentId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+			return
+		}
+		exp, err := strconv.ParseInt(c.Query("exp"), 10, 64)
+		now := time.Now()
+		if err != nil || !signer.Verify(id, exp, c.Query("sig"), now) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid_signature"})
+			return
+		}
+
+		m, err := attSvc.Open(c.Request.Context(), id)
+		if err != nil {
+			respondAttachmentError(c, err, "download")
+			return
+		}
+		defer m.Content.Close()
+
+		// The stored bytes never change, so caches may keep them for as long
+		// as the URL is valid.
+		c.Header("ETag", `"`+hex.EncodeToString(m.Digest)+`"`)
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Cache-Control", "public, max-age="+strconv.FormatInt(exp-now.Unix(), 10)+", immutable")
+		http.ServeContent(c.Writer, c.Request, "", m.ModifiedAt, m.Content)
+	}
+	g.GET("/media/:attachmentId", serve)
+	g.HEAD("/media/:attachmentId", serve)
+}
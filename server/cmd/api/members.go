@@ -0,0 +1,218 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/realtime"
+)
+
+func registerMemberRoutes(g *gin.RouterGroup, chatRepo *chats.Repository, hub *realtime.Hub) {
+	g.GET("/chats/:chatId/members", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		members, err := chatRepo.Members(c.Request.Context(), chatID, userID)
+		if err != nil {
+			respondChatError(c, err, "members")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"members": members})
+	})
+
+	g.PATCH("/chats/:chatId", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Title string `json:"title"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Title) > 128 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		title := optionalString(req.Title)
+		if err := chatRepo.UpdateInfo(ctx, chatID, userID, title); err != nil {
+			respondChatError(c, err, "chat_update")
+			return
+		}
+		notifyChat(ctx, chatRepo, hub, chatID, "chat.info", gin.H{"chatId": chatID, "title": title, "changedBy": userID})
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.PUT("/chats/:chatId/members/:userId/role", func(c *gin.Context) {
+		ownerID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, targetID, ok := chatAndUserParams(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Role        chats.Role `json:"role"`
+			Permissions []string   `json:"permissions"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || !req.Role.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		perms, err := chats.ParsePermissions(req.Permissions)
+		if err != nil {
+			respondChatError(c, err, "member_role")
+			return
+		}
+
+		ctx := c.Request.Context()
+		if err := chatRepo.SetRole(ctx, chatID, ownerID, targetID, req.Role, perms); err != nil {
+			respondChatError(c, err, "member_role")
+			return
+		}
+		notifyChat(ctx, chatRepo, hub, chatID, "chat.member_role", gin.H{
+			"chatId":      chatID,
+			"userId":      targetID,
+			"role":        req.Role,
+			"permissions": perms.Names(),
+		})
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.PUT("/chats/:chatId/members/:userId/restriction", func(c *gin.Context) {
+		adminID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, targetID, ok := chatAndUserParams(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Until *time.Time `json:"until"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		if err := chatRepo.Restrict(ctx, chatID, adminID, targetID, req.Until); err != nil {
+			respondChatError(c, err, "member_restrict")
+			return
+		}
+		notifyChat(ctx, chatRepo, hub, chatID, "chat.member_restricted", gin.H{"chatId": chatID, "userId": targetID, "until": req.Until})
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.POST("/chats/:chatId/members/:userId/ban", func(c *gin.Context) {
+		adminID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, targetID, ok := chatAndUserParams(c)
+		if !ok {
+			return
+		}
+
+		var req struct {
+			Until *time.Time `json:"until"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		if err := chatRepo.BanMember(ctx, chatID, adminID, targetID, req.Until); err != nil {
+			respondChatError(c, err, "member_ban")
+			return
+		}
+		data := gin.H{"chatId": chatID, "userId": targetID, "until": req.Until}
+		notifyChat(ctx, chatRepo, hub, chatID, "chat.member_banned", data)
+		if err := hub.PublishToUsers(ctx, []uuid.UUID{targetID}, "chat.member_banned", data); err != nil {
+			log.Printf("chat event chat.member_banned failed: %v", err)
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.GET("/chats/:chatId/bans", func(c *gin.Context) {
+		adminID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		bans, err := chatRepo.Bans(c.Request.Context(), chatID, adminID)
+		if err != nil {
+			respondChatError(c, err, "bans")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"bans": bans})
+	})
+
+	g.DELETE("/chats/:chatId/bans/:userId", func(c *gin.Context) {
+		adminID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, targetID, ok := chatAndUserParams(c)
+		if !ok {
+			return
+		}
+
+		if err := chatRepo.Unban(c.Request.Context(), chatID, adminID, targetID); err != nil {
+			respondChatError(c, err, "unban")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
+
+// chatAndUserParams parses the :chatId and :userId path parameters, writing
+// a 400 response when either is malformed.
+func chatAndUserParams(c *gin.Context) (chatID, userID uuid.UUID, ok bool) {
+	chatID, err := uuid.Parse(c.Param("chatId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err = uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return chatID, userID, true
+}
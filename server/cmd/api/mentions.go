@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/messages"
+	"github.com/kentapp/kent/server/internal/users"
+)
+
+const maxMentionReadBatch = 200
+
+func registerMentionRoutes(g *gin.RouterGroup, msgSvc *messages.Service, userRepo *users.Repository) {
+	g.GET("/chats/:chatId/mentions/next", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		var after *uuid.UUID
+		if raw := c.Query("after"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+			after = &id
+		}
+
+		msg, unread, err := msgSvc.NextMention(c.Request.Context(), userID, chatID, after)
+		if err != nil {
+			respondMessageError(c, err, "mention")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": msg, "unreadMentions": unread})
+	})
+
+	g.POST("/chats/:chatId/mentions/read", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			MessageIDs []uuid.UUID `json:"messageIds"`
+			UpTo       *uuid.UUID  `json:"upTo"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.MessageIDs) > maxMentionReadBatch || (req.UpTo == nil && len(req.MessageIDs) == 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		unread, err := msgSvc.ReadMentions(c.Request.Context(), userID, chatID, req.MessageIDs, req.UpTo)
+		if err != nil {
+			respondMessageError(c, err, "mentions_read")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"unreadMentions": unread})
+	})
+
+	g.GET("/users/me/notifications", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		settings, err := userRepo.NotificationSettings(c.Request.Context(), userID)
+		if err != nil {
+			log.Printf("notification settings failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "notification_settings_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	})
+
+	g.PUT("/users/me/notifications", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req users.NotificationSettings
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := userRepo.SetNotificationSettings(c.Request.Context(), userID, req); err != nil {
+			log.Printf("notification settings update failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "notification_settings_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+}
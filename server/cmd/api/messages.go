@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/messages"
+)
+
+func registerMessageRoutes(g *gin.RouterGroup, msgSvc *messages.Service) {
+	g.GET("/chats/:chatId/messages", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		q, err := parseHistoryQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		list, err := msgSvc.History(c.Request.Context(), userID, chatID, q)
+		if err != nil {
+			respondMessageError(c, err, "history")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"messages": list})
+	})
+
+	g.POST("/chats/:chatId/messages", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Ciphertext   []byte          `json:"ciphertext"`
+			Body         *string         `json:"body"`
+			Headers      json.RawMessage `json:"headers"`
+			ReplyToID    *uuid.UUID      `json:"replyToId"`
+			ThreadRootID *uuid.UUID      `json:"threadRootId"`
+			Mentions     []uuid.UUID     `json:"mentions"`
+			TTLSeconds   int             `json:"ttlSeconds"`
+			// SenderKeyEpoch and Envelopes are set for group messages
+			// encrypted with the sender's group sender key.
+			SenderKeyEpoch *int                `json:"senderKeyEpoch"`
+			Envelopes      []messages.Envelope `json:"envelopes"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		msg, err := msgSvc.Send(c.Request.Context(), userID, deviceID, chatID, messages.NewMessage{
+			Ciphertext:     req.Ciphertext,
+			Body:           req.Body,
+			Headers:        req.Headers,
+			ReplyToID:      req.ReplyToID,
+			ThreadRootID:   req.ThreadRootID,
+			Mentions:       req.Mentions,
+			TTL:            time.Duration(req.TTLSeconds) * time.Second,
+			SenderKeyEpoch: req.SenderKeyEpoch,
+			Envelopes:      req.Envelopes,
+		})
+		if err != nil {
+			respondMessageError(c, err, "send")
+			return
+		}
+		c.JSON(http.StatusOK, msg)
+	})
+
+	g.POST("/messages/forward", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			Forwards []messages.ForwardItem `json:"forwards"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Forwards) == 0 || len(req.Forwards) > messages.MaxForwardBatch {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		list, err := msgSvc.Forward(c.Request.Context(), userID, deviceID, req.Forwards)
+		if err != nil {
+			respondMessageError(c, err, "forward")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"messages": list})
+	})
+
+	g.PATCH("/messages/:messageId", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		messageID, err := uuid.Parse(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Ciphertext     []byte          `json:"ciphertext"`
+			Body           *string         `json:"body"`
+			Headers        json.RawMessage `json:"headers"`
+			SenderKeyEpoch *int            `json:"senderKeyEpoch"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		msg, err := msgSvc.Edit(c.Request.Context(), userID, messageID, messages.NewMessage{
+			Ciphertext:     req.Ciphertext,
+			Body:           req.Body,
+			Headers:        req.Headers,
+			SenderKeyEpoch: req.SenderKeyEpoch,
+		})
+		if err != nil {
+			respondMessageError(c, err, "edit")
+			return
+		}
+		c.JSON(http.StatusOK, msg)
+	})
+
+	g.DELETE("/messages/:messageId", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		messageID, err := uuid.Parse(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		msg, err := msgSvc.DeleteForEveryone(c.Request.Context(), userID, messageID)
+		if err != nil {
+			respondMessageError(c, err, "delete")
+			return
+		}
+		c.JSON(http.StatusOK, msg)
+	})
+
+	g.POST("/messages/:messageId/hide", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		messageID, err := uuid.Parse(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := msgSvc.HideForMe(c.Request.Context(), userID, messageID); err != nil {
+			respondMessageError(c, err, "hide")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	g.GET("/messages/:messageId/thread", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		rootID, err := uuid.Parse(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		q, err := parseHistoryQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		list, err := msgSvc.Thread(c.Request.Context(), userID, rootID, q)
+		if err != nil {
+			respondMessageError(c, err, "thread")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"messages": list})
+	})
+
+	g.PUT("/messages/:messageId/reaction", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		messageID, err := uuid.Parse(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Emoji string `json:"emoji"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.Emoji == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := msgSvc.React(c.Request.Context(), userID, messageID, req.Emoji); err != nil {
+			respondMessageError(c, err, "reaction")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.DELETE("/messages/:messageId/reaction", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		messageID, err := uuid.Parse(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := msgSvc.React(c.Request.Context(), userID, messageID, ""); err != nil {
+			respondMessageError(c, err, "reaction")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
+
+func registerPinRoutes(g *gin.RouterGroup, msgSvc *messages.Service) {
+	g.GET("/chats/:chatId/pins", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		list, err := msgSvc.Pinned(c.Request.Context(), userID, chatID)
+		if err != nil {
+			respondMessageError(c, err, "pins")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"messages": list})
+	})
+
+	g.PUT("/messages/:messageId/pin", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		messageID, err := uuid.Parse(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := msgSvc.Pin(c.Request.Context(), userID, messageID); err != nil {
+			respondMessageError(c, err, "pin")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	g.DELETE("/messages/:messageId/pin", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		messageID, err := uuid.Parse(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := msgSvc.Unpin(c.Request.Context(), userID, messageID); err != nil {
+			respondMessageError(c, err, "unpin")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
+
+func parseHistoryQuery(c *gin.Context) (messages.HistoryQuery, error) {
+	var q messages.HistoryQuery
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return q, err
+		}
+		q.Limit = n
+	}
+	if raw := c.Query("before"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return q, err
+		}
+		q.Before = &id
+	}
+	if raw := c.Query("after"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return q, err
+		}
+		q.After = &id
+	}
+	if raw := c.Query("updatedSince"); raw != "" {
+		t, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return q, err
+		}
+		q.UpdatedSince = &t
+	}
+	return q, nil
+}
+
+// respondMessageError maps service errors to the API's error codes.
+func respondMessageError(c *gin.Context, err error, op string) {
+	switch {
+	case errors.Is(err, chats.ErrNotParticipant), errors.Is(err, chats.ErrNotAllowed), errors.Is(err, messages.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	case errors.Is(err, chats.ErrRestricted):
+		c.JSON(http.StatusForbidden, gin.H{"error": "restricted"})
+	case errors.Is(err, chats.ErrBanned):
+		c.JSON(http.StatusForbidden, gin.H{"error": "banned"})
+	case errors.Is(err, messages.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+	case errors.Is(err, chats.ErrNotChannel):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_a_channel"})
+	case errors.Is(err, messages.ErrInvalidBody):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+	case errors.Is(err, messages.ErrInvalidReference):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_reference"})
+	case errors.Is(err, messages.ErrInvalidReaction):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_reaction"})
+	case errors.Is(err, messages.ErrInvalidMentions):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_mentions"})
+	case errors.Is(err, messages.ErrInvalidTTL):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_ttl"})
+	case errors.Is(err, messages.ErrInvalidSendAt):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_send_at"})
+	case errors.Is(err, messages.ErrTooManyScheduled):
+		c.JSON(http.StatusConflict, gin.H{"error": "too_many_scheduled"})
+	case errors.Is(err, messages.ErrNotSaved):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not_saved"})
+	case errors.Is(err, messages.ErrInvalidTag):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_tag"})
+	case errors.Is(err, messages.ErrInvalidPoll):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_poll"})
+	case errors.Is(err, messages.ErrPollClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": "poll_closed"})
+	case errors.Is(err, messages.ErrAlreadyVoted):
+		c.JSON(http.StatusConflict, gin.H{"error": "already_voted"})
+	case errors.Is(err, messages.ErrNotVoted):
+		c.JSON(http.StatusConflict, gin.H{"error": "not_voted"})
+	case errors.Is(err, messages.ErrVoteFinal):
+		c.JSON(http.StatusConflict, gin.H{"error": "vote_final"})
+	case errors.Is(err, messages.ErrPollAnonymous):
+		c.JSON(http.StatusForbidden, gin.H{"error": "poll_anonymous"})
+	case errors.Is(err, messages.ErrInvalidAttachments):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_attachments"})
+	case errors.Is(err, messages.ErrInvalidEnvelopes):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_envelopes"})
+	case errors.Is(err, messages.ErrStaleSenderKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "sender_key_stale"})
+	case errors.Is(err, messages.ErrEditWindowExpired):
+		c.JSON(http.StatusConflict, gin.H{"error": "edit_window_expired"})
+	case errors.Is(err, messages.ErrDeleted):
+		c.JSON(http.StatusGone, gin.H{"error": "message_deleted"})
+	default:
+		log.Printf("message %s failed: %v", op, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "message_" + op + "_failed"})
+	}
+}
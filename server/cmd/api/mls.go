@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/mls"
+	"github.com/kentapp/kent/server/internal/ratelimit"
+)
+
+// MLS routes make the API an MLS Delivery Service for groups: KeyPackage
+// directory, per-epoch handshake ordering and Welcome delivery.
+func registerMLSRoutes(g *gin.RouterGroup, mlsSvc *mls.Service, limiter *ratelimit.Limiter) {
+	g.POST("/mls/key-packages", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			KeyPackages [][]byte `json:"keyPackages"`
+			LastResort  []byte   `json:"lastResort"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		count, err := mlsSvc.AddKeyPackages(c.Request.Context(), userID, deviceID, req.KeyPackages, req.LastResort)
+		if err != nil {
+			respondMLSError(c, err, "key_packages")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"count": count})
+	})
+
+	g.GET("/mls/key-packages/count", func(c *gin.Context) {
+		_, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		count, err := mlsSvc.KeyPackageCount(c.Request.Context(), deviceID)
+		if err != nil {
+			respondMLSError(c, err, "key_packages")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"count": count, "low": count < mls.LowKeyPackageThreshold})
+	})
+
+	g.GET("/users/:userId/key-packages", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		targetID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		var targetDevice *uuid.UUID
+		if raw := c.Query("deviceId"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+			targetDevice = &id
+		}
+		if !allowKeyFetch(c, limiter, "keypackages", userID, targetID) {
+			return
+		}
+
+		list, err := mlsSvc.ClaimKeyPackages(c.Request.Context(), deviceID, targetID, targetDevice)
+		if err != nil {
+			respondMLSError(c, err, "key_packages")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"keyPackages": list})
+	})
+
+	g.POST("/chats/:chatId/mls/messages", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req mls.NewHandshake
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		h, err := mlsSvc.Submit(c.Request.Context(), userID, deviceID, chatID, req)
+		if err != nil {
+			respondMLSError(c, err, "handshake")
+			return
+		}
+		c.JSON(http.StatusOK, h)
+	})
+
+	g.GET("/chats/:chatId/mls/messages", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		var afterSeq int64
+		if raw := c.Query("afterSeq"); raw != "" {
+			if afterSeq, err = strconv.ParseInt(raw, 10, 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+		}
+		limit := 0
+		if raw := c.Query("limit"); raw != "" {
+			if limit, err = strconv.Atoi(raw); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+		}
+
+		list, epoch, err := mlsSvc.Handshakes(c.Request.Context(), userID, chatID, afterSeq, limit)
+		if err != nil {
+			respondMLSError(c, err, "handshake")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"epoch": epoch, "messages": list})
+	})
+
+	g.GET("/mls/welcomes", func(c *gin.Context) {
+		_, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		list, err := mlsSvc.PendingWelcomes(c.Request.Context(), deviceID)
+		if err != nil {
+			respondMLSError(c, err, "welcome")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"welcomes": list})
+	})
+
+	g.POST("/mls/welcomes/ack", func(c *gin.Context) {
+		_, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			IDs []uuid.UUID `json:"ids"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 || len(req.IDs) > mls.MaxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := mlsSvc.AckWelcomes(c.Request.Context(), deviceID, req.IDs); err != nil {
+			respondMLSError(c, err, "welcome")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
+
+// respondMLSError maps delivery service errors to the API's error codes.
+func respondMLSError(c *gin.Context, err error, op string) {
+	switch {
+	case errors.Is(err, chats.ErrNotParticipant), errors.Is(err, chats.ErrNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+	case errors.Is(err, chats.ErrRestricted):
+		c.JSON(http.StatusForbidden, gin.H{"error": "restricted"})
+	case errors.Is(err, chats.ErrBanned):
+		c.JSON(http.StatusForbidden, gin.H{"error": "banned"})
+	case errors.Is(err, mls.ErrNotGroup):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "not_a_group"})
+	case errors.Is(err, mls.ErrInvalidKeyPackage):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_key_package"})
+	case errors.Is(err, mls.ErrTooManyPackages):
+		c.JSON(http.StatusConflict, gin.H{"error": "too_many_key_packages"})
+	case errors.Is(err, mls.ErrNoKeyPackages):
+		c.JSON(http.StatusNotFound, gin.H{"error": "no_key_packages"})
+	case errors.Is(err, mls.ErrInvalidHandshake):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_handshake"})
+	case errors.Is(err, mls.ErrInvalidWelcome):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_welcome"})
+	case errors.Is(err, mls.ErrStaleEpoch):
+		c.JSON(http.StatusConflict, gin.H{"error": "stale_epoch"})
+	default:
+		log.Printf("mls %s failed: %v", op, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": op + "_failed"})
+	}
+}
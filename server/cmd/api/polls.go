@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/messages"
+)
+
+func registerPollRoutes(g *gin.RouterGroup, msgSvc *messages.Service) {
+	g.POST("/chats/:chatId/polls", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req messages.NewPoll
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		poll, err := msgSvc.CreatePoll(c.Request.Context(), userID, deviceID, chatID, req)
+		if err != nil {
+			respondMessageError(c, err, "poll_create")
+			return
+		}
+		c.JSON(http.StatusOK, poll)
+	})
+
+	g.GET("/polls/:pollId", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		pollID, err := uuid.Parse(c.Param("pollId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		poll, err := msgSvc.Poll(c.Request.Context(), userID, pollID)
+		if err != nil {
+			respondMessageError(c, err, "poll")
+			return
+		}
+		c.JSON(http.StatusOK, poll)
+	})
+
+	g.PUT("/polls/:pollId/vote", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		pollID, err := uuid.Parse(c.Param("pollId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Options []int `json:"options"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.Options) > messages.MaxPollOptions {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		poll, err := msgSvc.Vote(c.Request.Context(), userID, pollID, req.Options)
+		if err != nil {
+			respondMessageError(c, err, "vote")
+			return
+		}
+		c.JSON(http.StatusOK, poll)
+	})
+
+	g.DELETE("/polls/:pollId/vote", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		pollID, err := uuid.Parse(c.Param("pollId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		poll, err := msgSvc.RetractVote(c.Request.Context(), userID, pollID)
+		if err != nil {
+			respondMessageError(c, err, "vote_retract")
+			return
+		}
+		c.JSON(http.StatusOK, poll)
+	})
+
+	g.POST("/polls/:pollId/close", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		pollID, err := uuid.Parse(c.Param("pollId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		poll, err := msgSvc.ClosePoll(c.Request.Context(), userID, pollID)
+		if err != nil {
+			respondMessageError(c, err, "poll_close")
+			return
+		}
+		c.JSON(http.StatusOK, poll)
+	})
+
+	g.GET("/polls/:pollId/voters", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		pollID, err := uuid.Parse(c.Param("pollId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		option, err := strconv.Atoi(c.Query("option"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		voters, err := msgSvc.PollVoters(c.Request.Context(), userID, pollID, option)
+		if err != nil {
+			respondMessageError(c, err, "poll_voters")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"userIds": voters})
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/ratelimit"
+)
+
+// Fetching another user's keys uses up one of their one-time keys, so it is
+// limited both in total and per target user. It is not restricted to
+// contacts, since the first message to a new user needs their keys.
+var (
+	keyFetchPerCaller = ratelimit.Limit{N: 200, Window: time.Hour}
+	keyFetchPerTarget = ratelimit.Limit{N: 10, Window: time.Hour}
+)
+
+// allowKeyFetch charges a fetch of targetID's keys of the given kind to the
+// caller, responding 429 when either limit is spent. A Redis failure lets
+// the fetch through so that session setup survives an outage.
+func allowKeyFetch(c *gin.Context, limiter *ratelimit.Limiter, kind string, callerID, targetID uuid.UUID) bool {
+	checks := []struct {
+		key   string
+		limit ratelimit.Limit
+	}{
+		{kind + ":" + callerID.String() + ":" + targetID.String(), keyFetchPerTarget},
+		{kind + ":" + callerID.String(), keyFetchPerCaller},
+	}
+	for _, chk := range checks {
+		ok, retryAfter, err := limiter.Allow(c.Request.Context(), chk.key, chk.limit)
+		if err != nil {
+			log.Printf("rate limit check failed: %v", err)
+			return true
+		}
+		if !ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate_limited"})
+			return false
+		}
+	}
+	return true
+}
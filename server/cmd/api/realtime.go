@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/presence"
+	"github.com/kentapp/kent/server/internal/realtime"
+)
+
+const maxPresenceLookup = 200
+
+func registerRealtimeRoutes(g *gin.RouterGroup, hub *realtime.Hub, presenceSvc *presence.Service) {
+	g.GET("/events", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		sub := hub.Subscribe(ctx, userID, deviceID)
+		defer sub.Close()
+		if _, err := sub.Receive(ctx); err != nil {
+			log.Printf("realtime subscribe failed: %v", err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "realtime_unavailable"})
+			return
+		}
+
+		if err := presenceSvc.Heartbeat(ctx, userID, deviceID); err != nil {
+			log.Printf("presence heartbeat failed: %v", err)
+		}
+		defer func() {
+			dctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := presenceSvc.Disconnect(dctx, userID, deviceID); err != nil {
+				log.Printf("presence disconnect failed: %v", err)
+			}
+		}()
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+
+		msgs := sub.Channel()
+		ticker := time.NewTicker(presenceSvc.TTL() / 2)
+		defer ticker.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-ctx.Done():
+				return false
+			case msg, ok := <-msgs:
+				if !ok {
+					return false
+				}
+				c.SSEvent("message", msg.Payload)
+			case <-ticker.C:
+				if err := presenceSvc.Heartbeat(ctx, userID, deviceID); err != nil {
+					log.Printf("presence heartbeat failed: %v", err)
+				}
+				c.SSEvent("ping", "")
+			}
+			return true
+		})
+	})
+
+	g.GET("/presence", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		ids, err := parseUUIDList(c.Query("userIds"), maxPresenceLookup)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		statuses, err := presenceSvc.Lookup(c.Request.Context(), userID, ids)
+		if err != nil {
+			log.Printf("presence lookup failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "presence_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"presence": statuses})
+	})
+
+	g.POST("/chats/:chatId/activity", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Activity string `json:"activity"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		err = presenceSvc.SetActivity(c.Request.Context(), userID, chatID, strings.TrimSpace(req.Activity))
+		switch {
+		case errors.Is(err, presence.ErrInvalidActivity):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_activity"})
+		case errors.Is(err, chats.ErrNotParticipant), errors.Is(err, chats.ErrRestricted), errors.Is(err, chats.ErrBanned):
+			c.JSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+		case err != nil:
+			log.Printf("chat activity failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "activity_failed"})
+		default:
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+		}
+	})
+}
+
+func parseUUIDList(raw string, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := uuid.Parse(part)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 || len(ids) > limit {
+		return nil, errors.New("invalid id list")
+	}
+	return ids, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/messages"
+)
+
+// Saved messages is an ordinary chat of kind "self": notes are sent and
+// edited through the message routes using the chat ID returned here.
+func registerSavedRoutes(g *gin.RouterGroup, chatRepo *chats.Repository, msgSvc *messages.Service) {
+	g.GET("/saved", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		chat, err := chatRepo.SelfChat(c.Request.Context(), userID)
+		if err != nil {
+			log.Printf("saved chat failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "saved_chat_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, chat)
+	})
+
+	g.GET("/saved/messages", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		q, err := parseHistoryQuery(c)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		q.Tag = c.Query("tag")
+
+		ctx := c.Request.Context()
+		chat, err := chatRepo.SelfChat(ctx, userID)
+		if err != nil {
+			log.Printf("saved chat failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "saved_chat_failed"})
+			return
+		}
+		list, err := msgSvc.History(ctx, userID, chat.ID, q)
+		if err != nil {
+			respondMessageError(c, err, "history")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"chatId": chat.ID, "messages": list})
+	})
+
+	g.PUT("/saved/messages/:messageId/tags", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		messageID, err := uuid.Parse(c.Param("messageId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Tags []string `json:"tags"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		tags, err := msgSvc.SetTags(c.Request.Context(), userID, messageID, req.Tags)
+		if err != nil {
+			respondMessageError(c, err, "tags")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tags": tags})
+	})
+
+	g.GET("/saved/tags", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		tags, err := msgSvc.SavedTags(c.Request.Context(), userID)
+		if err != nil {
+			respondMessageError(c, err, "tags")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tags": tags})
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/messages"
+)
+
+func registerScheduledRoutes(g *gin.RouterGroup, msgSvc *messages.Service) {
+	g.POST("/chats/:chatId/scheduled", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Ciphertext   []byte          `json:"ciphertext"`
+			Body         *string         `json:"body"`
+			Headers      json.RawMessage `json:"headers"`
+			ReplyToID    *uuid.UUID      `json:"replyToId"`
+			ThreadRootID *uuid.UUID      `json:"threadRootId"`
+			Mentions     []uuid.UUID     `json:"mentions"`
+			TTLSeconds   int             `json:"ttlSeconds"`
+			SendAt       time.Time       `json:"sendAt"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		sm, err := msgSvc.Schedule(c.Request.Context(), userID, deviceID, chatID, messages.NewMessage{
+			Ciphertext:   req.Ciphertext,
+			Body:         req.Body,
+			Headers:      req.Headers,
+			ReplyToID:    req.ReplyToID,
+			ThreadRootID: req.ThreadRootID,
+			Mentions:     req.Mentions,
+			TTL:          time.Duration(req.TTLSeconds) * time.Second,
+		}, req.SendAt)
+		if err != nil {
+			respondMessageError(c, err, "schedule")
+			return
+		}
+		c.JSON(http.StatusOK, sm)
+	})
+
+	g.GET("/chats/:chatId/scheduled", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		list, err := msgSvc.ListScheduled(c.Request.Context(), userID, chatID)
+		if err != nil {
+			respondMessageError(c, err, "scheduled_list")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"scheduled": list})
+	})
+
+	g.PATCH("/scheduled/:scheduledId", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		scheduledID, err := uuid.Parse(c.Param("scheduledId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Ciphertext []byte          `json:"ciphertext"`
+			Body       *string         `json:"body"`
+			Headers    json.RawMessage `json:"headers"`
+			Mentions   []uuid.UUID     `json:"mentions"`
+			SendAt     *time.Time      `json:"sendAt"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		sm, err := msgSvc.EditScheduled(c.Request.Context(), userID, scheduledID, messages.NewMessage{
+			Ciphertext: req.Ciphertext,
+			Body:       req.Body,
+			Headers:    req.Headers,
+			Mentions:   req.Mentions,
+		}, req.SendAt)
+		if err != nil {
+			respondMessageError(c, err, "schedule_edit")
+			return
+		}
+		c.JSON(http.StatusOK, sm)
+	})
+
+	g.DELETE("/scheduled/:scheduledId", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		scheduledID, err := uuid.Parse(c.Param("scheduledId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := msgSvc.CancelScheduled(c.Request.Context(), userID, scheduledID); err != nil {
+			respondMessageError(c, err, "schedule_cancel")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
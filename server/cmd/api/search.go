@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/messages"
+	"github.com/kentapp/kent/server/internal/users"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	minSearchLength    = 2
+	maxSearchLength    = 100
+)
+
+// Search covers what the server can read: usernames, display names of known
+// contacts, chat titles and channel posts. E2EE message bodies are searched
+// on the device.
+func registerSearchRoutes(g *gin.RouterGroup, userRepo *users.Repository, chatRepo *chats.Repository, msgSvc *messages.Service) {
+	g.GET("/search", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		text := strings.TrimSpace(c.Query("q"))
+		if n := utf8.RuneCountInString(text); n < minSearchLength || n > maxSearchLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_query"})
+			return
+		}
+		limit, offset := defaultSearchLimit, 0
+		if raw := c.Query("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 || n > maxSearchLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+			limit = n
+		}
+		if raw := c.Query("offset"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+			offset = n
+		}
+		var chatID *uuid.UUID
+		if raw := c.Query("chatId"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+			chatID = &id
+		}
+
+		scope := c.DefaultQuery("type", "all")
+		if chatID != nil {
+			scope = "posts"
+		}
+		switch scope {
+		case "all", "users", "chats", "posts":
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		resp := gin.H{}
+		if scope == "all" || scope == "users" {
+			list, err := userRepo.Search(ctx, userID, text, limit, offset)
+			if err != nil {
+				log.Printf("user search failed: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "search_failed"})
+				return
+			}
+			resp["users"] = list
+		}
+		if scope == "all" || scope == "chats" {
+			list, err := chatRepo.Search(ctx, userID, text, limit, offset)
+			if err != nil {
+				respondChatError(c, err, "search")
+				return
+			}
+			resp["chats"] = list
+		}
+		if scope == "all" || scope == "posts" {
+			list, err := msgSvc.SearchPosts(ctx, userID, chatID, text, limit, offset)
+			if err != nil {
+				respondMessageError(c, err, "search")
+				return
+			}
+			resp["posts"] = list
+		}
+		c.JSON(http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/messages"
+)
+
+// Sender-key routes distribute group sender keys. Envelopes are queued per
+// recipient device until that device acknowledges them.
+func registerSenderKeyRoutes(g *gin.RouterGroup, msgSvc *messages.Service) {
+	g.GET("/chats/:chatId/sender-keys/devices", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		epoch, devices, err := msgSvc.SenderKeyDevices(c.Request.Context(), userID, chatID)
+		if err != nil {
+			respondMessageError(c, err, "sender_key")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"epoch": epoch, "devices": devices})
+	})
+
+	g.POST("/chats/:chatId/sender-keys", func(c *gin.Context) {
+		userID, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		chatID, err := uuid.Parse(c.Param("chatId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		var req struct {
+			Epoch     int                 `json:"epoch"`
+			Envelopes []messages.Envelope `json:"envelopes"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		missing, err := msgSvc.DistributeSenderKey(c.Request.Context(), userID, deviceID, chatID, req.Epoch, req.Envelopes)
+		if err != nil {
+			respondMessageError(c, err, "sender_key")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"missing": missing})
+	})
+
+	g.GET("/keys/sender-keys", func(c *gin.Context) {
+		_, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		limit := 0
+		if raw := c.Query("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+				return
+			}
+			limit = n
+		}
+
+		list, err := msgSvc.PendingSenderKeys(c.Request.Context(), deviceID, limit)
+		if err != nil {
+			respondMessageError(c, err, "sender_key")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"senderKeys": list})
+	})
+
+	g.POST("/keys/sender-keys/ack", func(c *gin.Context) {
+		_, deviceID, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			IDs []uuid.UUID `json:"ids"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 || len(req.IDs) > messages.MaxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := msgSvc.AckSenderKeys(c.Request.Context(), deviceID, req.IDs); err != nil {
+			respondMessageError(c, err, "sender_key")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/kentapp/kent/server/internal/users"
+)
+
+func registerSettingsRoutes(g *gin.RouterGroup, userRepo *users.Repository) {
+	g.GET("/users/me/privacy", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		settings, err := userRepo.PrivacySettings(c.Request.Context(), userID)
+		if err != nil {
+			log.Printf("privacy settings failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "privacy_settings_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, settings)
+	})
+
+	g.PUT("/users/me/privacy", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req users.PrivacySettings
+		if err := c.ShouldBindJSON(&req); err != nil || !req.ForwardAttribution.Valid() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		if err := userRepo.SetPrivacySettings(c.Request.Context(), userID, req); err != nil {
+			log.Printf("privacy settings update failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "privacy_settings_failed"})
+			return
+		}
+		c.JSON(http.StatusOK, req)
+	})
+
+	g.PUT("/users/me/username", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+
+		var req struct {
+			Username string `json:"username"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		username, err := userRepo.SetUsername(c.Request.Context(), userID, req.Username)
+		switch {
+		case errors.Is(err, users.ErrInvalidUsername):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_username"})
+		case errors.Is(err, users.ErrUsernameTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": "username_taken"})
+		case err != nil:
+			log.Printf("username update failed: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "username_update_failed"})
+		default:
+			c.JSON(http.StatusOK, gin.H{"username": username})
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/transparency"
+)
+
+// Transparency routes let clients check that the identity keys they are
+// served are the ones logged, and that the log only ever grows.
+func registerTransparencyRoutes(g *gin.RouterGroup, ktSvc *transparency.Service) {
+	g.GET("/transparency/public-key", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"publicKey": []byte(ktSvc.PublicKey())})
+	})
+
+	g.GET("/transparency/head", func(c *gin.Context) {
+		head, err := ktSvc.Head(c.Request.Context())
+		if err != nil {
+			respondTransparencyError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, head)
+	})
+
+	g.GET("/users/:userId/devices/:deviceId/transparency", func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		deviceID, err := uuid.Parse(c.Param("deviceId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+		treeSize, ok := int64Query(c, "treeSize", 0)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		entry, head, err := ktSvc.Inclusion(c.Request.Context(), userID, deviceID, treeSize)
+		if err != nil {
+			respondTransparencyError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"entry": entry, "head": head})
+	})
+
+	g.GET("/transparency/consistency", func(c *gin.Context) {
+		from, okFrom := int64Query(c, "from", 0)
+		to, okTo := int64Query(c, "to", 0)
+		if !okFrom || !okTo {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		proof, head, err := ktSvc.Consistency(c.Request.Context(), from, to)
+		if err != nil {
+			respondTransparencyError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"proof": proof, "head": head})
+	})
+
+	g.GET("/users/:userId/transparency", func(c *gin.Context) {
+		userID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		entries, head, err := ktSvc.Lookup(c.Request.Context(), userID)
+		if err != nil {
+			respondTransparencyError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": entries, "head": head})
+	})
+
+	g.GET("/transparency/audit", func(c *gin.Context) {
+		userID, _, ok := identity(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid_token"})
+			return
+		}
+		after, ok := int64Query(c, "after", -1)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "bad_request"})
+			return
+		}
+
+		entries, head, err := ktSvc.Audit(c.Request.Context(), userID, after)
+		if err != nil {
+			respondTransparencyError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": entries, "head": head})
+	})
+}
+
+func int64Query(c *gin.Context, name string, fallback int64) (int64, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return fallback, true
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	return n, err == nil
+}
+
+func respondTransparencyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, transparency.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+	case errors.Is(err, transparency.ErrInvalidSize):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_tree_size"})
+	default:
+		log.Printf("transparency request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "transparency_failed"})
+	}
+}
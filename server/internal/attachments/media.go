@@ -0,0 +1,93 @@
+package attachments
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kentapp/kent/server/internal/blob"
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/scan"
+)
+
+// Media is a finished attachment opened for download. Content seeks without
+// reading, so ranges are fetched from the store directly.
+type Media struct {
+	ID         uuid.UUID
+	Size       int64
+	Digest     []byte
+	ModifiedAt time.Time
+	Content    io.ReadSeekCloser
+}
+
+// Authorize lets the uploader, and anyone who can read a live message
+// referencing the attachment, download it. Others get ErrNotFound.
+func (s *Service) Authorize(ctx context.Context, userID, id uuid.UUID) error {
+	var owner uuid.UUID
+	err := s.pool.QueryRow(ctx, `SELECT owner_id FROM attachments WHERE id=$1 AND completed_at IS NOT NULL`, id).Scan(&owner)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if owner == userID {
+		return nil
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT DISTINCT m.chat_id FROM message_attachments ma
+      JOIN messages m ON m.id = ma.message_id
+      WHERE ma.attachment_id=$1 AND m.deleted_at IS NULL AND (m.expires_at IS NULL OR m.expires_at > now())`, id)
+	if err != nil {
+		return err
+	}
+	var chatIDs []uuid.UUID
+	for rows.Next() {
+		var chatID uuid.UUID
+		if err := rows.Scan(&chatID); err != nil {
+			rows.Close()
+			return err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, chatID := range chatIDs {
+		_, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, chats.ErrNotParticipant) && !errors.Is(err, chats.ErrNotAllowed) && !errors.Is(err, chats.ErrBanned) {
+			return err
+		}
+	}
+	return ErrNotFound
+}
+
+// Open opens a finished attachment. Callers check access first, or hold a
+// signed URL for it. Public attachments open only once scanned clean.
+func (s *Service) Open(ctx context.Context, id uuid.UUID) (*Media, error) {
+	m := Media{ID: id}
+	var key string
+	var status *scan.Status
+	err := s.pool.QueryRow(ctx, `SELECT size, digest, completed_at, storage_key, scan_status FROM attachments
+      WHERE id=$1 AND completed_at IS NOT NULL`, id).Scan(&m.Size, &m.Digest, &m.ModifiedAt, &key, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != nil && !status.Servable() {
+		return nil, ErrQuarantined
+	}
+	m.Content = blob.NewSeeker(ctx, s.store, key, m.Size)
+	return &m, nil
+}
@@ -0,0 +1,125 @@
+package attachments
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kentapp/kent/server/internal/scan"
+)
+
+// Quarantined is a public attachment the scanner flagged or could not scan.
+type Quarantined struct {
+	ID        uuid.UUID `json:"id"`
+	OwnerID   uuid.UUID `json:"ownerId"`
+	Size      int64     `json:"size"`
+	Reason    *string   `json:"reason,omitempty"`
+	ScannedAt time.Time `json:"scannedAt"`
+}
+
+// RunScanner scans completed public attachments every interval, and as
+// soon as one completes, until ctx is cancelled.
+func (s *Service) RunScanner(ctx context.Context, scanner scan.Scanner, interval time.Duration) {
+	scan.Run(ctx, scanQueue{s}, s.store, scanner, interval, s.scanWake)
+}
+
+func (s *Service) wakeScanner() {
+	select {
+	case s.scanWake <- struct{}{}:
+	default:
+	}
+}
+
+type scanQueue struct{ s *Service }
+
+func (q scanQueue) Claim(ctx context.Context, limit int) ([]scan.Item, error) {
+	rows, err := q.s.pool.Query(ctx, `UPDATE attachments
+      SET scan_attempts = scan_attempts + 1, scan_claimed_until = now() + make_interval(secs => $2)
+      WHERE id IN (
+        SELECT id FROM attachments
+        WHERE scan_status = 'pending' AND completed_at IS NOT NULL
+          AND (scan_claimed_until IS NULL OR scan_claimed_until < now())
+        ORDER BY completed_at
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED)
+      RETURNING id, storage_key, scan_attempts`, limit, scan.ClaimTTL.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []scan.Item
+	for rows.Next() {
+		var id uuid.UUID
+		var item scan.Item
+		if err := rows.Scan(&id, &item.Key, &item.Attempts); err != nil {
+			return nil, err
+		}
+		item.ID = id.String()
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func (q scanQueue) Resolve(ctx context.Context, item scan.Item, status scan.Status, reason string) error {
+	_, err := q.s.pool.Exec(ctx, `UPDATE attachments
+      SET scan_status=$2, scan_result=NULLIF($3, ''), scanned_at=now(), scan_claimed_until=NULL
+      WHERE id=$1 AND scan_status = 'pending'`, item.ID, status, reason)
+	return err
+}
+
+// Quarantined lists quarantined attachments, most recently scanned first.
+func (s *Service) Quarantined(ctx context.Context, limit int) ([]Quarantined, error) {
+	rows, err := s.pool.Query(ctx, `SELECT id, owner_id, size, scan_result, scanned_at FROM attachments
+      WHERE scan_status = 'quarantined'
+      ORDER BY scanned_at DESC
+      LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Quarantined, 0)
+	for rows.Next() {
+		var q Quarantined
+		if err := rows.Scan(&q.ID, &q.OwnerID, &q.Size, &q.Reason, &q.ScannedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// Release clears a quarantined attachment for download.
+func (s *Service) Release(ctx context.Context, id uuid.UUID) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE attachments SET scan_status = 'released'
+      WHERE id=$1 AND scan_status = 'quarantined'`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Remove deletes a quarantined attachment and its blob. Messages keep the
+// reference, which then resolves to nothing.
+func (s *Service) Remove(ctx context.Context, id uuid.UUID) error {
+	var key *string
+	err := s.pool.QueryRow(ctx, `DELETE FROM attachments WHERE id=$1 AND scan_status = 'quarantined'
+      RETURNING storage_key`, id).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		s.deleteBlob(ctx, *key)
+	}
+	return nil
+}
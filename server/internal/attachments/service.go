@@ -0,0 +1,320 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/kentapp/kent/server/internal/blob"
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/scan"
+)
+
+const (
+	MaxSize = 2 << 30
+	// Every chunk but the last must be at least MinChunkSize, which bounds
+	// the number of parts an upload can leave behind.
+	MinChunkSize = 256 << 10
+	MaxChunkSize = 8 << 20
+)
+
+var (
+	ErrNotFound       = errors.New("attachments: upload not found")
+	ErrInvalidSize    = errors.New("attachments: invalid size")
+	ErrInvalidChunk   = errors.New("attachments: chunk size out of range")
+	ErrOffsetMismatch = errors.New("attachments: offset does not match the upload")
+	ErrDigestMismatch = errors.New("attachments: digest does not match the uploaded bytes")
+	ErrCompleted      = errors.New("attachments: upload already completed")
+	ErrQuarantined    = errors.New("attachments: quarantined until scanned clean")
+)
+
+// Attachment is an upload in progress or a stored blob. Digest is the
+// SHA-256 of the uploaded bytes and is set once the upload completes.
+// Public attachments are not encrypted, for channel posts, and are scanned
+// once complete; ScanStatus is only set for them.
+type Attachment struct {
+	ID          uuid.UUID    `json:"id"`
+	Size        int64        `json:"size"`
+	Offset      int64        `json:"offset"`
+	Digest      []byte       `json:"digest,omitempty"`
+	Public      bool         `json:"public"`
+	ScanStatus  *scan.Status `json:"scanStatus,omitempty"`
+	CreatedAt   time.Time    `json:"createdAt"`
+	CompletedAt *time.Time   `json:"completedAt,omitempty"`
+}
+
+// Service takes resumable uploads of client-encrypted attachments. Each
+// chunk is stored as its own part object, so an upload can resume on any
+// API instance; the parts are joined into one object at the end.
+type Service struct {
+	pool     *pgxpool.Pool
+	store    blob.Store
+	chats    *chats.Repository
+	quotas   Quotas
+	scanWake chan struct{}
+}
+
+func NewService(pool *pgxpool.Pool, store blob.Store, chatRepo *chats.Repository, quotas Quotas) *Service {
+	return &Service{pool: pool, store: store, chats: chatRepo, quotas: quotas, scanWake: make(chan struct{}, 1)}
+}
+
+// Create starts an upload of size bytes, which counts against the user's
+// quota from the start. When digest is set the completed upload must match
+// it.
+func (s *Service) Create(ctx context.Context, userID uuid.UUID, size int64, digest []byte, public bool) (a *Attachment, err error) {
+	if size <= 0 || size > MaxSize || (digest != nil && len(digest) != sha256.Size) {
+		return nil, ErrInvalidSize
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	if err = s.reserve(ctx, tx, userID, size); err != nil {
+		return nil, err
+	}
+	row := tx.QueryRow(ctx, `INSERT INTO attachments (owner_id, size, expected_digest, public, scan_status)
+      VALUES ($1, $2, $3, $4, CASE WHEN $4 THEN 'pending' END)
+      RETURNING `+attachmentColumns, userID, size, digest, public)
+	return scanAttachment(row)
+}
+
+// Get returns one of the caller's uploads.
+func (s *Service) Get(ctx context.Context, userID, id uuid.UUID) (*Attachment, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE id=$1 AND owner_id=$2`, id, userID)
+	return scanAttachment(row)
+}
+
+// Append stores the next chunk of an upload, which must start at the
+// upload's current offset. The chunk that completes the upload also joins
+// the parts; if that fails, an empty chunk at the final offset retries it.
+func (s *Service) Append(ctx context.Context, userID, id uuid.UUID, offset int64, r io.Reader, size int64) (*Attachment, error) {
+	a, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if a.CompletedAt != nil {
+		return nil, ErrCompleted
+	}
+	if offset != a.Offset {
+		return nil, ErrOffsetMismatch
+	}
+	if size == 0 && offset == a.Size {
+		return s.complete(ctx, a)
+	}
+	last := offset+size == a.Size
+	if size <= 0 || size > MaxChunkSize || offset+size > a.Size || (size < MinChunkSize && !last) {
+		return nil, ErrInvalidChunk
+	}
+
+	key := "parts/" + uuid.NewString()
+	if err := s.store.Put(ctx, key, r, size); err != nil {
+		if errors.Is(err, blob.ErrSizeMismatch) {
+			return nil, ErrInvalidChunk
+		}
+		return nil, err
+	}
+	if err := s.addPart(ctx, id, offset, size, key); err != nil {
+		s.deleteBlob(ctx, key)
+		return nil, err
+	}
+
+	a.Offset += size
+	if !last {
+		return a, nil
+	}
+	return s.complete(ctx, a)
+}
+
+// addPart records a stored chunk and advances the upload, unless another
+// request got there first.
+func (s *Service) addPart(ctx context.Context, id uuid.UUID, offset, size int64, key string) (err error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	tag, err := tx.Exec(ctx, `UPDATE attachments SET received = received + $3, unreferenced_at = now()
+      WHERE id=$1 AND received=$2 AND completed_at IS NULL`, id, offset, size)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		err = ErrOffsetMismatch
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO attachment_parts (attachment_id, "offset", size, storage_key) VALUES ($1, $2, $3, $4)`,
+		id, offset, size, key)
+	return err
+}
+
+// complete joins the parts into the final object while hashing it, then
+// drops the parts. A digest mismatch discards the whole upload.
+func (s *Service) complete(ctx context.Context, a *Attachment) (*Attachment, error) {
+	parts, err := s.parts(ctx, a.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	key := "attachments/" + a.ID.String()
+	src := &partReader{ctx: ctx, store: s.store, parts: parts}
+	err = s.store.Put(ctx, key, io.TeeReader(src, h), a.Size)
+	src.Close()
+	if err != nil {
+		return nil, err
+	}
+	digest := h.Sum(nil)
+
+	var expected []byte
+	row := s.pool.QueryRow(ctx, `UPDATE attachments SET digest=$2, storage_key=$3, completed_at=now()
+      WHERE id=$1 AND completed_at IS NULL
+      RETURNING expected_digest, `+attachmentColumns, a.ID, digest, key)
+	var done Attachment
+	err = row.Scan(&expected, &done.ID, &done.Size, &done.Offset, &done.Digest, &done.Public, &done.ScanStatus, &done.CreatedAt, &done.CompletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// A concurrent retry completed it first.
+		return s.getByID(ctx, a.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if expected != nil && !bytes.Equal(expected, digest) {
+		if _, err := s.pool.Exec(ctx, `DELETE FROM attachments WHERE id=$1`, a.ID); err != nil {
+			return nil, err
+		}
+		s.deleteBlob(ctx, key)
+		s.dropParts(ctx, parts)
+		return nil, ErrDigestMismatch
+	}
+
+	if _, err := s.pool.Exec(ctx, `DELETE FROM attachment_parts WHERE attachment_id=$1`, a.ID); err != nil {
+		log.Printf("attachment parts cleanup failed: %v", err)
+	}
+	s.dropParts(ctx, parts)
+	if done.Public {
+		s.wakeScanner()
+	}
+	return &done, nil
+}
+
+type part struct {
+	offset int64
+	size   int64
+	key    string
+}
+
+func (s *Service) parts(ctx context.Context, id uuid.UUID) ([]part, error) {
+	rows, err := s.pool.Query(ctx, `SELECT "offset", size, storage_key FROM attachment_parts
+      WHERE attachment_id=$1
+      ORDER BY "offset"`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []part
+	for rows.Next() {
+		var p part
+		if err := rows.Scan(&p.offset, &p.size, &p.key); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Service) dropParts(ctx context.Context, parts []part) {
+	for _, p := range parts {
+		s.deleteBlob(ctx, p.key)
+	}
+}
+
+func (s *Service) deleteBlob(ctx context.Context, key string) {
+	if err := s.store.Delete(ctx, key); err != nil {
+		log.Printf("blob delete %s failed: %v", key, err)
+	}
+}
+
+func (s *Service) getByID(ctx context.Context, id uuid.UUID) (*Attachment, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE id=$1`, id)
+	return scanAttachment(row)
+}
+
+const attachmentColumns = `id, size, received, digest, public, scan_status, created_at, completed_at`
+
+func scanAttachment(row pgx.Row) (*Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.Size, &a.Offset, &a.Digest, &a.Public, &a.ScanStatus, &a.CreatedAt, &a.CompletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// partReader reads the parts of an upload back to back, opening each one
+// only when the previous one is used up.
+type partReader struct {
+	ctx   context.Context
+	store blob.Store
+	parts []part
+	cur   io.ReadCloser
+}
+
+func (p *partReader) Read(b []byte) (int, error) {
+	for {
+		if p.cur == nil {
+			if len(p.parts) == 0 {
+				return 0, io.EOF
+			}
+			rc, err := p.store.Get(p.ctx, p.parts[0].key, 0, p.parts[0].size)
+			if err != nil {
+				return 0, err
+			}
+			p.cur, p.parts = rc, p.parts[1:]
+		}
+		n, err := p.cur.Read(b)
+		if errors.Is(err, io.EOF) {
+			p.cur.Close()
+			p.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (p *partReader) Close() {
+	if p.cur != nil {
+		p.cur.Close()
+		p.cur = nil
+	}
+}
@@ -0,0 +1,185 @@
+package attachments
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kentapp/kent/server/internal/blob"
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+const gcBatchSize = 200
+
+var ErrQuotaExceeded = errors.New("attachments: storage quota exceeded")
+
+// Quotas maps subscription tiers to the bytes a user may store. Unknown
+// tiers get the "free" quota.
+type Quotas map[string]int64
+
+func (q Quotas) of(tier string) int64 {
+	if n, ok := q[tier]; ok {
+		return n
+	}
+	return q["free"]
+}
+
+// Usage is a user's storage use. Unfinished uploads count at their full
+// size.
+type Usage struct {
+	Tier  string `json:"tier"`
+	Used  int64  `json:"used"`
+	Quota int64  `json:"quota"`
+}
+
+func (s *Service) Usage(ctx context.Context, userID uuid.UUID) (*Usage, error) {
+	var u Usage
+	err := s.pool.QueryRow(ctx, `SELECT u.tier, COALESCE(s.bytes, 0) FROM users u
+      LEFT JOIN user_storage s ON s.user_id = u.id
+      WHERE u.id=$1`, userID).Scan(&u.Tier, &u.Used)
+	if err != nil {
+		return nil, err
+	}
+	u.Quota = s.quotas.of(u.Tier)
+	return &u, nil
+}
+
+// ChatUsage returns the bytes of attachments referenced by the chat's
+// messages, counting an attachment once per message.
+func (s *Service) ChatUsage(ctx context.Context, userID, chatID uuid.UUID) (int64, error) {
+	if _, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead); err != nil {
+		return 0, err
+	}
+	var n int64
+	err := s.pool.QueryRow(ctx, `SELECT COALESCE((SELECT bytes FROM chat_storage WHERE chat_id=$1), 0)`, chatID).Scan(&n)
+	return n, err
+}
+
+// reserve locks the user's usage row within tx and checks that size more
+// bytes fit in their quota. The insert that follows updates the row.
+func (s *Service) reserve(ctx context.Context, tx pgx.Tx, userID uuid.UUID, size int64) error {
+	if _, err := tx.Exec(ctx, `INSERT INTO user_storage (user_id) VALUES ($1) ON CONFLICT DO NOTHING`, userID); err != nil {
+		return err
+	}
+	var used int64
+	var tier string
+	err := tx.QueryRow(ctx, `SELECT s.bytes, u.tier FROM user_storage s
+      JOIN users u ON u.id = s.user_id
+      WHERE s.user_id=$1
+      FOR UPDATE OF s`, userID).Scan(&used, &tier)
+	if err != nil {
+		return err
+	}
+	if used+size > s.quotas.of(tier) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// RecomputeUsage rebuilds the usage counters from the stored attachments,
+// for one user when userID is set or for everyone. Chat counters are only
+// rebuilt for everyone.
+func (s *Service) RecomputeUsage(ctx context.Context, userID *uuid.UUID) (users, chatCount int64, err error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	tag, err := tx.Exec(ctx, `INSERT INTO user_storage (user_id, bytes)
+      SELECT u.id, COALESCE((SELECT sum(a.size) FROM attachments a WHERE a.owner_id = u.id), 0)
+      FROM users u
+      WHERE $1::uuid IS NULL OR u.id = $1
+      ON CONFLICT (user_id) DO UPDATE SET bytes = EXCLUDED.bytes`, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	users = tag.RowsAffected()
+	if userID != nil {
+		return users, 0, nil
+	}
+
+	if _, err = tx.Exec(ctx, `UPDATE message_attachments ma SET chat_id = m.chat_id, size = a.size
+      FROM messages m, attachments a
+      WHERE m.id = ma.message_id AND a.id = ma.attachment_id
+        AND (ma.chat_id IS DISTINCT FROM m.chat_id OR ma.size IS DISTINCT FROM a.size)`); err != nil {
+		return 0, 0, err
+	}
+	tag, err = tx.Exec(ctx, `INSERT INTO chat_storage (chat_id, bytes)
+      SELECT c.id, COALESCE((SELECT sum(ma.size) FROM message_attachments ma WHERE ma.chat_id = c.id), 0)
+      FROM chats c
+      ON CONFLICT (chat_id) DO UPDATE SET bytes = EXCLUDED.bytes`)
+	if err != nil {
+		return 0, 0, err
+	}
+	return users, tag.RowsAffected(), nil
+}
+
+// RunGC collects unreferenced attachments every interval until ctx is
+// cancelled.
+func (s *Service) RunGC(ctx context.Context, interval, grace time.Duration) {
+	blob.RunGC(ctx, "attachment", interval, gcBatchSize, func(ctx context.Context) (int, error) {
+		return s.CollectGarbage(ctx, grace)
+	})
+}
+
+// CollectGarbage deletes one batch of attachments that no message or
+// pending scheduled message has referenced for the grace period: abandoned
+// or never-sent uploads and those of deleted or expired messages. Scheduled
+// messages are matched on the parsed UUID, as the message trigger does, so
+// a reference in another letter case still keeps the upload. Rows go first,
+// then blobs; a blob that fails to delete is only logged.
+func (s *Service) CollectGarbage(ctx context.Context, grace time.Duration) (int, error) {
+	rows, err := s.pool.Query(ctx, `WITH gone AS (
+        DELETE FROM attachments
+        WHERE id IN (
+          SELECT a.id FROM attachments a
+          WHERE a.unreferenced_at < now() - make_interval(secs => $1)
+            AND NOT EXISTS (SELECT 1 FROM message_attachments ma WHERE ma.attachment_id = a.id)
+            AND NOT EXISTS (
+              SELECT 1 FROM scheduled_messages sm, jsonb_array_elements_text(
+                CASE WHEN jsonb_typeof(sm.headers->'attachments') = 'array' THEN sm.headers->'attachments' ELSE '[]'::jsonb END) e
+              WHERE sm.status = 'pending'
+                AND CASE WHEN e ~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$' THEN e::uuid END = a.id)
+          ORDER BY a.unreferenced_at
+          LIMIT $2
+          FOR UPDATE SKIP LOCKED)
+        RETURNING id, storage_key)
+      SELECT g.id, g.storage_key FROM gone g WHERE g.storage_key IS NOT NULL
+      UNION ALL
+      SELECT g.id, p.storage_key FROM gone g JOIN attachment_parts p ON p.attachment_id = g.id`,
+		grace.Seconds(), gcBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	ids := make(map[uuid.UUID]bool)
+	for rows.Next() {
+		var id uuid.UUID
+		var key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids[id] = true
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		s.deleteBlob(ctx, key)
+	}
+	return len(ids), nil
+}
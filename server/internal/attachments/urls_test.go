@@ -0,0 +1,121 @@
+package attachments
+
+import (
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func parseSigned(t *testing.T, raw string) (uuid.UUID, int64, string) {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	id, err := uuid.Parse(path.Base(u.Path))
+	if err != nil {
+		t.Fatalf("id in %q: %v", raw, err)
+	}
+	exp, err := strconv.ParseInt(u.Query().Get("exp"), 10, 64)
+	if err != nil {
+		t.Fatalf("exp in %q: %v", raw, err)
+	}
+	return id, exp, u.Query().Get("sig")
+}
+
+func TestSignerURL(t *testing.T) {
+	s := NewSigner([]byte("test key"), time.Hour, "https://cdn.example.com/v1/media")
+	id := uuid.MustParse("6f1c8f9e-3c43-4f0e-9a51-0d5c2b7d9a10")
+	now := time.Date(2026, 5, 1, 10, 2, 30, 0, time.UTC)
+
+	raw, exp := s.URL(id, now)
+	if !strings.HasPrefix(raw, "https://cdn.example.com/v1/media/"+id.String()+"?") {
+		t.Fatalf("URL = %q", raw)
+	}
+	if want := time.Date(2026, 5, 1, 11, 5, 0, 0, time.UTC); !exp.Equal(want) {
+		t.Errorf("expiry = %v, want %v rounded up to the step", exp, want)
+	}
+	if exp.Sub(now) < time.Hour {
+		t.Errorf("URL is good for %v, less than the TTL", exp.Sub(now))
+	}
+
+	gotID, gotExp, sig := parseSigned(t, raw)
+	if gotID != id || gotExp != exp.Unix() {
+		t.Errorf("URL carries id %v exp %d, want %v %d", gotID, gotExp, id, exp.Unix())
+	}
+	if !s.Verify(id, gotExp, sig, now) {
+		t.Error("fresh URL does not verify")
+	}
+
+	// URLs issued within one step are identical so CDNs can cache them.
+	if again, _ := s.URL(id, now.Add(90*time.Second)); again != raw {
+		t.Errorf("URL issued 90s later = %q, want %q", again, raw)
+	}
+}
+
+func TestSignerExpiry(t *testing.T) {
+	s := NewSigner([]byte("test key"), time.Hour, "/v1/media")
+	id := uuid.New()
+	now := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	_, exp, sig := parseSigned(t, mustURL(s, id, now))
+
+	for _, tc := range []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"issued", now, true},
+		{"just before expiry", time.Unix(exp-1, 0), true},
+		{"at expiry", time.Unix(exp, 0), false},
+		{"after expiry", time.Unix(exp+3600, 0), false},
+	} {
+		if got := s.Verify(id, exp, sig, tc.at); got != tc.want {
+			t.Errorf("%s: Verify = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSignerRejectsTampering(t *testing.T) {
+	s := NewSigner([]byte("test key"), time.Hour, "/v1/media")
+	id := uuid.New()
+	now := time.Now()
+	_, exp, sig := parseSigned(t, mustURL(s, id, now))
+
+	flipped := []byte(sig)
+	if flipped[0] == 'A' {
+		flipped[0] = 'B'
+	} else {
+		flipped[0] = 'A'
+	}
+
+	for _, tc := range []struct {
+		name   string
+		signer *Signer
+		id     uuid.UUID
+		exp    int64
+		sig    string
+	}{
+		{"other attachment", s, uuid.New(), exp, sig},
+		{"extended expiry", s, id, exp + 3600, sig},
+		{"altered signature", s, id, exp, string(flipped)},
+		{"truncated signature", s, id, exp, sig[:len(sig)-4]},
+		{"empty signature", s, id, exp, ""},
+		{"not base64url", s, id, exp, sig[:len(sig)-1] + "*"},
+		{"padded base64", s, id, exp, sig + "="},
+		{"other key", NewSigner([]byte("other key"), time.Hour, "/v1/media"), id, exp, sig},
+	} {
+		if tc.signer.Verify(tc.id, tc.exp, tc.sig, now) {
+			t.Errorf("%s: Verify accepted a tampered URL", tc.name)
+		}
+	}
+}
+
+func mustURL(s *Signer, id uuid.UUID, now time.Time) string {
+	raw, _ := s.URL(id, now)
+	return raw
+}
@@ -0,0 +1,49 @@
+// Package blob stores opaque objects, such as client-encrypted attachments,
+// on the local filesystem or in an S3-compatible bucket.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrNotFound     = errors.New("blob: object not found")
+	ErrSizeMismatch = errors.New("blob: object size does not match")
+	ErrInvalidKey   = errors.New("blob: invalid key")
+)
+
+// Store is a flat key-value object store. Keys are slash-separated paths of
+// letters, digits, '-', '_' and '.'.
+type Store interface {
+	// Put stores exactly size bytes read from r under key, replacing any
+	// object already there. A reader that ends early or runs long fails
+	// with ErrSizeMismatch and leaves no object behind.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get reads length bytes starting at offset, or to the end of the object
+	// when length is negative.
+	Get(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+		for _, r := range seg {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
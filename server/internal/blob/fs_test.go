@@ -0,0 +1,110 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	for _, tc := range []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"attachments/ab/0f3c-9e_1.bin", true},
+		{"a.b/c..d", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"a/", false},
+		{"a//b", false},
+		{".", false},
+		{"..", false},
+		{"../a", false},
+		{"a/../../b", false},
+		{"a/./b", false},
+		{"a/..", false},
+		{`..\a`, false},
+		{`a\b`, false},
+		{"a/b c", false},
+		{"a/b%2f..", false},
+		{"a\x00b", false},
+		{"a/é", false},
+		{"a:b", false},
+	} {
+		if got := validKey(tc.key); got != tc.want {
+			t.Errorf("validKey(%q) = %v, want %v", tc.key, got, tc.want)
+		}
+	}
+}
+
+func TestFSStaysUnderRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	s := NewFS(root)
+	ctx := context.Background()
+
+	for _, key := range []string{"../outside", "a/../../outside", "/outside", `..\outside`} {
+		if err := s.Put(ctx, key, strings.NewReader("x"), 1); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+		if _, err := s.Get(ctx, key, 0, -1); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+		if err := s.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "outside")); !os.IsNotExist(err) {
+		t.Errorf("a file was written outside the root: %v", err)
+	}
+}
+
+func TestFSRoundTrip(t *testing.T) {
+	s := NewFS(t.TempDir())
+	ctx := context.Background()
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+
+	if err := s.Put(ctx, "a/b/obj", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	for _, tc := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, string(data)},
+		{10, -1, string(data[10:])},
+		{3, 5, string(data[3:8])},
+		{0, 0, ""},
+	} {
+		if got := readAll(t, s, "a/b/obj", tc.offset, tc.length); got != tc.want {
+			t.Errorf("Get(%d, %d) = %q, want %q", tc.offset, tc.length, got, tc.want)
+		}
+	}
+
+	if err := s.Put(ctx, "short", strings.NewReader("abc"), 10); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Put of a short body: err = %v, want ErrSizeMismatch", err)
+	}
+	if err := s.Put(ctx, "long", strings.NewReader("abcdef"), 3); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Put of a long body: err = %v, want ErrSizeMismatch", err)
+	}
+	for _, key := range []string{"short", "long"} {
+		if _, err := s.Get(ctx, key, 0, -1); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) after a failed Put: err = %v, want ErrNotFound", key, err)
+		}
+	}
+
+	if err := s.Delete(ctx, "a/b/obj"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, "a/b/obj", 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "a/b/obj"); err != nil {
+		t.Errorf("Delete of a missing object: %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package blob
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunGC calls collect every interval until ctx is cancelled. collect deletes
+// one batch of at most batchSize unused objects and returns how many it
+// deleted; full batches are followed by another right away. Several replicas
+// may run it concurrently as long as collect locks what it deletes.
+func RunGC(ctx context.Context, name string, interval time.Duration, batchSize int, collect func(context.Context) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := collect(ctx)
+			if err != nil {
+				log.Printf("%s gc failed: %v", name, err)
+				break
+			}
+			if n < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunGC(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		results []int
+	}{
+		{"full batches run back to back", []int{10, 10, 3}},
+		{"an error ends the round", []int{10, -1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			calls := 0
+			round := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				// The interval never elapses, so only the first round runs.
+				RunGC(ctx, "test", time.Hour, 10, func(context.Context) (int, error) {
+					n := tc.results[calls]
+					calls++
+					if calls == len(tc.results) {
+						close(round)
+					}
+					if n < 0 {
+						return 0, errors.New("collect failed")
+					}
+					return n, nil
+				})
+			}()
+
+			select {
+			case <-round:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("collect ran %d times, want %d", calls, len(tc.results))
+			}
+			cancel()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("RunGC did not return after ctx was cancelled")
+			}
+			if calls != len(tc.results) {
+				t.Errorf("collect ran %d times, want %d", calls, len(tc.results))
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The vectors come from the AWS Signature Version 4 test suite and the S3
+// API reference; their canonical requests are spelled out so that the
+// signing itself is checked independently of how sign builds them.
+func TestSigV4ReferenceVectors(t *testing.T) {
+	const emptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	for _, tc := range []struct {
+		name      string
+		secret    string
+		scope     string
+		amzDate   string
+		canonical string
+		want      string
+	}{
+		{
+			name:    "get-vanilla",
+			secret:  "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+			scope:   "20150830/us-east-1/service/aws4_request",
+			amzDate: "20150830T123600Z",
+			canonical: "GET\n/\n\n" +
+				"host:example.amazonaws.com\nx-amz-date:20150830T123600Z\n\n" +
+				"host;x-amz-date\n" + emptyHash,
+			want: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:    "s3-get-object-range",
+			secret:  "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY",
+			scope:   "20130524/us-east-1/s3/aws4_request",
+			amzDate: "20130524T000000Z",
+			canonical: "GET\n/test.txt\n\n" +
+				"host:examplebucket.s3.amazonaws.com\nrange:bytes=0-9\n" +
+				"x-amz-content-sha256:" + emptyHash + "\nx-amz-date:20130524T000000Z\n\n" +
+				"host;range;x-amz-content-sha256;x-amz-date\n" + emptyHash,
+			want: "f0e8bdb87c964420e857bd35b5d6ed310bd44f0170aba48dd91039c6036bdb41",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sigV4(tc.secret, tc.scope, tc.amzDate, tc.canonical); got != tc.want {
+				t.Errorf("signature = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSignHeaders(t *testing.T) {
+	s := NewS3("http://minio:9000/", "eu-west-1", "kent-attachments", "AKIDEXAMPLE", "secret")
+	req, err := s.request(context.Background(), http.MethodGet, "attachments/ab/c-d_e.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.sign(req, time.Date(2026, 3, 1, 12, 30, 45, 0, time.FixedZone("CET", 3600)))
+
+	if got := req.Header.Get("X-Amz-Date"); got != "20260301T113045Z" {
+		t.Errorf("X-Amz-Date = %q, want the time in UTC", got)
+	}
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != unsignedPayload {
+		t.Errorf("X-Amz-Content-Sha256 = %q", got)
+	}
+
+	wantCanonical := "GET\n/kent-attachments/attachments/ab/c-d_e.bin\n\n" +
+		"host:minio:9000\nx-amz-content-sha256:UNSIGNED-PAYLOAD\nx-amz-date:20260301T113045Z\n\n" +
+		"host;x-amz-content-sha256;x-amz-date\nUNSIGNED-PAYLOAD"
+	if got := canonicalRequest(req, "20260301T113045Z"); got != wantCanonical {
+		t.Errorf("canonical request =\n%s\nwant\n%s", got, wantCanonical)
+	}
+
+	scope := "20260301/eu-west-1/s3/aws4_request"
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/" + scope +
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=" +
+		sigV4("secret", scope, "20260301T113045Z", wantCanonical)
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestS3RejectsInvalidKeys(t *testing.T) {
+	s := NewS3("http://minio:9000", "us-east-1", "b", "k", "s")
+	if _, err := s.request(context.Background(), http.MethodGet, "../other-bucket/x", nil); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("request with a traversal key: err = %v, want ErrInvalidKey", err)
+	}
+}
+
+// TestS3RoundTrip runs against a real bucket, such as the MinIO service in
+// infra/docker-compose.yml:
+//
+//	TEST_S3_ENDPOINT=http://localhost:9000 go test ./internal/blob/
+//
+// The bucket and keys default to the ones that compose file sets up.
+func TestS3RoundTrip(t *testing.T) {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT not set")
+	}
+	s := NewS3(endpoint, envOr("TEST_S3_REGION", "us-east-1"), envOr("TEST_S3_BUCKET", "kent-attachments"),
+		envOr("TEST_S3_ACCESS_KEY", "kent"), envOr("TEST_S3_SECRET_KEY", "kent-minio-secret"))
+	ctx := context.Background()
+	key := "test/roundtrip-" + time.Now().UTC().Format("20060102T150405.000000000")
+	data := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	t.Cleanup(func() { _ = s.Delete(ctx, key) })
+
+	if err := s.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	for _, tc := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, string(data)},
+		{10, -1, string(data[10:])},
+		{3, 5, string(data[3:8])},
+		{0, 0, ""},
+	} {
+		if got := readAll(t, s, key, tc.offset, tc.length); got != tc.want {
+			t.Errorf("Get(%d, %d) = %q, want %q", tc.offset, tc.length, got, tc.want)
+		}
+	}
+
+	if err := s.Put(ctx, key+"-short", strings.NewReader("abc"), 10); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Put of a short body: err = %v, want ErrSizeMismatch", err)
+	}
+	if _, err := s.Get(ctx, key+"-short", 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after a short Put: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Put(ctx, key+"-long", strings.NewReader("abcdef"), 3); !errors.Is(err, ErrSizeMismatch) {
+		t.Errorf("Put of a long body: err = %v, want ErrSizeMismatch", err)
+	}
+	if _, err := s.Get(ctx, key+"-long", 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after a long Put: err = %v, want ErrNotFound", err)
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, key, 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: err = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing object: %v", err)
+	}
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func readAll(t *testing.T, s Store, key string, offset, length int64) string {
+	t.Helper()
+	rc, err := s.Get(context.Background(), key, offset, length)
+	if err != nil {
+		t.Fatalf("Get(%d, %d): %v", offset, length, err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(b)
+}
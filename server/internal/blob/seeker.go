@@ -0,0 +1,65 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Seeker is an io.ReadSeekCloser over a stored object of known size. It
+// opens the object at the current offset on the first read after a seek, so
+// seeking costs nothing and http.ServeContent fetches ranges directly.
+type Seeker struct {
+	ctx    context.Context
+	store  Store
+	key    string
+	size   int64
+	offset int64
+	cur    io.ReadCloser
+}
+
+func NewSeeker(ctx context.Context, store Store, key string, size int64) *Seeker {
+	return &Seeker{ctx: ctx, store: store, key: key, size: size}
+}
+
+func (b *Seeker) Read(p []byte) (int, error) {
+	if b.offset >= b.size {
+		return 0, io.EOF
+	}
+	if b.cur == nil {
+		rc, err := b.store.Get(b.ctx, b.key, b.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		b.cur = rc
+	}
+	n, err := b.cur.Read(p)
+	b.offset += int64(n)
+	return n, err
+}
+
+func (b *Seeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += b.offset
+	case io.SeekEnd:
+		offset += b.size
+	}
+	if offset < 0 {
+		return 0, errors.New("blob: negative seek")
+	}
+	if offset != b.offset {
+		b.Close()
+		b.offset = offset
+	}
+	return offset, nil
+}
+
+func (b *Seeker) Close() error {
+	if b.cur == nil {
+		return nil
+	}
+	err := b.cur.Close()
+	b.cur = nil
+	return err
+}
@@ -0,0 +1,126 @@
+package chats
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrNotChannel = errors.New("chats: not a channel")
+
+// ChannelInfo is what a reader sees about a channel; Role is only set for
+// subscribers.
+type ChannelInfo struct {
+	ID              uuid.UUID  `json:"id"`
+	Title           *string    `json:"title,omitempty"`
+	AvatarID        *uuid.UUID `json:"avatarId,omitempty"`
+	IsPublic        bool       `json:"isPublic"`
+	SubscriberCount int        `json:"subscriberCount"`
+	Subscribed      bool       `json:"subscribed"`
+	Role            *Role      `json:"role,omitempty"`
+}
+
+// Channel describes a channel the caller subscribes to or, if public, may
+// read without subscribing.
+func (r *Repository) Channel(ctx context.Context, chatID, userID uuid.UUID) (*ChannelInfo, error) {
+	m, err := r.Authorize(ctx, chatID, userID, ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if m.Kind != KindChannel {
+		return nil, ErrNotChannel
+	}
+
+	info := ChannelInfo{ID: chatID, IsPublic: m.Public, Subscribed: m.Joined}
+	if m.Joined {
+		info.Role = &m.Role
+	}
+	err = r.pool.QueryRow(ctx, `SELECT title, avatar_id, member_count FROM chats WHERE id=$1`, chatID).Scan(&info.Title, &info.AvatarID, &info.SubscriberCount)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Subscribe joins a public channel. Private channels are joined through
+// invite links.
+func (r *Repository) Subscribe(ctx context.Context, chatID, userID uuid.UUID) (err error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	var kind Kind
+	var public bool
+	err = tx.QueryRow(ctx, `SELECT kind, is_public FROM chats WHERE id=$1`, chatID).Scan(&kind, &public)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = ErrNotChannel
+		}
+		return err
+	}
+	if kind != KindChannel {
+		err = ErrNotChannel
+		return err
+	}
+	if !public {
+		err = ErrNotAllowed
+		return err
+	}
+
+	banned, err := isBanned(ctx, tx, chatID, userID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		err = ErrBanned
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO chat_participants (chat_id, user_id, role) VALUES ($1, $2, $3)
+      ON CONFLICT DO NOTHING`, chatID, userID, RoleMember)
+	return err
+}
+
+// Unsubscribe leaves a channel. The owner cannot leave their own channel.
+func (r *Repository) Unsubscribe(ctx context.Context, chatID, userID uuid.UUID) error {
+	m, err := r.Authorize(ctx, chatID, userID, ActionRead)
+	if err != nil {
+		return err
+	}
+	if m.Kind != KindChannel {
+		return ErrNotChannel
+	}
+	if m.Role == RoleOwner {
+		return ErrNotAllowed
+	}
+
+	_, err = r.pool.Exec(ctx, `DELETE FROM chat_participants WHERE chat_id=$1 AND user_id=$2`, chatID, userID)
+	return err
+}
+
+// FlushMemberCounts folds one batch of queued channel join and leave
+// deltas into chats.member_count and returns how many it consumed.
+func (r *Repository) FlushMemberCounts(ctx context.Context, limit int) (int, error) {
+	var n int
+	err := r.pool.QueryRow(ctx, `WITH batch AS (
+        DELETE FROM chat_member_deltas
+        WHERE id IN (SELECT id FROM chat_member_deltas ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED)
+        RETURNING chat_id, delta
+      ), applied AS (
+        UPDATE chats c SET member_count = c.member_count + s.n
+        FROM (SELECT chat_id, sum(delta) AS n FROM batch GROUP BY chat_id) s
+        WHERE c.id = s.chat_id
+      )
+      SELECT count(*) FROM batch`, limit).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,115 @@
+package chats
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	EventDraft = "chat.draft"
+
+	MaxDraftSize = 16 << 10
+)
+
+var (
+	ErrInvalidDraft  = errors.New("chats: draft is too large")
+	ErrDraftConflict = errors.New("chats: a newer draft exists")
+)
+
+// Draft is the caller's encrypted unsent text for a chat. Version is
+// assigned by the server and grows with every save or clear; 0 means no
+// draft was ever stored.
+type Draft struct {
+	Ciphertext []byte     `json:"ciphertext,omitempty"`
+	DeviceID   *uuid.UUID `json:"deviceId,omitempty"`
+	Version    int64      `json:"version"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+// SaveDraft stores the draft if the stored one is still at baseVersion, the
+// version the device last saw. Otherwise another device wrote in between,
+// and it returns ErrDraftConflict along with the stored draft. An empty
+// ciphertext clears the draft.
+func (r *Repository) SaveDraft(ctx context.Context, chatID, userID, deviceID uuid.UUID, ciphertext []byte, baseVersion int64) (*Draft, error) {
+	if len(ciphertext) > MaxDraftSize || baseVersion < 0 {
+		return nil, ErrInvalidDraft
+	}
+	if err := r.draftAccess(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 {
+		ciphertext = nil
+	}
+
+	d := Draft{Ciphertext: ciphertext, DeviceID: &deviceID}
+	err := r.pool.QueryRow(ctx, `INSERT INTO chat_drafts (user_id, chat_id, ciphertext, device_id)
+      VALUES ($1, $2, $3, $4)
+      ON CONFLICT (user_id, chat_id) DO UPDATE
+        SET ciphertext = EXCLUDED.ciphertext, device_id = EXCLUDED.device_id,
+            version = chat_drafts.version + 1, updated_at = now()
+        WHERE chat_drafts.version = $5
+      RETURNING version, updated_at`,
+		userID, chatID, ciphertext, deviceID, baseVersion).Scan(&d.Version, &d.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		current, err := r.Draft(ctx, chatID, userID)
+		if err != nil {
+			return nil, err
+		}
+		return current, ErrDraftConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Draft returns the stored draft, which has no ciphertext when cleared.
+func (r *Repository) Draft(ctx context.Context, chatID, userID uuid.UUID) (*Draft, error) {
+	if err := r.draftAccess(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	var d Draft
+	err := r.pool.QueryRow(ctx, `SELECT ciphertext, device_id, version, updated_at FROM chat_drafts WHERE user_id=$1 AND chat_id=$2`,
+		userID, chatID).Scan(&d.Ciphertext, &d.DeviceID, &d.Version, &d.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &Draft{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ClearDraft drops the draft after the user sent a message from any device.
+// The clear bumps the version, so a save still based on the version before it
+// gets ErrDraftConflict instead of bringing the sent text back. It returns the
+// cleared draft, or nil when there was nothing to clear.
+func (r *Repository) ClearDraft(ctx context.Context, chatID, userID uuid.UUID) (*Draft, error) {
+	var d Draft
+	err := r.pool.QueryRow(ctx, `UPDATE chat_drafts SET ciphertext=NULL, device_id=NULL, version=version+1, updated_at=now()
+      WHERE user_id=$1 AND chat_id=$2 AND ciphertext IS NOT NULL
+      RETURNING version, updated_at`, userID, chatID).Scan(&d.Version, &d.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+func (r *Repository) draftAccess(ctx context.Context, chatID, userID uuid.UUID) error {
+	m, err := r.Authorize(ctx, chatID, userID, ActionRead)
+	if err != nil {
+		return err
+	}
+	if !m.Joined {
+		return ErrNotParticipant
+	}
+	return nil
+}
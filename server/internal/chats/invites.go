@@ -0,0 +1,359 @@
+package chats
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	JoinStatusJoined  = "joined"
+	JoinStatusPending = "pending"
+	JoinStatusMember  = "already_member"
+)
+
+var (
+	ErrNotAllowed      = errors.New("chats: action not allowed")
+	ErrInviteInvalid   = errors.New("chats: invite link is invalid, revoked, expired or used up")
+	ErrRequestNotFound = errors.New("chats: join request not found")
+)
+
+// InviteLink is a shareable link into a chat. Only a hash of the token is
+// stored, so Token is set when the link is created and empty afterwards.
+type InviteLink struct {
+	ID               uuid.UUID  `json:"id"`
+	ChatID           uuid.UUID  `json:"chatId"`
+	Token            string     `json:"token,omitempty"`
+	CreatedBy        *uuid.UUID `json:"createdBy,omitempty"`
+	ExpiresAt        *time.Time `json:"expiresAt,omitempty"`
+	MaxUses          *int       `json:"maxUses,omitempty"`
+	UseCount         int        `json:"useCount"`
+	RequiresApproval bool       `json:"requiresApproval"`
+	RevokedAt        *time.Time `json:"revokedAt,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
+
+type InviteOptions struct {
+	ExpiresAt        *time.Time
+	MaxUses          *int
+	RequiresApproval bool
+}
+
+// InvitePreview is what a prospective member may see before joining.
+type InvitePreview struct {
+	ChatID           uuid.UUID  `json:"chatId"`
+	Title            *string    `json:"title,omitempty"`
+	AvatarID         *uuid.UUID `json:"avatarId,omitempty"`
+	MemberCount      int        `json:"memberCount"`
+	RequiresApproval bool       `json:"requiresApproval"`
+}
+
+type JoinRequest struct {
+	UserID      uuid.UUID `json:"userId"`
+	LinkID      uuid.UUID `json:"linkId"`
+	RequestedAt time.Time `json:"requestedAt"`
+}
+
+type InviteJoin struct {
+	UserID     uuid.UUID  `json:"userId"`
+	LinkID     *uuid.UUID `json:"linkId,omitempty"`
+	ApprovedBy *uuid.UUID `json:"approvedBy,omitempty"`
+	JoinedAt   time.Time  `json:"joinedAt"`
+}
+
+const inviteColumns = `id, chat_id, created_by, expires_at, max_uses, use_count, requires_approval, revoked_at, created_at`
+
+func (r *Repository) CreateInvite(ctx context.Context, chatID, adminID uuid.UUID, opts InviteOptions) (*InviteLink, error) {
+	if _, err := r.Authorize(ctx, chatID, adminID, ActionInvite); err != nil {
+		return nil, err
+	}
+
+	token, err := newInviteToken()
+	if err != nil {
+		return nil, err
+	}
+	row := r.pool.QueryRow(ctx, `INSERT INTO chat_invite_links (chat_id, token_sha256, created_by, expires_at, max_uses, requires_approval)
+      VALUES ($1, $2, $3, $4, $5, $6)
+      RETURNING `+inviteColumns, chatID, hashInviteToken(token), adminID, opts.ExpiresAt, opts.MaxUses, opts.RequiresApproval)
+	link, err := scanInvite(row)
+	if err != nil {
+		return nil, err
+	}
+	link.Token = token
+	return link, nil
+}
+
+func (r *Repository) ListInvites(ctx context.Context, chatID, adminID uuid.UUID) ([]InviteLink, error) {
+	if _, err := r.Authorize(ctx, chatID, adminID, ActionInvite); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.pool.Query(ctx, `SELECT `+inviteColumns+` FROM chat_invite_links WHERE chat_id=$1 ORDER BY created_at DESC`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []InviteLink
+	for rows.Next() {
+		link, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *link)
+	}
+	return out, rows.Err()
+}
+
+func (r *Repository) RevokeInvite(ctx context.Context, chatID, adminID, linkID uuid.UUID) error {
+	if _, err := r.Authorize(ctx, chatID, adminID, ActionInvite); err != nil {
+		return err
+	}
+
+	res, err := r.pool.Exec(ctx, `UPDATE chat_invite_links SET revoked_at = COALESCE(revoked_at, now()) WHERE id=$1 AND chat_id=$2`, linkID, chatID)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrInviteInvalid
+	}
+	return nil
+}
+
+func (r *Repository) PreviewInvite(ctx context.Context, token string) (*InvitePreview, error) {
+	var p InvitePreview
+	err := r.pool.QueryRow(ctx, `SELECT c.id, c.title, c.avatar_id, l.requires_approval, c.member_count
+      FROM chat_invite_links l
+      JOIN chats c ON c.id = l.chat_id
+      WHERE l.token_sha256=$1 AND `+inviteUsable, hashInviteToken(token)).Scan(&p.ChatID, &p.Title, &p.AvatarID, &p.RequiresApproval, &p.MemberCount)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrInviteInvalid
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+// JoinByInvite adds the user to the chat behind the link, or files a join
+// request when the link requires admin approval.
+func (r *Repository) JoinByInvite(ctx context.Context, token string, userID uuid.UUID) (chatID uuid.UUID, status string, err error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	var linkID uuid.UUID
+	var requiresApproval bool
+	err = tx.QueryRow(ctx, `SELECT id, chat_id, requires_approval FROM chat_invite_links l
+      WHERE token_sha256=$1 AND `+inviteUsable+`
+      FOR UPDATE`, hashInviteToken(token)).Scan(&linkID, &chatID, &requiresApproval)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = ErrInviteInvalid
+		}
+		return uuid.Nil, "", err
+	}
+
+	var member bool
+	if err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM chat_participants WHERE chat_id=$1 AND user_id=$2)`, chatID, userID).Scan(&member); err != nil {
+		return uuid.Nil, "", err
+	}
+	if member {
+		return chatID, JoinStatusMember, nil
+	}
+	banned, err := isBanned(ctx, tx, chatID, userID)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if banned {
+		err = ErrBanned
+		return uuid.Nil, "", err
+	}
+
+	if requiresApproval {
+		_, err = tx.Exec(ctx, `INSERT INTO chat_join_requests (chat_id, user_id, link_id) VALUES ($1, $2, $3)
+        ON CONFLICT (chat_id, user_id) DO UPDATE
+          SET link_id=EXCLUDED.link_id, status='pending', requested_at=now(), decided_by=NULL, decided_at=NULL`,
+			chatID, userID, linkID)
+		if err != nil {
+			return uuid.Nil, "", err
+		}
+		return chatID, JoinStatusPending, nil
+	}
+
+	if err = joinViaLink(ctx, tx, chatID, userID, linkID, nil); err != nil {
+		return uuid.Nil, "", err
+	}
+	return chatID, JoinStatusJoined, nil
+}
+
+func (r *Repository) ListJoinRequests(ctx context.Context, chatID, adminID uuid.UUID) ([]JoinRequest, error) {
+	if _, err := r.Authorize(ctx, chatID, adminID, ActionInvite); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.pool.Query(ctx, `SELECT user_id, link_id, requested_at FROM chat_join_requests
+      WHERE chat_id=$1 AND status='pending'
+      ORDER BY requested_at`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []JoinRequest
+	for rows.Next() {
+		var jr JoinRequest
+		if err := rows.Scan(&jr.UserID, &jr.LinkID, &jr.RequestedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, jr)
+	}
+	return out, rows.Err()
+}
+
+// DecideJoinRequest approves or rejects a pending request. Approval still
+// counts against the link's use limit, but not its expiry.
+func (r *Repository) DecideJoinRequest(ctx context.Context, chatID, adminID, userID uuid.UUID, approve bool) (err error) {
+	if _, err := r.Authorize(ctx, chatID, adminID, ActionInvite); err != nil {
+		return err
+	}
+
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	var linkID uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT link_id FROM chat_join_requests
+      WHERE chat_id=$1 AND user_id=$2 AND status='pending'
+      FOR UPDATE`, chatID, userID).Scan(&linkID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = ErrRequestNotFound
+		}
+		return err
+	}
+
+	status := "rejected"
+	if approve {
+		status = "approved"
+		var banned bool
+		if banned, err = isBanned(ctx, tx, chatID, userID); err != nil {
+			return err
+		}
+		if banned {
+			err = ErrBanned
+			return err
+		}
+		var usable bool
+		err = tx.QueryRow(ctx, `SELECT revoked_at IS NULL AND (max_uses IS NULL OR use_count < max_uses)
+        FROM chat_invite_links WHERE id=$1 FOR UPDATE`, linkID).Scan(&usable)
+		if err != nil {
+			return err
+		}
+		if !usable {
+			err = ErrInviteInvalid
+			return err
+		}
+		if err = joinViaLink(ctx, tx, chatID, userID, linkID, &adminID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `UPDATE chat_join_requests SET status=$3, decided_by=$4, decided_at=now()
+      WHERE chat_id=$1 AND user_id=$2`, chatID, userID, status, adminID)
+	return err
+}
+
+// InviteJoins returns the audit trail of members who joined through links.
+func (r *Repository) InviteJoins(ctx context.Context, chatID, adminID uuid.UUID, linkID *uuid.UUID) ([]InviteJoin, error) {
+	if _, err := r.Authorize(ctx, chatID, adminID, ActionInvite); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.pool.Query(ctx, `SELECT user_id, link_id, approved_by, joined_at FROM chat_invite_joins
+      WHERE chat_id=$1 AND ($2::uuid IS NULL OR link_id=$2)
+      ORDER BY joined_at DESC
+      LIMIT 500`, chatID, linkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []InviteJoin
+	for rows.Next() {
+		var j InviteJoin
+		if err := rows.Scan(&j.UserID, &j.LinkID, &j.ApprovedBy, &j.JoinedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, j)
+	}
+	return out, rows.Err()
+}
+
+// AdminIDs returns the owners and admins of a chat.
+func (r *Repository) AdminIDs(ctx context.Context, chatID uuid.UUID) ([]uuid.UUID, error) {
+	return r.queryIDs(ctx, `SELECT user_id FROM chat_participants WHERE chat_id=$1 AND role IN ('owner', 'admin')`, chatID)
+}
+
+// inviteUsable filters chat_invite_links (aliased l) down to links that can
+// still be used to join.
+const inviteUsable = `l.revoked_at IS NULL
+        AND (l.expires_at IS NULL OR l.expires_at > now())
+        AND (l.max_uses IS NULL OR l.use_count < l.max_uses)`
+
+func joinViaLink(ctx context.Context, tx pgx.Tx, chatID, userID, linkID uuid.UUID, approvedBy *uuid.UUID) error {
+	if _, err := tx.Exec(ctx, `INSERT INTO chat_participants (chat_id, user_id, role) VALUES ($1, $2, $3)
+      ON CONFLICT DO NOTHING`, chatID, userID, RoleMember); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE chat_invite_links SET use_count = use_count + 1 WHERE id=$1`, linkID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO chat_invite_joins (chat_id, link_id, user_id, approved_by) VALUES ($1, $2, $3, $4)`,
+		chatID, linkID, userID, approvedBy)
+	return err
+}
+
+func scanInvite(row pgx.Row) (*InviteLink, error) {
+	var l InviteLink
+	err := row.Scan(&l.ID, &l.ChatID, &l.CreatedBy, &l.ExpiresAt, &l.MaxUses, &l.UseCount, &l.RequiresApproval, &l.RevokedAt, &l.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func newInviteToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashInviteToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
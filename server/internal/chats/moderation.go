@@ -0,0 +1,196 @@
+package chats
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrInvalidRole = errors.New("chats: invalid role change")
+
+type Participant struct {
+	UserID          uuid.UUID  `json:"userId"`
+	Role            Role       `json:"role"`
+	Permissions     []string   `json:"permissions"`
+	RestrictedUntil *time.Time `json:"restrictedUntil,omitempty"`
+	JoinedAt        time.Time  `json:"joinedAt"`
+}
+
+type Ban struct {
+	UserID    uuid.UUID  `json:"userId"`
+	BannedBy  *uuid.UUID `json:"bannedBy,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Members lists a chat's participants. Channel subscriber lists are private,
+// so for channels only the owner and admins are returned.
+func (r *Repository) Members(ctx context.Context, chatID, viewerID uuid.UUID) ([]Participant, error) {
+	m, err := r.Authorize(ctx, chatID, viewerID, ActionRead)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.pool.Query(ctx, `SELECT user_id, role, permissions, restricted_until, joined_at
+      FROM chat_participants
+      WHERE chat_id=$1 AND (NOT $2 OR role IN ('owner', 'admin'))
+      ORDER BY joined_at`, chatID, m.Kind == KindChannel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Participant
+	for rows.Next() {
+		var p Participant
+		var perms Permission
+		if err := rows.Scan(&p.UserID, &p.Role, &perms, &p.RestrictedUntil, &p.JoinedAt); err != nil {
+			return nil, err
+		}
+		p.Permissions = perms.Names()
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// UpdateInfo changes the group or channel title.
+func (r *Repository) UpdateInfo(ctx context.Context, chatID, userID uuid.UUID, title *string) error {
+	m, err := r.Authorize(ctx, chatID, userID, ActionChangeInfo)
+	if err != nil {
+		return err
+	}
+	if m.Kind == KindDirect {
+		return ErrNotAllowed
+	}
+	_, err = r.pool.Exec(ctx, `UPDATE chats SET title=$2 WHERE id=$1`, chatID, title)
+	return err
+}
+
+// SetRole promotes a member to admin with the given rights or demotes an
+// admin back to member. Only the owner may do this.
+func (r *Repository) SetRole(ctx context.Context, chatID, ownerID, targetID uuid.UUID, role Role, perms Permission) error {
+	if role != RoleAdmin && role != RoleMember {
+		return ErrInvalidRole
+	}
+	if ownerID == targetID {
+		return ErrInvalidRole
+	}
+	if _, err := r.Authorize(ctx, chatID, ownerID, ActionManageAdmins); err != nil {
+		return err
+	}
+	if role == RoleMember {
+		perms = 0
+	}
+
+	res, err := r.pool.Exec(ctx, `UPDATE chat_participants SET role=$3, permissions=$4
+      WHERE chat_id=$1 AND user_id=$2 AND role <> 'owner'`, chatID, targetID, role, perms&PermAll)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrInvalidRole
+	}
+	return nil
+}
+
+// Restrict mutes a member until the given time; nil lifts the restriction.
+func (r *Repository) Restrict(ctx context.Context, chatID, adminID, targetID uuid.UUID, until *time.Time) error {
+	if _, err := r.moderationTarget(ctx, chatID, adminID, targetID); err != nil {
+		return err
+	}
+	_, err := r.pool.Exec(ctx, `UPDATE chat_participants SET restricted_until=$3 WHERE chat_id=$1 AND user_id=$2`, chatID, targetID, until)
+	return err
+}
+
+// BanMember removes a member and keeps them out until the given time, or
+// permanently when until is nil.
+func (r *Repository) BanMember(ctx context.Context, chatID, adminID, targetID uuid.UUID, until *time.Time) (err error) {
+	if _, err := r.moderationTarget(ctx, chatID, adminID, targetID); err != nil {
+		return err
+	}
+
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, `DELETE FROM chat_participants WHERE chat_id=$1 AND user_id=$2`, chatID, targetID); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM chat_join_requests WHERE chat_id=$1 AND user_id=$2`, chatID, targetID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO chat_bans (chat_id, user_id, banned_by, until) VALUES ($1, $2, $3, $4)
+      ON CONFLICT (chat_id, user_id) DO UPDATE SET banned_by=EXCLUDED.banned_by, until=EXCLUDED.until, created_at=now()`,
+		chatID, targetID, adminID, until)
+	return err
+}
+
+func (r *Repository) Unban(ctx context.Context, chatID, adminID, targetID uuid.UUID) error {
+	if _, err := r.Authorize(ctx, chatID, adminID, ActionBan); err != nil {
+		return err
+	}
+	_, err := r.pool.Exec(ctx, `DELETE FROM chat_bans WHERE chat_id=$1 AND user_id=$2`, chatID, targetID)
+	return err
+}
+
+func (r *Repository) Bans(ctx context.Context, chatID, adminID uuid.UUID) ([]Ban, error) {
+	if _, err := r.Authorize(ctx, chatID, adminID, ActionBan); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.pool.Query(ctx, `SELECT user_id, banned_by, until, created_at FROM chat_bans
+      WHERE chat_id=$1 AND (until IS NULL OR until > now())
+      ORDER BY created_at DESC`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Ban
+	for rows.Next() {
+		var b Ban
+		if err := rows.Scan(&b.UserID, &b.BannedBy, &b.Until, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// moderationTarget checks that the actor holds the ban right and outranks
+// the target member.
+func (r *Repository) moderationTarget(ctx context.Context, chatID, actorID, targetID uuid.UUID) (*Member, error) {
+	actor, err := r.Authorize(ctx, chatID, actorID, ActionBan)
+	if err != nil {
+		return nil, err
+	}
+	target, err := r.membership(ctx, chatID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	if !target.Joined {
+		return nil, ErrNotParticipant
+	}
+	if !actor.Outranks(target) {
+		return nil, ErrNotAllowed
+	}
+	return target, nil
+}
+
+func isBanned(ctx context.Context, q pgx.Tx, chatID, userID uuid.UUID) (bool, error) {
+	var banned bool
+	err := q.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM chat_bans
+      WHERE chat_id=$1 AND user_id=$2 AND (until IS NULL OR until > now()))`, chatID, userID).Scan(&banned)
+	return banned, err
+}
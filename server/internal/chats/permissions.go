@@ -0,0 +1,216 @@
+package chats
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+type Role string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+func (r Role) Valid() bool {
+	return r == RoleOwner || r == RoleAdmin || r == RoleMember
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleOwner:
+		return 2
+	case RoleAdmin:
+		return 1
+	}
+	return 0
+}
+
+// Permission is the set of individual rights an admin holds. Owners hold
+// all of them implicitly; members hold none.
+type Permission uint32
+
+const (
+	PermChangeInfo Permission = 1 << iota
+	PermBan
+	PermPin
+	PermInvite
+	PermDeleteMessages
+
+	PermAll = PermChangeInfo | PermBan | PermPin | PermInvite | PermDeleteMessages
+)
+
+var permissionNames = map[Permission]string{
+	PermChangeInfo:     "change_info",
+	PermBan:            "ban",
+	PermPin:            "pin",
+	PermInvite:         "invite",
+	PermDeleteMessages: "delete_messages",
+}
+
+// Names lists the permissions in the set by their API names.
+func (p Permission) Names() []string {
+	names := []string{}
+	for bit := PermChangeInfo; bit <= PermDeleteMessages; bit <<= 1 {
+		if p&bit != 0 {
+			names = append(names, permissionNames[bit])
+		}
+	}
+	return names
+}
+
+func ParsePermissions(names []string) (Permission, error) {
+	var p Permission
+	for _, name := range names {
+		found := false
+		for bit, n := range permissionNames {
+			if n == name {
+				p |= bit
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, ErrInvalidPermission
+		}
+	}
+	return p, nil
+}
+
+// Action is something a participant attempts in a chat.
+type Action int
+
+const (
+	ActionRead Action = iota
+	ActionSend
+	ActionChangeInfo
+	ActionBan
+	ActionPin
+	ActionInvite
+	ActionDeleteMessages
+	ActionManageAdmins
+)
+
+var actionPermission = map[Action]Permission{
+	ActionChangeInfo:     PermChangeInfo,
+	ActionBan:            PermBan,
+	ActionPin:            PermPin,
+	ActionInvite:         PermInvite,
+	ActionDeleteMessages: PermDeleteMessages,
+}
+
+var (
+	ErrRestricted        = errors.New("chats: member is restricted from sending")
+	ErrBanned            = errors.New("chats: user is banned from this chat")
+	ErrInvalidPermission = errors.New("chats: unknown permission")
+)
+
+// Member describes a user's participation in a chat. Joined is false for
+// visitors reading a public channel they have not subscribed to; Banned is
+// set while an active ban keeps the user out of the chat.
+type Member struct {
+	ChatID          uuid.UUID
+	UserID          uuid.UUID
+	Kind            Kind
+	Public          bool
+	Joined          bool
+	Banned          bool
+	Role            Role
+	Permissions     Permission
+	RestrictedUntil *time.Time
+}
+
+// Can reports whether the member may perform the action at the given time.
+// Both sides of a direct chat may pin and change its settings; a saved-messages
+// chat only allows pinning on top of reading and sending. Group and channel
+// rights follow the role and the admin's individual permissions. Only
+// channel owners and admins post.
+func (m *Member) Can(action Action, now time.Time) bool {
+	if !m.Joined {
+		return action == ActionRead && m.Kind == KindChannel && m.Public
+	}
+
+	switch action {
+	case ActionRead:
+		return true
+	case ActionSend:
+		if m.Kind == KindChannel && m.Role == RoleMember {
+			return false
+		}
+		return m.RestrictedUntil == nil || !m.RestrictedUntil.After(now)
+	}
+
+	switch m.Kind {
+	case KindDirect:
+		return action == ActionPin || action == ActionChangeInfo
+	case KindSelf:
+		return action == ActionPin
+	}
+
+	switch m.Role {
+	case RoleOwner:
+		return true
+	case RoleAdmin:
+		perm, ok := actionPermission[action]
+		return ok && m.Permissions&perm != 0
+	}
+	return false
+}
+
+// Outranks reports whether the member may moderate target.
+func (m *Member) Outranks(target *Member) bool {
+	return m.Role.rank() > target.Role.rank()
+}
+
+// Authorize is the single permission check for chat endpoints. It returns
+// the caller's membership when the action is allowed. Banned users are
+// refused every action, reading a public channel included.
+func (r *Repository) Authorize(ctx context.Context, chatID, userID uuid.UUID, action Action) (*Member, error) {
+	m, err := r.membership(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if m.Banned {
+		return nil, ErrBanned
+	}
+	now := time.Now()
+	if m.Can(action, now) {
+		return m, nil
+	}
+	switch {
+	case !m.Joined:
+		return nil, ErrNotParticipant
+	case action == ActionSend && m.RestrictedUntil != nil && m.RestrictedUntil.After(now):
+		return nil, ErrRestricted
+	}
+	return nil, ErrNotAllowed
+}
+
+func (r *Repository) membership(ctx context.Context, chatID, userID uuid.UUID) (*Member, error) {
+	m := Member{ChatID: chatID, UserID: userID}
+	var role *Role
+	var perms *Permission
+	err := r.pool.QueryRow(ctx, `SELECT c.kind, c.is_public, p.role, p.permissions, p.restricted_until,
+        EXISTS (SELECT 1 FROM chat_bans b
+          WHERE b.chat_id = c.id AND b.user_id = $2 AND (b.until IS NULL OR b.until > now()))
+      FROM chats c
+      LEFT JOIN chat_participants p ON p.chat_id = c.id AND p.user_id = $2
+      WHERE c.id=$1`, chatID, userID).Scan(&m.Kind, &m.Public, &role, &perms, &m.RestrictedUntil, &m.Banned)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotParticipant
+		}
+		return nil, err
+	}
+	if role != nil {
+		m.Joined = true
+		m.Role = *role
+		m.Permissions = *perms
+	}
+	return &m, nil
+}
@@ -0,0 +1,290 @@
+package chats
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNotParticipant = errors.New("chats: user is not a chat participant")
+	ErrInvalidMembers = errors.New("chats: invalid member list")
+)
+
+type Kind string
+
+const (
+	KindDirect  Kind = "direct"
+	KindGroup   Kind = "group"
+	KindChannel Kind = "channel"
+	// KindSelf is the user's saved-messages chat; see SelfChat.
+	KindSelf Kind = "self"
+)
+
+// Valid reports whether clients may create chats of this kind.
+func (k Kind) Valid() bool {
+	return k == KindDirect || k == KindGroup || k == KindChannel
+}
+
+type Chat struct {
+	ID          uuid.UUID  `json:"id"`
+	Kind        Kind       `json:"kind"`
+	Title       *string    `json:"title,omitempty"`
+	AvatarID    *uuid.UUID `json:"avatarId,omitempty"`
+	IsPublic    bool       `json:"isPublic"`
+	MemberCount int        `json:"memberCount"`
+	Role        Role       `json:"role"`
+	CreatedAt   time.Time  `json:"createdAt"`
+
+	MessageTTLSeconds *int       `json:"messageTtlSeconds,omitempty"`
+	LastMessageAt     *time.Time `json:"lastMessageAt,omitempty"`
+	UnreadMentions    int        `json:"unreadMentions"`
+	Draft             *Draft     `json:"draft,omitempty"`
+
+	State
+}
+
+// State is the caller's private view of a chat, shared by all their devices.
+type State struct {
+	MutedUntil *time.Time `json:"mutedUntil"`
+	Archived   bool       `json:"archived"`
+	PinOrder   *int       `json:"pinOrder"`
+}
+
+type Repository struct {
+	pool *pgxpool.Pool
+}
+
+func NewRepository(pool *pgxpool.Pool) *Repository {
+	return &Repository{pool: pool}
+}
+
+// Create makes a new chat owned by creatorID. A direct chat has exactly one
+// other member and is reused if it already exists, even when two requests
+// race to open it; a channel may start with no subscribers. Only channels
+// can be public.
+func (r *Repository) Create(ctx context.Context, creatorID uuid.UUID, memberIDs []uuid.UUID, kind Kind, title *string, public bool) (chat *Chat, err error) {
+	members := uniqueWithout(memberIDs, creatorID)
+	switch kind {
+	case KindDirect:
+		if len(members) != 1 {
+			return nil, ErrInvalidMembers
+		}
+		title = nil
+	case KindGroup:
+		if len(members) == 0 {
+			return nil, ErrInvalidMembers
+		}
+	case KindChannel:
+	default:
+		return nil, ErrInvalidMembers
+	}
+	public = public && kind == KindChannel
+
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	var low, high *uuid.UUID
+	if kind == KindDirect {
+		lo, hi := orderedPair(creatorID, members[0])
+		low, high = &lo, &hi
+	}
+
+	chat = &Chat{Kind: kind, Title: title, IsPublic: public, MemberCount: len(members) + 1, Role: RoleOwner}
+	err = tx.QueryRow(ctx, `INSERT INTO chats (kind, title, is_public, created_by, direct_low, direct_high)
+      VALUES ($1, $2, $3, $4, $5, $6)
+      ON CONFLICT (direct_low, direct_high) WHERE kind = 'direct' DO NOTHING
+      RETURNING id, created_at`,
+		kind, title, public, creatorID, low, high,
+	).Scan(&chat.ID, &chat.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) && kind == KindDirect {
+		chat, err = findDirect(ctx, tx, *low, *high)
+		return chat, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.Exec(ctx, `INSERT INTO chat_participants (chat_id, user_id, role) VALUES ($1, $2, $3)`, chat.ID, creatorID, RoleOwner); err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return chat, nil
+	}
+	memberRole := RoleMember
+	if kind == KindDirect {
+		memberRole = RoleOwner
+	}
+	res, err := tx.Exec(ctx, `INSERT INTO chat_participants (chat_id, user_id, role)
+      SELECT $1, u.id, $3 FROM users u WHERE u.id = ANY($2)`, chat.ID, members, memberRole)
+	if err != nil {
+		return nil, err
+	}
+	if res.RowsAffected() != int64(len(members)) {
+		err = ErrInvalidMembers
+		return nil, err
+	}
+	return chat, nil
+}
+
+// List returns the user's chats, either the active or the archived ones.
+// Chats pinned to the top come first in pin order, then the most recently
+// active.
+func (r *Repository) List(ctx context.Context, userID uuid.UUID, archived bool) ([]Chat, error) {
+	rows, err := r.pool.Query(ctx, `SELECT c.id, c.kind, c.title, c.avatar_id, c.is_public, c.member_count, p.role, c.created_at, c.message_ttl_seconds,
+        last.created_at, p.muted_until, p.archived, p.pin_order,
+        (SELECT count(*) FROM message_mentions mm
+          JOIN messages m ON m.id = mm.message_id
+          WHERE mm.user_id = p.user_id AND mm.chat_id = c.id AND mm.read_at IS NULL
+            AND m.deleted_at IS NULL AND (m.expires_at IS NULL OR m.expires_at > now())),
+        d.ciphertext, d.device_id, d.version, d.updated_at
+      FROM chat_participants p
+      JOIN chats c ON c.id = p.chat_id
+      LEFT JOIN chat_drafts d ON d.user_id = p.user_id AND d.chat_id = c.id AND d.ciphertext IS NOT NULL
+      LEFT JOIN LATERAL (
+        SELECT m.created_at FROM messages m
+        WHERE m.chat_id = c.id AND (m.expires_at IS NULL OR m.expires_at > now())
+        ORDER BY m.created_at DESC
+        LIMIT 1
+      ) last ON TRUE
+      WHERE p.user_id=$1 AND p.archived=$2
+      ORDER BY p.pin_order ASC NULLS LAST, COALESCE(last.created_at, c.created_at) DESC`, userID, archived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Chat
+	for rows.Next() {
+		var ch Chat
+		var draft Draft
+		var draftAt *time.Time
+		if err := rows.Scan(&ch.ID, &ch.Kind, &ch.Title, &ch.AvatarID, &ch.IsPublic, &ch.MemberCount, &ch.Role, &ch.CreatedAt, &ch.MessageTTLSeconds,
+			&ch.LastMessageAt, &ch.MutedUntil, &ch.Archived, &ch.PinOrder, &ch.UnreadMentions,
+			&draft.Ciphertext, &draft.DeviceID, &draft.Version, &draftAt); err != nil {
+			return nil, err
+		}
+		if draftAt != nil {
+			draft.UpdatedAt = *draftAt
+			ch.Draft = &draft
+		}
+		out = append(out, ch)
+	}
+	return out, rows.Err()
+}
+
+// SetMessageTTL sets or clears (nil) the chat's disappearing-messages timer.
+func (r *Repository) SetMessageTTL(ctx context.Context, chatID, userID uuid.UUID, seconds *int) error {
+	if _, err := r.Authorize(ctx, chatID, userID, ActionChangeInfo); err != nil {
+		return err
+	}
+	_, err := r.pool.Exec(ctx, `UPDATE chats SET message_ttl_seconds=$2 WHERE id=$1`, chatID, seconds)
+	return err
+}
+
+// SetState replaces the user's private state for the chat.
+func (r *Repository) SetState(ctx context.Context, chatID, userID uuid.UUID, st State) error {
+	if _, err := r.Authorize(ctx, chatID, userID, ActionRead); err != nil {
+		return err
+	}
+	_, err := r.pool.Exec(ctx, `UPDATE chat_participants SET muted_until=$3, archived=$4, pin_order=$5
+      WHERE chat_id=$1 AND user_id=$2`, chatID, userID, st.MutedUntil, st.Archived, st.PinOrder)
+	return err
+}
+
+// RecipientIDs returns who receives real-time events for the chat. Channel
+// subscribers pull by cursor instead, so only channel admins are returned.
+func (r *Repository) RecipientIDs(ctx context.Context, chatID uuid.UUID) ([]uuid.UUID, error) {
+	return r.queryIDs(ctx, `SELECT p.user_id
+      FROM chat_participants p
+      JOIN chats c ON c.id = p.chat_id
+      WHERE p.chat_id=$1 AND (c.kind <> 'channel' OR p.role IN ('owner', 'admin'))`, chatID)
+}
+
+// PeerIDs returns every user that shares at least one direct or group chat
+// with userID.
+func (r *Repository) PeerIDs(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	return r.queryIDs(ctx, `SELECT DISTINCT p2.user_id
+      FROM chat_participants p1
+      JOIN chats c ON c.id = p1.chat_id AND c.kind <> 'channel'
+      JOIN chat_participants p2 ON p2.chat_id = p1.chat_id
+      WHERE p1.user_id=$1 AND p2.user_id<>$1`, userID)
+}
+
+// FilterPeers keeps only the candidates that share a direct or group chat
+// with userID.
+func (r *Repository) FilterPeers(ctx context.Context, userID uuid.UUID, candidates []uuid.UUID) ([]uuid.UUID, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	return r.queryIDs(ctx, `SELECT DISTINCT p2.user_id
+      FROM chat_participants p1
+      JOIN chats c ON c.id = p1.chat_id AND c.kind <> 'channel'
+      JOIN chat_participants p2 ON p2.chat_id = p1.chat_id
+      WHERE p1.user_id=$1 AND p2.user_id = ANY($2)`, userID, candidates)
+}
+
+// findDirect loads the direct chat between the ordered pair of users. The
+// INSERT that conflicted with it waited for its creator to commit, so the
+// row is visible here.
+func findDirect(ctx context.Context, tx pgx.Tx, low, high uuid.UUID) (*Chat, error) {
+	ch := Chat{Kind: KindDirect, MemberCount: 2, Role: RoleOwner}
+	err := tx.QueryRow(ctx, `SELECT id, created_at FROM chats
+      WHERE kind = 'direct' AND direct_low=$1 AND direct_high=$2`, low, high).Scan(&ch.ID, &ch.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+// orderedPair returns the two IDs in the order Postgres sorts UUIDs.
+func orderedPair(a, b uuid.UUID) (uuid.UUID, uuid.UUID) {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		return b, a
+	}
+	return a, b
+}
+
+func (r *Repository) queryIDs(ctx context.Context, sql string, args ...any) ([]uuid.UUID, error) {
+	rows, err := r.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func uniqueWithout(ids []uuid.UUID, skip uuid.UUID) []uuid.UUID {
+	seen := map[uuid.UUID]bool{skip: true}
+	var out []uuid.UUID
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package chats
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// SelfChat returns the user's saved-messages chat, creating it on first use.
+// Its only participant is the user, and its membership never changes.
+func (r *Repository) SelfChat(ctx context.Context, userID uuid.UUID) (chat *Chat, err error) {
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	chat = &Chat{Kind: KindSelf, MemberCount: 1, Role: RoleOwner}
+	err = tx.QueryRow(ctx, `INSERT INTO chats (kind, created_by) VALUES ($1, $2)
+      ON CONFLICT (created_by) WHERE kind = 'self' DO NOTHING
+      RETURNING id, created_at`, KindSelf, userID).Scan(&chat.ID, &chat.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = tx.QueryRow(ctx, `SELECT id, created_at, message_ttl_seconds FROM chats WHERE kind = $1 AND created_by = $2`,
+			KindSelf, userID).Scan(&chat.ID, &chat.CreatedAt, &chat.MessageTTLSeconds)
+		return chat, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO chat_participants (chat_id, user_id, role) VALUES ($1, $2, $3)`, chat.ID, userID, RoleOwner)
+	return chat, err
+}
@@ -0,0 +1,50 @@
+package chats
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/db"
+)
+
+// Match is a chat found by title. Role is only set for members; other
+// matches are public channels the caller may subscribe to.
+type Match struct {
+	ID          uuid.UUID  `json:"id"`
+	Kind        Kind       `json:"kind"`
+	Title       *string    `json:"title,omitempty"`
+	AvatarID    *uuid.UUID `json:"avatarId,omitempty"`
+	IsPublic    bool       `json:"isPublic"`
+	MemberCount int        `json:"memberCount"`
+	Role        *Role      `json:"role,omitempty"`
+}
+
+// Search finds the caller's own chats and public channels by title. The
+// caller's chats rank first, then by trigram word similarity and size.
+func (r *Repository) Search(ctx context.Context, userID uuid.UUID, text string, limit, offset int) ([]Match, error) {
+	rows, err := r.pool.Query(ctx, `SELECT c.id, c.kind, c.title, c.avatar_id, c.is_public, c.member_count, p.role
+      FROM chats c
+      LEFT JOIN chat_participants p ON p.chat_id = c.id AND p.user_id = $1
+      WHERE c.title IS NOT NULL
+        AND (c.title ILIKE $3 OR $2 <% c.title)
+        AND (p.user_id IS NOT NULL OR (c.kind = 'channel' AND c.is_public
+          AND NOT EXISTS (SELECT 1 FROM chat_bans b
+            WHERE b.chat_id = c.id AND b.user_id = $1 AND (b.until IS NULL OR b.until > now()))))
+      ORDER BY p.user_id IS NOT NULL DESC, word_similarity($2, c.title) DESC, c.member_count DESC, c.id
+      LIMIT $4 OFFSET $5`, userID, text, db.ContainsPattern(text), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Match, 0, limit)
+	for rows.Next() {
+		var m Match
+		if err := rows.Scan(&m.ID, &m.Kind, &m.Title, &m.AvatarID, &m.IsPublic, &m.MemberCount, &m.Role); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"os"
@@ -15,6 +17,11 @@ var (
 	ErrMissingDatabaseURL = errors.New("config: DATABASE_URL must be provided")
 	ErrWeakAccessSecret   = errors.New("config: ACCESS_SECRET must be at least 32 characters")
 	ErrWeakRefreshSecret  = errors.New("config: REFRESH_SECRET must be at least 32 characters")
+	ErrInvalidKTKey       = errors.New("config: KT_SIGNING_KEY must be a base64 Ed25519 seed of 32 bytes")
+	ErrWeakMediaSecret    = errors.New("config: MEDIA_URL_SECRET must be at least 32 characters")
+	ErrInvalidBlobStore   = errors.New("config: BLOB_BACKEND must be fs with BLOB_DIR, or s3 with S3_ENDPOINT, S3_BUCKET and S3 keys")
+	ErrInvalidScanner     = errors.New("config: SCANNER must be none, or clamav with CLAMAV_ADDR")
+	ErrInvalidPushSender  = errors.New("config: PUSH_SENDER must be none or log")
 )
 
 type Config struct {
@@ -30,12 +37,81 @@ type Config struct {
 	RefreshSecret  string
 	AccessTTL      time.Duration
 	RefreshTTL     time.Duration
+	PresenceTTL    time.Duration
+	TypingTTL      time.Duration
+
+	MessageEditWindow   time.Duration
+	ExpirySweepInterval time.Duration
+	SchedulerInterval   time.Duration
+	// CounterFlushInterval is how often channel view and subscriber counts
+	// catch up with recorded views and joins.
+	CounterFlushInterval time.Duration
+
+	// PushSender delivers push notifications: "none" drops them until a
+	// provider is wired, "log" logs each one for local debugging.
+	PushSender string
+
+	// KTSigningKey is the base64 Ed25519 seed that signs key transparency
+	// tree heads. Rotating it invalidates every head clients have stored.
+	KTSigningKey string
+
+	// BlobBackend selects where attachments are stored: "fs" keeps them
+	// under BlobDir, "s3" in an S3-compatible bucket.
+	BlobBackend string
+	BlobDir     string
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+
+	// MediaURLSecret signs download URLs, which stay valid for MediaURLTTL.
+	// MediaBaseURL is where clients fetch them, e.g. a CDN in front of
+	// /v1/media; by default the URLs are relative to the API.
+	MediaURLSecret string
+	MediaURLTTL    time.Duration
+	MediaBaseURL   string
+
+	// ImageBaseURL is where clients fetch avatar renditions, e.g. a CDN in
+	// front of /v1/images.
+	ImageBaseURL string
+
+	// StorageQuotaFree and StorageQuotaPremium are the bytes a user of each
+	// tier may store. Unreferenced attachments are collected every
+	// AttachmentGCInterval once AttachmentGCGrace has passed.
+	StorageQuotaFree     int64
+	StorageQuotaPremium  int64
+	AttachmentGCInterval time.Duration
+	AttachmentGCGrace    time.Duration
+
+	// Scanner checks public attachments and avatars before they are served:
+	// "none" passes everything, "clamav" streams them to clamd at ClamAVAddr.
+	Scanner       string
+	ClamAVAddr    string
+	ClamAVTimeout time.Duration
+	ScanInterval  time.Duration
+
+	// AdminToken guards the /v1/admin routes; they are disabled when empty.
+	AdminToken string
 }
 
 func FromEnv() Config {
 	ttlSeconds, _ := strconv.Atoi(getenv("OTP_TTL_SECONDS", "300"))
 	accessTTL := parseDuration(getenv("ACCESS_TOKEN_TTL", "15m"), 15*time.Minute)
 	refreshTTL := parseDuration(getenv("REFRESH_TOKEN_TTL", "720h"), 30*24*time.Hour)
+	presenceTTL := parseDuration(getenv("PRESENCE_TTL", "60s"), time.Minute)
+	typingTTL := parseDuration(getenv("TYPING_TTL", "6s"), 6*time.Second)
+	editWindow := parseDuration(getenv("MESSAGE_EDIT_WINDOW", "48h"), 48*time.Hour)
+	sweepInterval := parseDuration(getenv("EXPIRY_SWEEP_INTERVAL", "30s"), 30*time.Second)
+	schedulerInterval := parseDuration(getenv("SCHEDULER_INTERVAL", "5s"), 5*time.Second)
+	counterInterval := parseDuration(getenv("COUNTER_FLUSH_INTERVAL", "10s"), 10*time.Second)
+	mediaURLTTL := parseDuration(getenv("MEDIA_URL_TTL", "1h"), time.Hour)
+	quotaFree, _ := strconv.ParseInt(getenv("STORAGE_QUOTA_FREE", "5368709120"), 10, 64)
+	quotaPremium, _ := strconv.ParseInt(getenv("STORAGE_QUOTA_PREMIUM", "107374182400"), 10, 64)
+	gcInterval := parseDuration(getenv("ATTACHMENT_GC_INTERVAL", "10m"), 10*time.Minute)
+	gcGrace := parseDuration(getenv("ATTACHMENT_GC_GRACE", "24h"), 24*time.Hour)
+	clamTimeout := parseDuration(getenv("CLAMAV_TIMEOUT", "1m"), time.Minute)
+	scanInterval := parseDuration(getenv("SCAN_INTERVAL", "30s"), 30*time.Second)
 
 	return Config{
 		InfobipBaseURL: getenv("INFOBIP_BASE_URL", ""),
@@ -50,6 +126,42 @@ func FromEnv() Config {
 		RefreshSecret:  getenv("REFRESH_SECRET", "change_me_refresh"),
 		AccessTTL:      accessTTL,
 		RefreshTTL:     refreshTTL,
+		PresenceTTL:    presenceTTL,
+		TypingTTL:      typingTTL,
+
+		MessageEditWindow:    editWindow,
+		ExpirySweepInterval:  sweepInterval,
+		SchedulerInterval:    schedulerInterval,
+		CounterFlushInterval: counterInterval,
+
+		PushSender: getenv("PUSH_SENDER", "none"),
+
+		KTSigningKey: getenv("KT_SIGNING_KEY", ""),
+
+		BlobBackend: getenv("BLOB_BACKEND", "fs"),
+		BlobDir:     getenv("BLOB_DIR", "data/blobs"),
+		S3Endpoint:  getenv("S3_ENDPOINT", ""),
+		S3Region:    getenv("S3_REGION", "us-east-1"),
+		S3Bucket:    getenv("S3_BUCKET", ""),
+		S3AccessKey: getenv("S3_ACCESS_KEY", ""),
+		S3SecretKey: getenv("S3_SECRET_KEY", ""),
+
+		MediaURLSecret: getenv("MEDIA_URL_SECRET", "change_me_media"),
+		MediaURLTTL:    mediaURLTTL,
+		MediaBaseURL:   strings.TrimSuffix(getenv("MEDIA_BASE_URL", "/v1/media"), "/"),
+		ImageBaseURL:   strings.TrimSuffix(getenv("IMAGE_BASE_URL", "/v1/images"), "/"),
+
+		StorageQuotaFree:     quotaFree,
+		StorageQuotaPremium:  quotaPremium,
+		AttachmentGCInterval: gcInterval,
+		AttachmentGCGrace:    gcGrace,
+
+		Scanner:       getenv("SCANNER", "none"),
+		ClamAVAddr:    getenv("CLAMAV_ADDR", ""),
+		ClamAVTimeout: clamTimeout,
+		ScanInterval:  scanInterval,
+
+		AdminToken: getenv("ADMIN_TOKEN", ""),
 	}
 }
 
@@ -75,6 +187,69 @@ func (c Config) Validate() error {
 	if c.RefreshTTL <= 0 || c.RefreshTTL < c.AccessTTL {
 		return fmt.Errorf("refresh ttl must be > access ttl")
 	}
+	if c.PresenceTTL < 10*time.Second {
+		return fmt.Errorf("presence ttl must be >= 10s")
+	}
+	if c.TypingTTL <= 0 {
+		return fmt.Errorf("typing ttl must be > 0")
+	}
+	if c.MessageEditWindow < 0 {
+		return fmt.Errorf("message edit window must be >= 0")
+	}
+	if c.ExpirySweepInterval <= 0 {
+		return fmt.Errorf("expiry sweep interval must be > 0")
+	}
+	if c.SchedulerInterval <= 0 {
+		return fmt.Errorf("scheduler interval must be > 0")
+	}
+	if c.CounterFlushInterval <= 0 {
+		return fmt.Errorf("counter flush interval must be > 0")
+	}
+	if seed, err := base64.StdEncoding.DecodeString(c.KTSigningKey); err != nil || len(seed) != ed25519.SeedSize {
+		return ErrInvalidKTKey
+	}
+	if err := validateSecret(c.MediaURLSecret, ErrWeakMediaSecret); err != nil {
+		return err
+	}
+	if c.MediaURLTTL < time.Minute {
+		return fmt.Errorf("media url ttl must be >= 1m")
+	}
+	if c.StorageQuotaFree <= 0 || c.StorageQuotaPremium < c.StorageQuotaFree {
+		return fmt.Errorf("storage quotas must be > 0 and premium >= free")
+	}
+	if c.AttachmentGCInterval <= 0 {
+		return fmt.Errorf("attachment gc interval must be > 0")
+	}
+	if c.AttachmentGCGrace < time.Hour {
+		return fmt.Errorf("attachment gc grace must be >= 1h")
+	}
+	if c.PushSender != "none" && c.PushSender != "log" {
+		return ErrInvalidPushSender
+	}
+	switch {
+	case c.Scanner == "none":
+	case c.Scanner == "clamav" && c.ClamAVAddr != "":
+	default:
+		return ErrInvalidScanner
+	}
+	if c.ClamAVTimeout <= 0 || c.ScanInterval <= 0 {
+		return fmt.Errorf("clamav timeout and scan interval must be > 0")
+	}
+	if c.AdminToken != "" && len(c.AdminToken) < 32 {
+		return fmt.Errorf("admin token must be at least 32 characters")
+	}
+	switch c.BlobBackend {
+	case "fs":
+		if strings.TrimSpace(c.BlobDir) == "" {
+			return ErrInvalidBlobStore
+		}
+	case "s3":
+		if c.S3Endpoint == "" || c.S3Bucket == "" || c.S3AccessKey == "" || c.S3SecretKey == "" {
+			return ErrInvalidBlobStore
+		}
+	default:
+		return ErrInvalidBlobStore
+	}
 	return nil
 }
 
@@ -0,0 +1,11 @@
+package db
+
+import "strings"
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ContainsPattern builds an ILIKE pattern matching text anywhere, with the
+// pattern's wildcards in text escaped.
+func ContainsPattern(text string) string {
+	return "%" + likeEscaper.Replace(text) + "%"
+}
@@ -0,0 +1,293 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+const (
+	// MaxUploadSize bounds the encoded upload.
+	MaxUploadSize = 10 << 20
+	// MaxDimension and MaxPixels bound the decoded image, which is checked
+	// from the header before any pixels are decoded.
+	MaxDimension = 8192
+	MaxPixels    = 24_000_000
+
+	jpegQuality = 85
+)
+
+// Sizes are the square renditions generated for every avatar, in pixels.
+// Smaller sources are not scaled up.
+var Sizes = []int{96, 320, 640}
+
+var (
+	ErrUnsupported = errors.New("images: unsupported image type")
+	ErrTooLarge    = errors.New("images: image is too large")
+	ErrCorrupt     = errors.New("images: image cannot be decoded")
+)
+
+// Rendition is one encoded size of a processed image.
+type Rendition struct {
+	Size        int
+	Width       int
+	Height      int
+	ContentType string
+	Data        []byte
+}
+
+// process validates an uploaded image and renders it at every size as a
+// center-cropped square JPEG. Re-encoding from pixels drops EXIF, GPS and
+// any other metadata; the EXIF orientation is applied to each rendition.
+// Renditions are JPEG only: the standard library has no WebP encoder, and
+// WebP output is out of scope until the server takes on a codec dependency.
+//
+// The square is cropped and scaled down before it is flattened and oriented,
+// so the only full-resolution copy besides the decoded source is the crop.
+// Rotations and flips map the centered square onto itself, so cropping
+// before orienting picks the same pixels.
+func process(data []byte) ([]Rendition, error) {
+	if len(data) > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+	sniffed := http.DetectContentType(data)
+	switch sniffed {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, ErrUnsupported
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	if "image/"+format != sniffed {
+		return nil, ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width > MaxDimension || cfg.Height > MaxDimension ||
+		cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	// A GIF's first frame may be smaller than its canvas, never larger.
+	if b := src.Bounds(); b.Empty() || b.Dx() > cfg.Width || b.Dy() > cfg.Height {
+		return nil, ErrCorrupt
+	}
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+
+	square := cropSquare(src)
+	src = nil // let the decoded source be collected while encoding
+
+	out := make([]Rendition, 0, len(Sizes))
+	for _, size := range Sizes {
+		side := min(size, square.Bounds().Dx())
+		img := orient(flatten(resize(square, side, side)), orientation)
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+		out = append(out, Rendition{Size: size, Width: side, Height: side, ContentType: "image/jpeg", Data: buf.Bytes()})
+	}
+	return out, nil
+}
+
+// cropSquare copies the centered square of src into RGBA with alpha
+// premultiplied, which keeps scaling correct for transparent pixels.
+func cropSquare(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+	at := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), src, at, draw.Src)
+	return dst
+}
+
+// flatten composites img over a white background in place, since JPEG has
+// no transparency. Opaque pixels are left as they are, so flattening twice
+// changes nothing.
+func flatten(img *image.RGBA) *image.RGBA {
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		a := img.Pix[i+3]
+		if a == 0xff {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			img.Pix[i+c] += 0xff - a
+		}
+		img.Pix[i+3] = 0xff
+	}
+	return img
+}
+
+// resize scales img to w×h by area averaging, which suits downscaling.
+func resize(img *image.RGBA, w, h int) *image.RGBA {
+	b := img.Bounds()
+	if b.Dx() == w && b.Dy() == h {
+		return img
+	}
+	// Horizontal pass into a float buffer, then vertical into the result.
+	tmp := make([]float32, w*b.Dy()*4)
+	xw := weights(b.Dx(), w)
+	for y := 0; y < b.Dy(); y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, b.Min.Y+y):]
+		for x, ws := range xw {
+			var acc [4]float32
+			for _, t := range ws {
+				p := row[t.src*4:]
+				for c := 0; c < 4; c++ {
+					acc[c] += float32(p[c]) * t.w
+				}
+			}
+			copy(tmp[(y*w+x)*4:], acc[:])
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	yw := weights(b.Dy(), h)
+	for y, ws := range yw {
+		for x := 0; x < w; x++ {
+			var acc [4]float32
+			for _, t := range ws {
+				p := tmp[(t.src*w+x)*4:]
+				for c := 0; c < 4; c++ {
+					acc[c] += p[c] * t.w
+				}
+			}
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8(min(max(acc[c]+0.5, 0), 255))
+			}
+		}
+	}
+	return dst
+}
+
+type tap struct {
+	src int
+	w   float32
+}
+
+// weights lists, for each of the n destination pixels, the source pixels
+// it covers and by how much, normalized to sum to one.
+func weights(from, n int) [][]tap {
+	scale := float64(from) / float64(n)
+	out := make([][]tap, n)
+	for i := range out {
+		lo, hi := float64(i)*scale, float64(i+1)*scale
+		for s := int(lo); s < from && float64(s) < hi; s++ {
+			cover := min(hi, float64(s+1)) - max(lo, float64(s))
+			if cover > 0 {
+				out[i] = append(out[i], tap{src: s, w: float32(cover / scale)})
+			}
+		}
+	}
+	return out
+}
+
+// orient applies an EXIF orientation (1-8) so the pixels are upright.
+func orient(img *image.RGBA, o int) *image.RGBA {
+	if o < 2 || o > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			s := img.PixOffset(x, y)
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], img.Pix[s:s+4])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag from a JPEG's APP1
+// segment, returning 1 (upright) when there is none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			return 1
+		}
+		seg := data[i+4 : i+2+n]
+		if marker == 0xE1 && len(seg) > 6 && string(seg[:6]) == "Exif\x00\x00" {
+			return tiffOrientation(seg[6:])
+		}
+		i += 2 + n
+	}
+	return 1
+}
+
+func tiffOrientation(t []byte) int {
+	if len(t) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(t[4:]))
+	if ifd < 8 || ifd+2 > len(t) {
+		return 1
+	}
+	count := int(order.Uint16(t[ifd:]))
+	for k := 0; k < count; k++ {
+		e := ifd + 2 + k*12
+		if e+12 > len(t) {
+			return 1
+		}
+		// Orientation is tag 0x0112, a single SHORT.
+		if order.Uint16(t[e:]) == 0x0112 && order.Uint16(t[e+2:]) == 3 {
+			return int(order.Uint16(t[e+8:]))
+		}
+	}
+	return 1
+}
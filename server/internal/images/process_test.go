@@ -0,0 +1,339 @@
+package images
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"testing"
+)
+
+// withOrientation inserts an EXIF APP1 segment carrying the orientation tag
+// right after a JPEG's SOI marker.
+func withOrientation(t *testing.T, data []byte, o uint16, order binary.ByteOrder) []byte {
+	t.Helper()
+	tiff := make([]byte, 8+2+12+4)
+	if order == binary.LittleEndian {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], 1)
+	order.PutUint16(tiff[10:], 0x0112)
+	order.PutUint16(tiff[12:], 3)
+	order.PutUint32(tiff[14:], 1)
+	order.PutUint16(tiff[18:], o)
+
+	seg := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(seg)+2))
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	out = append(out, seg...)
+	return append(out, data[2:]...)
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngClaiming rewrites a PNG's header to claim other dimensions without
+// encoding that many pixels.
+func pngClaiming(t *testing.T, w, h uint32) []byte {
+	t.Helper()
+	data := encodePNG(t, image.NewGray(image.Rect(0, 0, 1, 1)))
+	// Signature (8), IHDR length (4), "IHDR" (4), width, height, ..., CRC.
+	binary.BigEndian.PutUint32(data[16:], w)
+	binary.BigEndian.PutUint32(data[20:], h)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+func near(got color.Color, want color.RGBA) bool {
+	r, g, b, _ := got.RGBA()
+	d := func(a uint32, b uint8) bool { return math.Abs(float64(a>>8)-float64(b)) <= 24 }
+	return d(r, want.R) && d(g, want.G) && d(b, want.B)
+}
+
+var (
+	red   = color.RGBA{0xff, 0, 0, 0xff}
+	blue  = color.RGBA{0, 0, 0xff, 0xff}
+	white = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+func TestJPEGOrientation(t *testing.T) {
+	plain := encodeJPEG(t, image.NewRGBA(image.Rect(0, 0, 8, 8)))
+	if o := jpegOrientation(plain); o != 1 {
+		t.Errorf("orientation without EXIF = %d, want 1", o)
+	}
+	for o := uint16(1); o <= 8; o++ {
+		for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			if got := jpegOrientation(withOrientation(t, plain, o, order)); got != int(o) {
+				t.Errorf("orientation %d (%v) read as %d", o, order, got)
+			}
+		}
+	}
+	// Truncated or malformed segments fall back to upright.
+	tagged := withOrientation(t, plain, 6, binary.BigEndian)
+	for _, data := range [][]byte{tagged[:8], tagged[:20], {0xFF, 0xD8, 0x00}, []byte("not a jpeg")} {
+		if o := jpegOrientation(data); o != 1 {
+			t.Errorf("orientation of malformed data = %d, want 1", o)
+		}
+	}
+}
+
+func TestOrient(t *testing.T) {
+	const w, h = 3, 2
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	at := func(x, y int) color.RGBA { return color.RGBA{uint8(x), uint8(y), 0, 0xff} }
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			src.SetRGBA(x, y, at(x, y))
+		}
+	}
+
+	// Which stored pixel each orientation shows at the top left, and
+	// whether it swaps width and height.
+	for _, tc := range []struct {
+		o        int
+		topLeft  color.RGBA
+		swapped  bool
+		topRight color.RGBA
+	}{
+		{1, at(0, 0), false, at(w-1, 0)},
+		{2, at(w-1, 0), false, at(0, 0)},
+		{3, at(w-1, h-1), false, at(0, h-1)},
+		{4, at(0, h-1), false, at(w-1, h-1)},
+		{5, at(0, 0), true, at(0, h-1)},
+		{6, at(0, h-1), true, at(0, 0)},
+		{7, at(w-1, h-1), true, at(w-1, 0)},
+		{8, at(w-1, 0), true, at(w-1, h-1)},
+	} {
+		got := orient(src, tc.o)
+		dw, dh := w, h
+		if tc.swapped {
+			dw, dh = h, w
+		}
+		if b := got.Bounds(); b.Dx() != dw || b.Dy() != dh {
+			t.Errorf("orientation %d: %dx%d, want %dx%d", tc.o, b.Dx(), b.Dy(), dw, dh)
+			continue
+		}
+		if c := got.RGBAAt(0, 0); c != tc.topLeft {
+			t.Errorf("orientation %d: top left is %v, want %v", tc.o, c, tc.topLeft)
+		}
+		if c := got.RGBAAt(dw-1, 0); c != tc.topRight {
+			t.Errorf("orientation %d: top right is %v, want %v", tc.o, c, tc.topRight)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	fill(src, image.Rect(0, 0, 2, 2), color.RGBA{200, 0, 0, 0xff})
+	fill(src, image.Rect(2, 0, 4, 2), color.RGBA{0, 100, 0, 0xff})
+	fill(src, image.Rect(0, 2, 2, 4), color.RGBA{0, 0, 50, 0xff})
+	fill(src, image.Rect(2, 2, 4, 4), color.RGBA{10, 20, 30, 0xff})
+	src.SetRGBA(3, 3, color.RGBA{14, 24, 34, 0xff})
+
+	got := resize(src, 2, 2)
+	for _, tc := range []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{0, 0, color.RGBA{200, 0, 0, 0xff}},
+		{1, 0, color.RGBA{0, 100, 0, 0xff}},
+		{0, 1, color.RGBA{0, 0, 50, 0xff}},
+		{1, 1, color.RGBA{11, 21, 31, 0xff}},
+	} {
+		if c := got.RGBAAt(tc.x, tc.y); c != tc.want {
+			t.Errorf("pixel (%d, %d) = %v, want the block average %v", tc.x, tc.y, c, tc.want)
+		}
+	}
+
+	if resize(src, 4, 4) != src {
+		t.Error("resize to the same size copied the image")
+	}
+
+	// Uneven ratios still weigh every source pixel fully.
+	gray := image.NewRGBA(image.Rect(0, 0, 7, 5))
+	fill(gray, gray.Bounds(), color.RGBA{90, 90, 90, 0xff})
+	small := resize(gray, 3, 2)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			if c := small.RGBAAt(x, y); c != (color.RGBA{90, 90, 90, 0xff}) {
+				t.Fatalf("uniform image resized unevenly: pixel (%d, %d) = %v", x, y, c)
+			}
+		}
+	}
+	for _, n := range []int{1, 2, 3, 5, 6} {
+		for i, taps := range weights(7, n) {
+			var sum float32
+			for _, tp := range taps {
+				sum += tp.w
+			}
+			if math.Abs(float64(sum)-1) > 1e-5 {
+				t.Errorf("weights(7, %d)[%d] sum to %v", n, i, sum)
+			}
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{0, 0, 0, 0})       // transparent
+	img.SetRGBA(1, 0, color.RGBA{0x80, 0, 0, 0x80}) // half-transparent red, premultiplied
+	img.SetRGBA(2, 0, color.RGBA{1, 2, 3, 0xff})    // opaque
+	flatten(img)
+
+	for x, want := range []color.RGBA{white, {0xff, 0x7f, 0x7f, 0xff}, {1, 2, 3, 0xff}} {
+		if c := img.RGBAAt(x, 0); c != want {
+			t.Errorf("pixel %d = %v, want %v", x, c, want)
+		}
+	}
+	before := append([]byte(nil), img.Pix...)
+	if !bytes.Equal(flatten(img).Pix, before) {
+		t.Error("flattening twice changed the image")
+	}
+}
+
+func TestProcessRenditions(t *testing.T) {
+	// A wide image: the centered square is red, the margins blue.
+	src := image.NewRGBA(image.Rect(0, 0, 1200, 800))
+	fill(src, src.Bounds(), blue)
+	fill(src, image.Rect(200, 0, 1000, 800), red)
+
+	out, err := process(encodeJPEG(t, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != len(Sizes) {
+		t.Fatalf("%d renditions, want %d", len(out), len(Sizes))
+	}
+	for i, r := range out {
+		if r.Size != Sizes[i] || r.Width != Sizes[i] || r.Height != Sizes[i] || r.ContentType != "image/jpeg" {
+			t.Errorf("rendition %d = %dpx %dx%d %s", i, r.Size, r.Width, r.Height, r.ContentType)
+		}
+		img, err := jpeg.Decode(bytes.NewReader(r.Data))
+		if err != nil {
+			t.Fatalf("rendition %d: %v", i, err)
+		}
+		if b := img.Bounds(); b.Dx() != r.Width || b.Dy() != r.Height {
+			t.Errorf("rendition %d decodes as %v", i, b)
+		}
+		for _, p := range []image.Point{{1, 1}, {r.Width - 2, r.Height - 2}, {r.Width / 2, r.Height / 2}} {
+			if c := img.At(p.X, p.Y); !near(c, red) {
+				t.Errorf("rendition %d: pixel %v = %v, want the red center only", i, p, c)
+			}
+		}
+	}
+
+	// Small sources are not scaled up.
+	out, err = process(encodePNG(t, image.NewRGBA(image.Rect(0, 0, 50, 70))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range out {
+		if r.Width != 50 || r.Height != 50 {
+			t.Errorf("%dpx rendition of a 50px source is %dx%d", r.Size, r.Width, r.Height)
+		}
+	}
+}
+
+func TestProcessAppliesOrientation(t *testing.T) {
+	// Stored top half red, bottom half blue. Orientation 6 is displayed
+	// rotated 90° clockwise, which puts the stored top on the right.
+	src := image.NewRGBA(image.Rect(0, 0, 128, 128))
+	fill(src, image.Rect(0, 0, 128, 64), red)
+	fill(src, image.Rect(0, 64, 128, 128), blue)
+	data := withOrientation(t, encodeJPEG(t, src), 6, binary.BigEndian)
+
+	out, err := process(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	side := out[0].Width
+	if c := img.At(side/4, side/2); !near(c, blue) {
+		t.Errorf("left side = %v, want blue", c)
+	}
+	if c := img.At(side*3/4, side/2); !near(c, red) {
+		t.Errorf("right side = %v, want red", c)
+	}
+	for _, r := range out {
+		if bytes.Contains(r.Data, []byte("Exif")) || jpegOrientation(r.Data) != 1 {
+			t.Errorf("%dpx rendition kept EXIF metadata", r.Size)
+		}
+	}
+}
+
+func TestProcessFlattensTransparency(t *testing.T) {
+	out, err := process(encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 32, 32))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := img.At(16, 16); !near(c, white) {
+		t.Errorf("transparent pixel = %v, want white", c)
+	}
+}
+
+func TestProcessLimits(t *testing.T) {
+	valid := encodePNG(t, image.NewGray(image.Rect(0, 0, 4, 4)))
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"over the upload size", append(append([]byte{}, valid...), make([]byte, MaxUploadSize)...), ErrTooLarge},
+		{"too wide", pngClaiming(t, MaxDimension+1, 1), ErrTooLarge},
+		{"too tall", pngClaiming(t, 1, MaxDimension+1), ErrTooLarge},
+		{"too many pixels", pngClaiming(t, 5000, 5000), ErrTooLarge},
+		{"zero width", pngClaiming(t, 0, 10), ErrCorrupt},
+		{"webp", []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00"), ErrUnsupported},
+		{"text", []byte("hello, world"), ErrUnsupported},
+		{"truncated", valid[:len(valid)-20], ErrCorrupt},
+	} {
+		if _, err := process(tc.data); !errors.Is(err, tc.want) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	if _, err := process(pngClaiming(t, MaxDimension, MaxPixels/MaxDimension)); errors.Is(err, ErrTooLarge) {
+		t.Error("an image at the limits was rejected as too large")
+	}
+}
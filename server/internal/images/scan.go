@@ -0,0 +1,151 @@
+package images
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kentapp/kent/server/internal/scan"
+)
+
+// Quarantined is a rendition the scanner flagged or could not scan.
+type Quarantined struct {
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	Avatars   int       `json:"avatars"`
+	Reason    *string   `json:"reason,omitempty"`
+	ScannedAt time.Time `json:"scannedAt"`
+}
+
+// RunScanner scans new renditions every interval, and as soon as an upload
+// stores some, until ctx is cancelled.
+func (s *Service) RunScanner(ctx context.Context, scanner scan.Scanner, interval time.Duration) {
+	scan.Run(ctx, scanQueue{s}, s.store, scanner, interval, s.scanWake)
+}
+
+func (s *Service) wakeScanner() {
+	select {
+	case s.scanWake <- struct{}{}:
+	default:
+	}
+}
+
+type scanQueue struct{ s *Service }
+
+func (q scanQueue) Claim(ctx context.Context, limit int) ([]scan.Item, error) {
+	rows, err := q.s.pool.Query(ctx, `UPDATE images
+      SET scan_attempts = scan_attempts + 1, scan_claimed_until = now() + make_interval(secs => $2)
+      WHERE digest IN (
+        SELECT digest FROM images
+        WHERE scan_status = 'pending'
+          AND (scan_claimed_until IS NULL OR scan_claimed_until < now())
+        ORDER BY created_at
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED)
+      RETURNING digest, scan_attempts`, limit, scan.ClaimTTL.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []scan.Item
+	for rows.Next() {
+		var digest []byte
+		var item scan.Item
+		if err := rows.Scan(&digest, &item.Attempts); err != nil {
+			return nil, err
+		}
+		item.ID, item.Key = hex.EncodeToString(digest), imageKey(digest)
+		out = append(out, item)
+	}
+	return out, rows.Err()
+}
+
+func (q scanQueue) Resolve(ctx context.Context, item scan.Item, status scan.Status, reason string) error {
+	digest, err := hex.DecodeString(item.ID)
+	if err != nil {
+		return err
+	}
+	_, err = q.s.pool.Exec(ctx, `UPDATE images
+      SET scan_status=$2, scan_result=NULLIF($3, ''), scanned_at=now(), scan_claimed_until=NULL
+      WHERE digest=$1 AND scan_status = 'pending'`, digest, status, reason)
+	return err
+}
+
+// Quarantined lists quarantined renditions, most recently scanned first,
+// with how many avatars use each.
+func (s *Service) Quarantined(ctx context.Context, limit int) ([]Quarantined, error) {
+	rows, err := s.pool.Query(ctx, `SELECT i.digest, i.size,
+        (SELECT count(DISTINCT ai.avatar_id) FROM avatar_images ai WHERE ai.digest = i.digest),
+        i.scan_result, i.scanned_at
+      FROM images i
+      WHERE i.scan_status = 'quarantined'
+      ORDER BY i.scanned_at DESC
+      LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Quarantined, 0)
+	for rows.Next() {
+		var q Quarantined
+		var digest []byte
+		if err := rows.Scan(&digest, &q.Size, &q.Avatars, &q.Reason, &q.ScannedAt); err != nil {
+			return nil, err
+		}
+		q.Digest = hex.EncodeToString(digest)
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// Release clears a quarantined rendition for serving.
+func (s *Service) Release(ctx context.Context, digest []byte) error {
+	tag, err := s.pool.Exec(ctx, `UPDATE images SET scan_status = 'released'
+      WHERE digest=$1 AND scan_status = 'quarantined'`, digest)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Remove deletes a quarantined rendition together with every avatar using
+// it, which leaves those users and chats without one.
+func (s *Service) Remove(ctx context.Context, digest []byte) (err error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	var found bool
+	err = tx.QueryRow(ctx, `SELECT true FROM images WHERE digest=$1 AND scan_status = 'quarantined' FOR UPDATE`, digest).Scan(&found)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrNotFound
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM avatars WHERE id IN (SELECT avatar_id FROM avatar_images WHERE digest=$1)`, digest); err != nil {
+		return err
+	}
+	if err = s.store.Delete(ctx, imageKey(digest)); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM images WHERE digest=$1`, digest)
+	return err
+}
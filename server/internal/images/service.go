@@ -0,0 +1,380 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/kentapp/kent/server/internal/blob"
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/scan"
+)
+
+const (
+	// maxConcurrent bounds how many uploads are decoded at once, since each
+	// may take a few hundred megabytes.
+	maxConcurrent = 4
+	gcBatchSize   = 100
+)
+
+var ErrNotFound = errors.New("images: not found")
+
+// Avatar is a profile or chat picture; every size in Sizes is present.
+// Its URLs serve once Status is clean or released.
+type Avatar struct {
+	ID     uuid.UUID    `json:"id"`
+	Status scan.Status  `json:"status"`
+	Sizes  []AvatarSize `json:"sizes"`
+}
+
+type AvatarSize struct {
+	Size   int    `json:"size"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	URL    string `json:"url"`
+}
+
+// Image is a stored rendition opened for serving.
+type Image struct {
+	Digest      []byte
+	ContentType string
+	CreatedAt   time.Time
+	Content     io.ReadSeekCloser
+}
+
+// Service processes public images, which unlike attachments are not
+// end-to-end encrypted: avatars of users, groups and channels. Renditions
+// are stored by content digest and never change, so they can be cached
+// indefinitely.
+type Service struct {
+	pool     *pgxpool.Pool
+	store    blob.Store
+	chats    *chats.Repository
+	base     string
+	sem      chan struct{}
+	scanWake chan struct{}
+}
+
+// NewService serves image URLs under base, such as a CDN origin followed by
+// /v1/images.
+func NewService(pool *pgxpool.Pool, store blob.Store, chatRepo *chats.Repository, base string) *Service {
+	return &Service{pool: pool, store: store, chats: chatRepo, base: base,
+		sem: make(chan struct{}, maxConcurrent), scanWake: make(chan struct{}, 1)}
+}
+
+// avatarOwner is the row an avatar belongs to: a user or a chat.
+type avatarOwner struct {
+	lock string
+	set  string
+}
+
+var (
+	userAvatar = avatarOwner{
+		lock: `SELECT avatar_id FROM users WHERE id=$1 FOR UPDATE`,
+		set:  `UPDATE users SET avatar_id=$2, updated_at=now() WHERE id=$1`,
+	}
+	chatAvatar = avatarOwner{
+		lock: `SELECT avatar_id FROM chats WHERE id=$1 FOR UPDATE`,
+		set:  `UPDATE chats SET avatar_id=$2 WHERE id=$1`,
+	}
+)
+
+// SetUserAvatar processes the upload and makes it the user's avatar.
+func (s *Service) SetUserAvatar(ctx context.Context, userID uuid.UUID, data []byte) (*Avatar, error) {
+	id, err := s.setAvatar(ctx, userID, data, userAvatar, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Avatar(ctx, id)
+}
+
+// SetChatAvatar processes the upload and makes it the avatar of a group or
+// channel, for members allowed to change its info.
+func (s *Service) SetChatAvatar(ctx context.Context, userID, chatID uuid.UUID, data []byte) (*Avatar, error) {
+	if err := s.authorizeChat(ctx, userID, chatID); err != nil {
+		return nil, err
+	}
+	id, err := s.setAvatar(ctx, userID, data, chatAvatar, chatID)
+	if err != nil {
+		return nil, err
+	}
+	return s.Avatar(ctx, id)
+}
+
+func (s *Service) ClearUserAvatar(ctx context.Context, userID uuid.UUID) error {
+	return s.clearAvatar(ctx, userAvatar, userID)
+}
+
+func (s *Service) ClearChatAvatar(ctx context.Context, userID, chatID uuid.UUID) error {
+	if err := s.authorizeChat(ctx, userID, chatID); err != nil {
+		return err
+	}
+	return s.clearAvatar(ctx, chatAvatar, chatID)
+}
+
+// authorizeChat allows group and channel avatars only; a direct chat shows
+// the other user's.
+func (s *Service) authorizeChat(ctx context.Context, userID, chatID uuid.UUID) error {
+	m, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionChangeInfo)
+	if err != nil {
+		return err
+	}
+	if m.Kind != chats.KindGroup && m.Kind != chats.KindChannel {
+		return chats.ErrNotAllowed
+	}
+	return nil
+}
+
+func (s *Service) setAvatar(ctx context.Context, uploader uuid.UUID, data []byte, owner avatarOwner, ownerID uuid.UUID) (id uuid.UUID, err error) {
+	renditions, err := s.render(data)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	digests, err := s.storeRenditions(ctx, renditions)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	if err = tx.QueryRow(ctx, `INSERT INTO avatars (uploaded_by) VALUES ($1) RETURNING id`, uploader).Scan(&id); err != nil {
+		return uuid.Nil, err
+	}
+	for i, r := range renditions {
+		if _, err = tx.Exec(ctx, `INSERT INTO avatar_images (avatar_id, size, digest) VALUES ($1, $2, $3)`,
+			id, r.Size, digests[i]); err != nil {
+			return uuid.Nil, err
+		}
+	}
+	if err = replaceAvatar(ctx, tx, owner, ownerID, &id); err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+func (s *Service) clearAvatar(ctx context.Context, owner avatarOwner, ownerID uuid.UUID) (err error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	err = replaceAvatar(ctx, tx, owner, ownerID, nil)
+	return err
+}
+
+// replaceAvatar points the owner at avatarID (nil clears it) and deletes
+// the avatar it had before.
+func replaceAvatar(ctx context.Context, tx pgx.Tx, owner avatarOwner, ownerID uuid.UUID, avatarID *uuid.UUID) error {
+	var old *uuid.UUID
+	if err := tx.QueryRow(ctx, owner.lock, ownerID).Scan(&old); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if _, err := tx.Exec(ctx, owner.set, ownerID, avatarID); err != nil {
+		return err
+	}
+	if old == nil {
+		return nil
+	}
+	_, err := tx.Exec(ctx, `DELETE FROM avatars WHERE id=$1`, *old)
+	return err
+}
+
+func (s *Service) render(data []byte) ([]Rendition, error) {
+	s.sem <- struct{}{}
+	defer func() { <-s.sem }()
+	return process(data)
+}
+
+// storeRenditions writes each rendition under its digest. The image rows
+// are claimed first, refreshing their grace period, so that garbage
+// collection cannot remove a blob this upload is about to reference.
+func (s *Service) storeRenditions(ctx context.Context, renditions []Rendition) ([][]byte, error) {
+	digests := make([][]byte, len(renditions))
+	for i, r := range renditions {
+		sum := sha256.Sum256(r.Data)
+		digests[i] = sum[:]
+		_, err := s.pool.Exec(ctx, `INSERT INTO images (digest, content_type, size, width, height) VALUES ($1, $2, $3, $4, $5)
+          ON CONFLICT (digest) DO UPDATE
+          SET unreferenced_at = CASE WHEN images.unreferenced_at IS NULL THEN NULL ELSE now() END`,
+			digests[i], r.ContentType, len(r.Data), r.Width, r.Height)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.store.Put(ctx, imageKey(digests[i]), bytes.NewReader(r.Data), int64(len(r.Data))); err != nil {
+			return nil, err
+		}
+	}
+	s.wakeScanner()
+	return digests, nil
+}
+
+// Avatar returns the avatar's renditions. Its status is the least
+// servable of theirs.
+func (s *Service) Avatar(ctx context.Context, id uuid.UUID) (*Avatar, error) {
+	rows, err := s.pool.Query(ctx, `SELECT ai.size, i.width, i.height, i.digest, i.content_type, i.scan_status
+      FROM avatar_images ai
+      JOIN images i ON i.digest = ai.digest
+      WHERE ai.avatar_id=$1
+      ORDER BY ai.size`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	a := Avatar{ID: id, Status: scan.StatusClean}
+	for rows.Next() {
+		var sz AvatarSize
+		var digest []byte
+		var contentType string
+		var status scan.Status
+		if err := rows.Scan(&sz.Size, &sz.Width, &sz.Height, &digest, &contentType, &status); err != nil {
+			return nil, err
+		}
+		switch {
+		case status == scan.StatusQuarantined:
+			a.Status = status
+		case status == scan.StatusPending && a.Status != scan.StatusQuarantined:
+			a.Status = status
+		}
+		sz.URL = s.base + "/" + Name(digest, contentType)
+		a.Sizes = append(a.Sizes, sz)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(a.Sizes) == 0 {
+		return nil, ErrNotFound
+	}
+	return &a, nil
+}
+
+// Open opens a stored rendition by digest. Content reads from the blob
+// store on demand, so a rendition is never held in memory whole. Renditions
+// not yet scanned clean are not found.
+func (s *Service) Open(ctx context.Context, digest []byte) (*Image, error) {
+	img := Image{Digest: digest}
+	var size int64
+	err := s.pool.QueryRow(ctx, `SELECT content_type, size, created_at FROM images
+      WHERE digest=$1 AND scan_status IN ('clean', 'released')`, digest).
+		Scan(&img.ContentType, &size, &img.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	img.Content = blob.NewSeeker(ctx, s.store, imageKey(digest), size)
+	return &img, nil
+}
+
+// RunGC removes unused avatars and images every interval until ctx is
+// cancelled.
+func (s *Service) RunGC(ctx context.Context, interval, grace time.Duration) {
+	blob.RunGC(ctx, "image", interval, gcBatchSize, func(ctx context.Context) (int, error) {
+		return s.CollectGarbage(ctx, grace)
+	})
+}
+
+// CollectGarbage deletes avatars no user or chat uses any more, then one
+// batch of images no avatar has referenced for the grace period. Image rows
+// stay locked while their blobs are deleted, so an upload claiming the same
+// digest waits and then stores the blob again.
+func (s *Service) CollectGarbage(ctx context.Context, grace time.Duration) (n int, err error) {
+	if _, err := s.pool.Exec(ctx, `DELETE FROM avatars a
+      WHERE a.created_at < now() - make_interval(secs => $1)
+        AND NOT EXISTS (SELECT 1 FROM users u WHERE u.avatar_id = a.id)
+        AND NOT EXISTS (SELECT 1 FROM chats c WHERE c.avatar_id = a.id)`, grace.Seconds()); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	rows, err := tx.Query(ctx, `SELECT i.digest FROM images i
+      WHERE i.unreferenced_at < now() - make_interval(secs => $1)
+        AND NOT EXISTS (SELECT 1 FROM avatar_images ai WHERE ai.digest = i.digest)
+      ORDER BY i.unreferenced_at
+      LIMIT $2
+      FOR UPDATE SKIP LOCKED`, grace.Seconds(), gcBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var candidates [][]byte
+	for rows.Next() {
+		var digest []byte
+		if err = rows.Scan(&digest); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		candidates = append(candidates, digest)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var gone [][]byte
+	for _, digest := range candidates {
+		if err := s.store.Delete(ctx, imageKey(digest)); err != nil {
+			log.Printf("blob delete %s failed: %v", imageKey(digest), err)
+			continue
+		}
+		gone = append(gone, digest)
+	}
+	if len(gone) > 0 {
+		if _, err = tx.Exec(ctx, `DELETE FROM images WHERE digest = ANY($1)`, gone); err != nil {
+			return 0, err
+		}
+	}
+	return len(gone), nil
+}
+
+var extensions = map[string]string{"image/jpeg": ".jpg", "image/png": ".png", "image/webp": ".webp"}
+
+// Name is the public file name of a rendition: its hex digest and an
+// extension for the content type.
+func Name(digest []byte, contentType string) string {
+	return hex.EncodeToString(digest) + extensions[contentType]
+}
+
+func imageKey(digest []byte) string {
+	return "images/" + hex.EncodeToString(digest)
+}
@@ -0,0 +1,285 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/kentapp/kent/server/internal/realtime"
+	"github.com/kentapp/kent/server/internal/transparency"
+)
+
+const (
+	EventPrekeysLow = "keys.low"
+
+	// KeySize is the size of Ed25519 identity keys and X25519 prekeys.
+	KeySize = 32
+
+	MaxPrekeyBatch   = 100
+	MaxStoredPrekeys = 1000
+	// LowPrekeyThreshold is the remaining one-time prekey count at which a
+	// device is asked to upload more.
+	LowPrekeyThreshold = 10
+)
+
+var (
+	ErrInvalidKey     = errors.New("keys: key has the wrong size")
+	ErrBadSignature   = errors.New("keys: signed prekey signature does not verify")
+	ErrNoIdentity     = errors.New("keys: device has no identity key")
+	ErrTooManyPrekeys = errors.New("keys: too many one-time prekeys")
+	ErrNoBundle       = errors.New("keys: no device of the user has published keys")
+)
+
+type SignedPrekey struct {
+	KeyID     int32  `json:"keyId"`
+	PublicKey []byte `json:"publicKey"`
+	Signature []byte `json:"signature"`
+}
+
+type Prekey struct {
+	KeyID     int32  `json:"keyId"`
+	PublicKey []byte `json:"publicKey"`
+}
+
+// Bundle is what an initiator needs to run X3DH with one device. Prekey is
+// nil once the device has run out of one-time prekeys.
+type Bundle struct {
+	DeviceID     uuid.UUID    `json:"deviceId"`
+	IdentityKey  []byte       `json:"identityKey"`
+	SignedPrekey SignedPrekey `json:"signedPrekey"`
+	Prekey       *Prekey      `json:"prekey,omitempty"`
+}
+
+// Service stores public key material for end-to-end encrypted sessions. It
+// never sees private keys.
+type Service struct {
+	pool *pgxpool.Pool
+	hub  *realtime.Hub
+}
+
+func NewService(pool *pgxpool.Pool, hub *realtime.Hub) *Service {
+	return &Service{pool: pool, hub: hub}
+}
+
+// SetIdentityKey publishes the device's identity key and logs the binding in
+// the key transparency log. A different key than the stored one invalidates
+// the device's prekeys, which were signed by or paired with the old key, and
+// sends the device back for approval. The primary's device list was signed
+// by its old key, so a new primary key drops the list and sends every device
+// of the account back for approval until the primary signs a new one.
+// changed reports whether the stored key changed and reset whether the
+// account's device list was dropped.
+func (s *Service) SetIdentityKey(ctx context.Context, userID, deviceID uuid.UUID, key []byte) (changed, reset bool, err error) {
+	if len(key) != ed25519.PublicKeySize {
+		return false, false, ErrInvalidKey
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, false, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	var current []byte
+	err = tx.QueryRow(ctx, `SELECT identity_key FROM device_identity_keys WHERE device_id=$1 FOR UPDATE`, deviceID).Scan(&current)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		_, err = tx.Exec(ctx, `INSERT INTO device_identity_keys (device_id, user_id, identity_key) VALUES ($1, $2, $3)`,
+			deviceID, userID, key)
+		if err != nil {
+			return false, false, err
+		}
+		err = transparency.Append(ctx, tx, userID, deviceID, key)
+		return err == nil, false, err
+	case err != nil:
+		return false, false, err
+	case bytes.Equal(current, key):
+		return false, false, nil
+	}
+
+	if _, err = tx.Exec(ctx, `UPDATE device_identity_keys SET identity_key=$2, created_at=now() WHERE device_id=$1`, deviceID, key); err != nil {
+		return false, false, err
+	}
+	if err = transparency.Append(ctx, tx, userID, deviceID, key); err != nil {
+		return false, false, err
+	}
+	if err = tx.QueryRow(ctx, `SELECT is_primary FROM devices WHERE id=$1`, deviceID).Scan(&reset); err != nil {
+		return false, false, err
+	}
+	if reset {
+		if _, err = tx.Exec(ctx, `UPDATE devices SET verified = FALSE WHERE user_id=$1`, userID); err != nil {
+			return false, false, err
+		}
+		if _, err = tx.Exec(ctx, `DELETE FROM device_lists WHERE user_id=$1`, userID); err != nil {
+			return false, false, err
+		}
+	} else if _, err = tx.Exec(ctx, `UPDATE devices SET verified = FALSE WHERE id=$1`, deviceID); err != nil {
+		return false, false, err
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM signed_prekeys WHERE device_id=$1`, deviceID); err != nil {
+		return false, false, err
+	}
+	_, err = tx.Exec(ctx, `DELETE FROM one_time_prekeys WHERE device_id=$1`, deviceID)
+	return err == nil, reset, err
+}
+
+// SetSignedPrekey replaces the device's signed prekey after checking its
+// signature against the device's identity key.
+func (s *Service) SetSignedPrekey(ctx context.Context, deviceID uuid.UUID, spk SignedPrekey) error {
+	if len(spk.PublicKey) != KeySize || len(spk.Signature) != ed25519.SignatureSize {
+		return ErrInvalidKey
+	}
+	identity, err := s.identityKey(ctx, deviceID)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(identity, spk.PublicKey, spk.Signature) {
+		return ErrBadSignature
+	}
+
+	_, err = s.pool.Exec(ctx, `INSERT INTO signed_prekeys (device_id, key_id, public_key, signature) VALUES ($1, $2, $3, $4)
+      ON CONFLICT (device_id) DO UPDATE
+        SET key_id = EXCLUDED.key_id, public_key = EXCLUDED.public_key, signature = EXCLUDED.signature, created_at = now()`,
+		deviceID, spk.KeyID, spk.PublicKey, spk.Signature)
+	return err
+}
+
+// AddPrekeys stores one-time prekeys and returns how many the device now has.
+// Key IDs that are already stored are skipped.
+func (s *Service) AddPrekeys(ctx context.Context, deviceID uuid.UUID, prekeys []Prekey) (count int, err error) {
+	if len(prekeys) == 0 || len(prekeys) > MaxPrekeyBatch {
+		return 0, ErrInvalidKey
+	}
+	ids := make([]int32, len(prekeys))
+	pubs := make([][]byte, len(prekeys))
+	for i, pk := range prekeys {
+		if len(pk.PublicKey) != KeySize {
+			return 0, ErrInvalidKey
+		}
+		ids[i], pubs[i] = pk.KeyID, pk.PublicKey
+	}
+	if _, err := s.identityKey(ctx, deviceID); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, `INSERT INTO one_time_prekeys (device_id, key_id, public_key)
+      SELECT $1, k.id, k.pub FROM unnest($2::int[], $3::bytea[]) AS k(id, pub)
+      ON CONFLICT DO NOTHING`, deviceID, ids, pubs); err != nil {
+		return 0, err
+	}
+	if err = tx.QueryRow(ctx, `SELECT count(*) FROM one_time_prekeys WHERE device_id=$1`, deviceID).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count > MaxStoredPrekeys {
+		err = ErrTooManyPrekeys
+		return 0, err
+	}
+	return count, nil
+}
+
+// PrekeyCount returns how many one-time prekeys the device has left.
+func (s *Service) PrekeyCount(ctx context.Context, deviceID uuid.UUID) (int, error) {
+	var n int
+	err := s.pool.QueryRow(ctx, `SELECT count(*) FROM one_time_prekeys WHERE device_id=$1`, deviceID).Scan(&n)
+	return n, err
+}
+
+// Bundles returns a bundle for each of the user's verified devices, or only
+// for deviceID when set, skipping the requesting device itself. Each bundle
+// consumes one one-time prekey; concurrent requests never get the same one.
+// Devices left with few prekeys are asked to replenish.
+func (s *Service) Bundles(ctx context.Context, requesterDeviceID, userID uuid.UUID, deviceID *uuid.UUID) ([]Bundle, error) {
+	rows, err := s.pool.Query(ctx, `WITH targets AS (
+        SELECT ik.device_id FROM device_identity_keys ik
+        JOIN signed_prekeys sp ON sp.device_id = ik.device_id
+        JOIN devices d ON d.id = ik.device_id AND d.verified
+        WHERE ik.user_id = $1 AND ik.device_id <> $2 AND ($3::uuid IS NULL OR ik.device_id = $3)),
+      claimed AS (
+        DELETE FROM one_time_prekeys o
+        USING (SELECT pk.device_id, pk.key_id FROM targets t
+          CROSS JOIN LATERAL (
+            SELECT device_id, key_id FROM one_time_prekeys
+            WHERE device_id = t.device_id
+            ORDER BY key_id
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED) pk) pick
+        WHERE o.device_id = pick.device_id AND o.key_id = pick.key_id
+        RETURNING o.device_id, o.key_id, o.public_key)
+      SELECT ik.device_id, ik.identity_key, sp.key_id, sp.public_key, sp.signature, c.key_id, c.public_key
+      FROM targets t
+      JOIN device_identity_keys ik ON ik.device_id = t.device_id
+      JOIN signed_prekeys sp ON sp.device_id = t.device_id
+      LEFT JOIN claimed c ON c.device_id = t.device_id
+      ORDER BY ik.device_id`, userID, requesterDeviceID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Bundle
+	for rows.Next() {
+		var b Bundle
+		var pkID *int32
+		var pkPub []byte
+		if err := rows.Scan(&b.DeviceID, &b.IdentityKey, &b.SignedPrekey.KeyID, &b.SignedPrekey.PublicKey, &b.SignedPrekey.Signature,
+			&pkID, &pkPub); err != nil {
+			return nil, err
+		}
+		if pkID != nil {
+			b.Prekey = &Prekey{KeyID: *pkID, PublicKey: pkPub}
+		}
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, ErrNoBundle
+	}
+
+	s.signalLow(ctx, out)
+	return out, nil
+}
+
+// signalLow tells devices that are running out of one-time prekeys how many
+// they have left.
+func (s *Service) signalLow(ctx context.Context, bundles []Bundle) {
+	ids := make([]uuid.UUID, len(bundles))
+	for i, b := range bundles {
+		ids[i] = b.DeviceID
+	}
+	s.hub.SignalLow(ctx, s.pool, `SELECT device_id, count(*) FROM one_time_prekeys WHERE device_id = ANY($1) GROUP BY device_id`,
+		ids, LowPrekeyThreshold, EventPrekeysLow)
+}
+
+func (s *Service) identityKey(ctx context.Context, deviceID uuid.UUID) (ed25519.PublicKey, error) {
+	var key []byte
+	err := s.pool.QueryRow(ctx, `SELECT identity_key FROM device_identity_keys WHERE device_id=$1`, deviceID).Scan(&key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoIdentity
+	}
+	return key, err
+}
@@ -0,0 +1,75 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+const MaxAttachments = 10
+
+var ErrInvalidAttachments = errors.New("messages: attachments are malformed, unfinished or not the sender's")
+
+// attachmentIDs reads the { "attachments": [id, ...] } header. IDs must be
+// in canonical lowercase form, the only one the message_attachments trigger
+// links.
+func attachmentIDs(headers json.RawMessage) ([]uuid.UUID, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	var h map[string]json.RawMessage
+	if err := json.Unmarshal(headers, &h); err != nil {
+		return nil, nil
+	}
+	raw, ok := h["attachments"]
+	if !ok {
+		return nil, nil
+	}
+	var strs []string
+	if err := json.Unmarshal(raw, &strs); err != nil || len(strs) > MaxAttachments {
+		return nil, ErrInvalidAttachments
+	}
+	ids := make([]uuid.UUID, 0, len(strs))
+	seen := make(map[uuid.UUID]bool, len(strs))
+	for _, s := range strs {
+		id, err := uuid.Parse(s)
+		if err != nil || id.String() != s || seen[id] {
+			return nil, ErrInvalidAttachments
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// validateAttachments requires every referenced attachment to be a finished
+// upload that the sender either uploaded or can already see in a message,
+// which is how forwards reuse attachments. Channel posts are cleartext, so
+// their attachments must be public ones the scanner has not quarantined.
+func (s *Service) validateAttachments(ctx context.Context, userID uuid.UUID, kind chats.Kind, in NewMessage) error {
+	ids, err := attachmentIDs(in.Headers)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	var n int
+	err = s.pool.QueryRow(ctx, `SELECT count(*) FROM attachments a
+      WHERE a.id = ANY($1) AND a.completed_at IS NOT NULL
+        AND (NOT $3 OR (a.public AND a.scan_status <> 'quarantined'))
+        AND (a.owner_id = $2 OR EXISTS (
+          SELECT 1 FROM message_attachments ma
+          JOIN messages m ON m.id = ma.message_id
+          JOIN chat_participants p ON p.chat_id = m.chat_id AND p.user_id = $2
+          WHERE ma.attachment_id = a.id))`, ids, userID, kind == chats.KindChannel).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n != len(ids) {
+		return ErrInvalidAttachments
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package messages
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentIDs(t *testing.T) {
+	const id = "0b6f1a4e-7c2d-4e8a-9f3b-5d1c2e7a8b90"
+	for _, tc := range []struct {
+		headers string
+		want    int
+		invalid bool
+	}{
+		{``, 0, false},
+		{`{}`, 0, false},
+		{`{"attachments": []}`, 0, false},
+		{`{"attachments": ["` + id + `"]}`, 1, false},
+		{`{"attachments": ["` + strings.ToUpper(id) + `"]}`, 0, true},
+		{`{"attachments": ["{` + id + `}"]}`, 0, true},
+		{`{"attachments": ["urn:uuid:` + id + `"]}`, 0, true},
+		{`{"attachments": ["` + strings.ReplaceAll(id, "-", "") + `"]}`, 0, true},
+		{`{"attachments": ["` + id + `", "` + id + `"]}`, 0, true},
+		{`{"attachments": [1]}`, 0, true},
+		{`{"attachments": "` + id + `"}`, 0, true},
+	} {
+		ids, err := attachmentIDs([]byte(tc.headers))
+		if tc.invalid {
+			if !errors.Is(err, ErrInvalidAttachments) {
+				t.Errorf("attachmentIDs(%s): err = %v, want ErrInvalidAttachments", tc.headers, err)
+			}
+			continue
+		}
+		if err != nil || len(ids) != tc.want {
+			t.Errorf("attachmentIDs(%s) = %v, %v, want %d ids", tc.headers, ids, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package messages
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+// MaxViewBatch bounds how many posts a client may mark as viewed at once.
+const MaxViewBatch = 100
+
+const counterBatchSize = 5000
+
+// ChannelPosts pages a channel's posts. Subscribers pull with After instead of
+// receiving a per-post event, which keeps posting cost independent of the
+// subscriber count.
+func (s *Service) ChannelPosts(ctx context.Context, userID, chatID uuid.UUID, q HistoryQuery) ([]Message, error) {
+	m, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if m.Kind != chats.KindChannel {
+		return nil, chats.ErrNotChannel
+	}
+	q.ThreadRootID = nil
+	return s.history(ctx, userID, chatID, q)
+}
+
+// RecordViews counts each post at most once per user and returns the
+// current view counts of the given posts. Views are only recorded here;
+// RunCounterFlush adds them to the posts, and until then they are counted
+// from the views table.
+func (s *Service) RecordViews(ctx context.Context, userID, chatID uuid.UUID, messageIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	m, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if m.Kind != chats.KindChannel {
+		return nil, chats.ErrNotChannel
+	}
+
+	if _, err := s.pool.Exec(ctx, `INSERT INTO channel_post_views (message_id, user_id)
+      SELECT m.id, $3 FROM messages m
+      WHERE m.chat_id = $1 AND m.id = ANY($2) AND m.deleted_at IS NULL AND `+notExpired+`
+      ON CONFLICT DO NOTHING`, chatID, messageIDs, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT m.id, m.view_count +
+        (SELECT count(*) FROM channel_post_views v WHERE v.message_id = m.id AND NOT v.counted)
+      FROM messages m WHERE m.chat_id=$1 AND m.id = ANY($2)`, chatID, messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[uuid.UUID]int64, len(messageIDs))
+	for rows.Next() {
+		var id uuid.UUID
+		var n int64
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, err
+		}
+		counts[id] = n
+	}
+	return counts, rows.Err()
+}
+
+// RunCounterFlush folds recorded post views and channel subscriber changes
+// into their counters every interval until ctx is cancelled. Several
+// replicas may run it concurrently.
+func (s *Service) RunCounterFlush(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.flushViewCounts(ctx)
+			if err != nil {
+				log.Printf("view count flush failed: %v", err)
+				break
+			}
+			if n < counterBatchSize {
+				break
+			}
+		}
+		for {
+			n, err := s.chats.FlushMemberCounts(ctx, counterBatchSize)
+			if err != nil {
+				log.Printf("member count flush failed: %v", err)
+				break
+			}
+			if n < counterBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushViewCounts adds one batch of uncounted views to their posts and
+// returns how many it consumed.
+func (s *Service) flushViewCounts(ctx context.Context) (int, error) {
+	var n int
+	err := s.pool.QueryRow(ctx, `WITH batch AS (
+        UPDATE channel_post_views v SET counted = TRUE
+        FROM (
+          SELECT message_id, user_id FROM channel_post_views
+          WHERE NOT counted
+          LIMIT $1
+          FOR UPDATE SKIP LOCKED
+        ) b
+        WHERE v.message_id = b.message_id AND v.user_id = b.user_id
+        RETURNING v.message_id
+      ), applied AS (
+        UPDATE messages m SET view_count = m.view_count + s.n
+        FROM (SELECT message_id, count(*) AS n FROM batch GROUP BY message_id) s
+        WHERE m.id = s.message_id
+      )
+      SELECT count(*) FROM batch`, counterBatchSize).Scan(&n)
+	return n, err
+}
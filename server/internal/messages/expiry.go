@@ -0,0 +1,79 @@
+package messages
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const sweepBatchSize = 500
+
+// RunExpirySweeper hard-deletes expired messages every interval until ctx is
+// cancelled. Several replicas may run it concurrently.
+func (s *Service) RunExpirySweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.SweepExpired(ctx)
+			if err != nil {
+				log.Printf("expiry sweep failed: %v", err)
+				break
+			}
+			if n < sweepBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SweepExpired deletes one batch of expired messages, along with the
+// replies of any thread roots among them, and tells the chat participants
+// which ones are gone.
+func (s *Service) SweepExpired(ctx context.Context) (int, error) {
+	rows, err := s.pool.Query(ctx, `WITH due AS (
+        SELECT id FROM messages
+        WHERE expires_at <= now()
+        ORDER BY expires_at
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+      )
+      DELETE FROM messages
+      WHERE id IN (SELECT id FROM due) OR thread_root_id IN (SELECT id FROM due)
+      RETURNING id, chat_id`, sweepBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	byChat := make(map[uuid.UUID][]uuid.UUID)
+	n := 0
+	for rows.Next() {
+		var id, chatID uuid.UUID
+		if err := rows.Scan(&id, &chatID); err != nil {
+			return n, err
+		}
+		byChat[chatID] = append(byChat[chatID], id)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+
+	for chatID, ids := range byChat {
+		s.notifyChat(ctx, chatID, EventExpired, map[string]any{
+			"chatId":     chatID,
+			"messageIds": ids,
+		})
+	}
+	return n, nil
+}
@@ -0,0 +1,154 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/users"
+)
+
+const MaxForwardBatch = 100
+
+// Forwarded is the provenance of a forwarded message. Channel posts point at
+// the channel post; other messages name their author unless the author's
+// privacy settings hide them, in which case Hidden is set.
+type Forwarded struct {
+	UserID    *uuid.UUID `json:"userId,omitempty"`
+	ChatID    *uuid.UUID `json:"chatId,omitempty"`
+	MessageID *uuid.UUID `json:"messageId,omitempty"`
+	Hidden    bool       `json:"hidden,omitempty"`
+	SentAt    time.Time  `json:"sentAt"`
+}
+
+// ForwardItem copies one source message into one chat. The client supplies
+// the body re-encrypted for the target chat; a cleartext channel post is
+// copied as is into another channel. Omitted headers are copied from the
+// source, so attachments it references are reused without re-upload.
+type ForwardItem struct {
+	SourceID   uuid.UUID       `json:"messageId"`
+	ChatID     uuid.UUID       `json:"chatId"`
+	Ciphertext []byte          `json:"ciphertext"`
+	Body       *string         `json:"body"`
+	Headers    json.RawMessage `json:"headers"`
+}
+
+type forwardCopy struct {
+	kind chats.Kind
+	in   NewMessage
+}
+
+// Forward copies messages into other chats. Every item is checked before
+// anything is stored, and all copies are stored in one transaction.
+func (s *Service) Forward(ctx context.Context, userID, deviceID uuid.UUID, items []ForwardItem) ([]Message, error) {
+	if len(items) == 0 || len(items) > MaxForwardBatch {
+		return nil, ErrInvalidBody
+	}
+
+	copies := make([]forwardCopy, 0, len(items))
+	sources := make(map[uuid.UUID]*Forwarded)
+	for _, item := range items {
+		src, srcKind, err := s.forwardSource(ctx, userID, item.SourceID)
+		if err != nil {
+			return nil, err
+		}
+		from, ok := sources[src.ID]
+		if !ok {
+			if from, err = s.provenance(ctx, src, srcKind); err != nil {
+				return nil, err
+			}
+			sources[src.ID] = from
+		}
+
+		in := NewMessage{Ciphertext: item.Ciphertext, Body: item.Body, Headers: item.Headers, ForwardedFrom: from}
+		if in.Headers == nil {
+			in.Headers = src.Headers
+		}
+		if in.Body == nil && len(in.Ciphertext) == 0 && srcKind == chats.KindChannel {
+			in.Body = src.Body
+		}
+		kind, err := s.checkSend(ctx, userID, item.ChatID, in)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, forwardCopy{kind: kind, in: in})
+	}
+
+	out, err := s.insertForwards(ctx, userID, deviceID, items, copies)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		s.delivered(ctx, copies[i].kind, &out[i])
+	}
+	return out, nil
+}
+
+func (s *Service) insertForwards(ctx context.Context, userID, deviceID uuid.UUID, items []ForwardItem, copies []forwardCopy) (out []Message, err error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	out = make([]Message, 0, len(copies))
+	for i, c := range copies {
+		msg, err := insertMessage(ctx, tx, userID, &deviceID, items[i].ChatID, c.in)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *msg)
+	}
+	return out, nil
+}
+
+// forwardSource loads a message the caller can read and returns the kind of
+// the chat it lives in.
+func (s *Service) forwardSource(ctx context.Context, userID, messageID uuid.UUID) (*Message, chats.Kind, error) {
+	src, err := s.get(ctx, messageID)
+	if err != nil {
+		return nil, "", err
+	}
+	member, err := s.chats.Authorize(ctx, src.ChatID, userID, chats.ActionRead)
+	if err != nil {
+		return nil, "", err
+	}
+	if src.DeletedAt != nil {
+		return nil, "", ErrDeleted
+	}
+	return src, member.Kind, nil
+}
+
+// provenance keeps the original attribution when forwarding a forward.
+func (s *Service) provenance(ctx context.Context, src *Message, kind chats.Kind) (*Forwarded, error) {
+	if src.ForwardedFrom != nil {
+		return src.ForwardedFrom, nil
+	}
+	from := &Forwarded{SentAt: src.CreatedAt}
+	if kind == chats.KindChannel {
+		from.ChatID, from.MessageID = &src.ChatID, &src.ID
+		return from, nil
+	}
+
+	var attribution users.Visibility
+	err := s.pool.QueryRow(ctx, `SELECT forward_attribution FROM users WHERE id=$1`, src.SenderID).Scan(&attribution)
+	if err != nil {
+		return nil, err
+	}
+	if attribution == users.VisibilityEveryone {
+		from.UserID = &src.SenderID
+	} else {
+		from.Hidden = true
+	}
+	return from, nil
+}
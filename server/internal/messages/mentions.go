@@ -0,0 +1,87 @@
+package messages
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+const EventMentionsRead = "chat.mentions"
+
+const unreadMentionsCond = `mm.user_id = $1 AND mm.chat_id = $2 AND mm.read_at IS NULL
+        AND m.deleted_at IS NULL AND ` + notExpired
+
+// NextMention returns the oldest unread message that mentions or replies to
+// the caller, after the given message when one is set, together with the
+// number of unread mentions left in the chat.
+func (s *Service) NextMention(ctx context.Context, userID, chatID uuid.UUID, after *uuid.UUID) (*Message, int, error) {
+	if _, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead); err != nil {
+		return nil, 0, err
+	}
+
+	row := s.pool.QueryRow(ctx, `SELECT `+messageColumns+`
+      FROM message_mentions mm
+      JOIN messages m ON m.id = mm.message_id
+      WHERE `+unreadMentionsCond+`
+        AND ($3::uuid IS NULL OR (m.created_at, m.id) > (SELECT created_at, id FROM messages WHERE id = $3))
+      ORDER BY m.created_at, m.id
+      LIMIT 1`, userID, chatID, after)
+	msg, err := scanMessage(row)
+	if err != nil {
+		return nil, 0, err
+	}
+	list := []Message{*msg}
+	if err := s.attachAggregates(ctx, userID, list); err != nil {
+		return nil, 0, err
+	}
+
+	unread, err := s.unreadMentions(ctx, userID, chatID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &list[0], unread, nil
+}
+
+// ReadMentions marks the given mentions as read, or every mention up to and
+// including upTo when it is set, and syncs the new counter to the caller's
+// devices.
+func (s *Service) ReadMentions(ctx context.Context, userID, chatID uuid.UUID, messageIDs []uuid.UUID, upTo *uuid.UUID) (int, error) {
+	if _, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead); err != nil {
+		return 0, err
+	}
+
+	var err error
+	if upTo != nil {
+		_, err = s.pool.Exec(ctx, `UPDATE message_mentions mm SET read_at = now()
+          FROM messages m
+          WHERE m.id = mm.message_id AND mm.user_id = $1 AND mm.chat_id = $2 AND mm.read_at IS NULL
+            AND (m.created_at, m.id) <= (SELECT created_at, id FROM messages WHERE id = $3)`, userID, chatID, *upTo)
+	} else {
+		_, err = s.pool.Exec(ctx, `UPDATE message_mentions SET read_at = now()
+          WHERE user_id = $1 AND chat_id = $2 AND message_id = ANY($3) AND read_at IS NULL`, userID, chatID, messageIDs)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	unread, err := s.unreadMentions(ctx, userID, chatID)
+	if err != nil {
+		return 0, err
+	}
+	s.notifyUsers(ctx, []uuid.UUID{userID}, EventMentionsRead, map[string]any{
+		"chatId":         chatID,
+		"unreadMentions": unread,
+	})
+	return unread, nil
+}
+
+func (s *Service) unreadMentions(ctx context.Context, userID, chatID uuid.UUID) (int, error) {
+	var n int
+	err := s.pool.QueryRow(ctx, `SELECT count(*)
+      FROM message_mentions mm
+      JOIN messages m ON m.id = mm.message_id
+      WHERE `+unreadMentionsCond, userID, chatID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,87 @@
+package messages
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+const (
+	EventPinned   = "message.pinned"
+	EventUnpinned = "message.unpinned"
+)
+
+// Pin adds the message to its chat's pinned list. In groups it requires the
+// pin right; in direct chats either participant may pin.
+func (s *Service) Pin(ctx context.Context, userID, messageID uuid.UUID) error {
+	current, err := s.pinTarget(ctx, userID, messageID)
+	if err != nil {
+		return err
+	}
+	if current.DeletedAt != nil {
+		return ErrDeleted
+	}
+
+	if _, err := s.pool.Exec(ctx, `INSERT INTO chat_pinned_messages (chat_id, message_id, pinned_by) VALUES ($1, $2, $3)
+      ON CONFLICT DO NOTHING`, current.ChatID, messageID, userID); err != nil {
+		return err
+	}
+	s.notifyChat(ctx, current.ChatID, EventPinned, map[string]any{"chatId": current.ChatID, "messageId": messageID, "pinnedBy": userID})
+	return nil
+}
+
+func (s *Service) Unpin(ctx context.Context, userID, messageID uuid.UUID) error {
+	current, err := s.pinTarget(ctx, userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.pool.Exec(ctx, `DELETE FROM chat_pinned_messages WHERE chat_id=$1 AND message_id=$2`, current.ChatID, messageID); err != nil {
+		return err
+	}
+	s.notifyChat(ctx, current.ChatID, EventUnpinned, map[string]any{"chatId": current.ChatID, "messageId": messageID})
+	return nil
+}
+
+// Pinned lists the chat's pinned messages, most recently pinned first.
+func (s *Service) Pinned(ctx context.Context, userID, chatID uuid.UUID) ([]Message, error) {
+	if _, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT `+messageColumns+`
+      FROM chat_pinned_messages pm
+      JOIN messages m ON m.id = pm.message_id
+      WHERE pm.chat_id=$1 AND m.deleted_at IS NULL AND `+notExpired+`
+      ORDER BY pm.pinned_at DESC`, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Message
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, s.attachAggregates(ctx, userID, out)
+}
+
+func (s *Service) pinTarget(ctx context.Context, userID, messageID uuid.UUID) (*Message, error) {
+	current, err := s.get(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.chats.Authorize(ctx, current.ChatID, userID, chats.ActionPin); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
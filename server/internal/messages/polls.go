@@ -0,0 +1,425 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+const (
+	EventPollUpdated = "poll.updated"
+
+	MaxPollOptions       = 10
+	maxPollQuestionRunes = 300
+	maxPollOptionRunes   = 100
+)
+
+var (
+	ErrInvalidPoll   = errors.New("messages: invalid poll")
+	ErrPollClosed    = errors.New("messages: poll is closed")
+	ErrAlreadyVoted  = errors.New("messages: already voted in this poll")
+	ErrNotVoted      = errors.New("messages: no vote to retract")
+	ErrVoteFinal     = errors.New("messages: quiz answers cannot be retracted")
+	ErrPollAnonymous = errors.New("messages: poll is anonymous")
+)
+
+// NewPoll is a poll as submitted by its creator. CorrectOption is required
+// for quizzes, which are always single choice.
+type NewPoll struct {
+	Question       string     `json:"question"`
+	Options        []string   `json:"options"`
+	Anonymous      bool       `json:"anonymous"`
+	MultipleChoice bool       `json:"multipleChoice"`
+	Quiz           bool       `json:"quiz"`
+	CorrectOption  *int       `json:"correctOption"`
+	Explanation    *string    `json:"explanation"`
+	ClosesAt       *time.Time `json:"closesAt"`
+}
+
+type PollOption struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+}
+
+// Poll is a poll with its live tally. The quiz answer is only revealed to
+// members who have voted, and to everyone once the poll is closed.
+type Poll struct {
+	ID             uuid.UUID    `json:"id"`
+	ChatID         uuid.UUID    `json:"chatId"`
+	MessageID      uuid.UUID    `json:"messageId"`
+	CreatedBy      *uuid.UUID   `json:"createdBy,omitempty"`
+	Question       string       `json:"question"`
+	Options        []PollOption `json:"options"`
+	Anonymous      bool         `json:"anonymous"`
+	MultipleChoice bool         `json:"multipleChoice"`
+	Quiz           bool         `json:"quiz"`
+	CorrectOption  *int         `json:"correctOption,omitempty"`
+	Explanation    *string      `json:"explanation,omitempty"`
+	ClosesAt       *time.Time   `json:"closesAt,omitempty"`
+	ClosedAt       *time.Time   `json:"closedAt,omitempty"`
+	Closed         bool         `json:"closed"`
+	TotalVoters    int          `json:"totalVoters"`
+	MyVotes        []int        `json:"myVotes,omitempty"`
+	CreatedAt      time.Time    `json:"createdAt"`
+}
+
+// CreatePoll stores a poll and announces it with a message whose headers
+// carry the poll ID. Polls are not available in direct chats.
+func (s *Service) CreatePoll(ctx context.Context, userID, deviceID, chatID uuid.UUID, in NewPoll) (*Poll, error) {
+	if err := validatePoll(in); err != nil {
+		return nil, err
+	}
+	member, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionSend)
+	if err != nil {
+		return nil, err
+	}
+	if member.Kind == chats.KindDirect {
+		return nil, ErrInvalidPoll
+	}
+
+	pollID := uuid.New()
+	msg, err := s.insertPoll(ctx, userID, deviceID, chatID, pollID, in)
+	if err != nil {
+		return nil, err
+	}
+	s.delivered(ctx, member.Kind, msg)
+
+	poll := &Poll{
+		ID: pollID, ChatID: chatID, MessageID: msg.ID, CreatedBy: &userID,
+		Question: in.Question, Anonymous: in.Anonymous, MultipleChoice: in.MultipleChoice, Quiz: in.Quiz,
+		CorrectOption: in.CorrectOption, Explanation: in.Explanation, ClosesAt: in.ClosesAt, CreatedAt: msg.CreatedAt,
+	}
+	for _, text := range in.Options {
+		poll.Options = append(poll.Options, PollOption{Text: text})
+	}
+	return poll, nil
+}
+
+func (s *Service) insertPoll(ctx context.Context, userID, deviceID, chatID, pollID uuid.UUID, in NewPoll) (msg *Message, err error) {
+	headers, err := json.Marshal(map[string]any{"pollId": pollID})
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	msg, err = insertMessage(ctx, tx, userID, &deviceID, chatID, NewMessage{Headers: headers})
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, `INSERT INTO polls
+        (id, chat_id, message_id, created_by, question, anonymous, multiple_choice, quiz, correct_option, explanation, closes_at)
+      VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		pollID, chatID, msg.ID, userID, in.Question, in.Anonymous, in.MultipleChoice, in.Quiz, in.CorrectOption, in.Explanation, in.ClosesAt); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, `INSERT INTO poll_options (poll_id, idx, text)
+      SELECT $1, o.ord - 1, o.text FROM unnest($2::text[]) WITH ORDINALITY AS o(text, ord)`, pollID, in.Options); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Poll returns the poll as seen by the caller.
+func (s *Service) Poll(ctx context.Context, userID, pollID uuid.UUID) (*Poll, error) {
+	poll, err := s.getPoll(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.chats.Authorize(ctx, poll.ChatID, userID, chats.ActionRead); err != nil {
+		return nil, err
+	}
+	return poll, s.personalize(ctx, userID, poll)
+}
+
+// Vote records the caller's single vote. Changing it requires retracting
+// first, which quizzes do not allow.
+func (s *Service) Vote(ctx context.Context, userID, pollID uuid.UUID, options []int) (*Poll, error) {
+	poll, err := s.voteTarget(ctx, userID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if len(options) == 0 || (len(options) > 1 && !poll.MultipleChoice) {
+		return nil, ErrInvalidPoll
+	}
+	seen := make(map[int]bool, len(options))
+	for _, o := range options {
+		if o < 0 || o >= len(poll.Options) || seen[o] {
+			return nil, ErrInvalidPoll
+		}
+		seen[o] = true
+	}
+
+	err = s.changeVote(ctx, pollID, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `INSERT INTO poll_votes (poll_id, user_id, options) VALUES ($1, $2, $3)
+          ON CONFLICT DO NOTHING`, pollID, userID, options)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrAlreadyVoted
+		}
+		if _, err := tx.Exec(ctx, `UPDATE poll_options SET vote_count = vote_count + 1 WHERE poll_id=$1 AND idx = ANY($2)`, pollID, options); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE polls SET voter_count = voter_count + 1 WHERE id=$1`, pollID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.pollChanged(ctx, userID, pollID)
+}
+
+// RetractVote removes the caller's vote from an open, non-quiz poll.
+func (s *Service) RetractVote(ctx context.Context, userID, pollID uuid.UUID) (*Poll, error) {
+	poll, err := s.voteTarget(ctx, userID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.Quiz {
+		return nil, ErrVoteFinal
+	}
+
+	err = s.changeVote(ctx, pollID, func(tx pgx.Tx) error {
+		var options []int16
+		err := tx.QueryRow(ctx, `DELETE FROM poll_votes WHERE poll_id=$1 AND user_id=$2 RETURNING options`, pollID, userID).Scan(&options)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotVoted
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE poll_options SET vote_count = vote_count - 1 WHERE poll_id=$1 AND idx = ANY($2)`, pollID, options); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `UPDATE polls SET voter_count = voter_count - 1 WHERE id=$1`, pollID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.pollChanged(ctx, userID, pollID)
+}
+
+// ClosePoll stops voting. The creator may close their poll; anyone else
+// needs the right to delete others' messages.
+func (s *Service) ClosePoll(ctx context.Context, userID, pollID uuid.UUID) (*Poll, error) {
+	poll, err := s.getPoll(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	action := chats.ActionDeleteMessages
+	if poll.CreatedBy != nil && *poll.CreatedBy == userID {
+		action = chats.ActionRead
+	}
+	if _, err := s.chats.Authorize(ctx, poll.ChatID, userID, action); err != nil {
+		return nil, err
+	}
+	if poll.Closed {
+		return nil, ErrPollClosed
+	}
+
+	if _, err := s.pool.Exec(ctx, `UPDATE polls SET closed_at = now() WHERE id=$1 AND closed_at IS NULL`, pollID); err != nil {
+		return nil, err
+	}
+	return s.pollChanged(ctx, userID, pollID)
+}
+
+// PollVoters lists who picked an option in a public poll.
+func (s *Service) PollVoters(ctx context.Context, userID, pollID uuid.UUID, option int) ([]uuid.UUID, error) {
+	poll, err := s.Poll(ctx, userID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.Anonymous {
+		return nil, ErrPollAnonymous
+	}
+	if option < 0 || option >= len(poll.Options) {
+		return nil, ErrInvalidPoll
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT user_id FROM poll_votes WHERE poll_id=$1 AND $2 = ANY(options) ORDER BY voted_at`, pollID, option)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	voters := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		voters = append(voters, id)
+	}
+	return voters, rows.Err()
+}
+
+// voteTarget loads an open poll the caller, as a joined member, may vote in.
+func (s *Service) voteTarget(ctx context.Context, userID, pollID uuid.UUID) (*Poll, error) {
+	poll, err := s.getPoll(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	member, err := s.chats.Authorize(ctx, poll.ChatID, userID, chats.ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if !member.Joined {
+		return nil, chats.ErrNotParticipant
+	}
+	if poll.Closed {
+		return nil, ErrPollClosed
+	}
+	return poll, nil
+}
+
+// changeVote runs fn with the poll row locked, checking again that the poll
+// is open so that no vote lands after it closes.
+func (s *Service) changeVote(ctx context.Context, pollID uuid.UUID, fn func(pgx.Tx) error) (err error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	var closed bool
+	err = tx.QueryRow(ctx, `SELECT closed_at IS NOT NULL OR COALESCE(closes_at <= now(), FALSE)
+      FROM polls WHERE id=$1 FOR UPDATE`, pollID).Scan(&closed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if closed {
+		err = ErrPollClosed
+		return err
+	}
+	return fn(tx)
+}
+
+// pollChanged pushes the new tally to the chat and returns the caller's view.
+func (s *Service) pollChanged(ctx context.Context, userID, pollID uuid.UUID) (*Poll, error) {
+	poll, err := s.getPoll(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	public := *poll
+	if !public.Closed {
+		public.CorrectOption, public.Explanation = nil, nil
+	}
+	s.notifyChat(ctx, poll.ChatID, EventPollUpdated, public)
+
+	return poll, s.personalize(ctx, userID, poll)
+}
+
+// personalize fills MyVotes and hides the quiz answer from members who have
+// not answered yet.
+func (s *Service) personalize(ctx context.Context, userID uuid.UUID, poll *Poll) error {
+	var options []int16
+	err := s.pool.QueryRow(ctx, `SELECT options FROM poll_votes WHERE poll_id=$1 AND user_id=$2`, poll.ID, userID).Scan(&options)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+	for _, o := range options {
+		poll.MyVotes = append(poll.MyVotes, int(o))
+	}
+	if poll.Quiz && !poll.Closed && len(poll.MyVotes) == 0 {
+		poll.CorrectOption, poll.Explanation = nil, nil
+	}
+	return nil
+}
+
+// getPoll loads a poll with its tally. Polls whose message was deleted or
+// has expired are not found.
+func (s *Service) getPoll(ctx context.Context, pollID uuid.UUID) (*Poll, error) {
+	var p Poll
+	var correct *int16
+	err := s.pool.QueryRow(ctx, `SELECT p.id, p.chat_id, p.message_id, p.created_by, p.question, p.anonymous,
+        p.multiple_choice, p.quiz, p.correct_option, p.explanation, p.closes_at, p.closed_at, p.voter_count, p.created_at,
+        p.closed_at IS NOT NULL OR COALESCE(p.closes_at <= now(), FALSE)
+      FROM polls p
+      JOIN messages m ON m.id = p.message_id
+      WHERE p.id=$1 AND m.deleted_at IS NULL AND `+notExpired, pollID).Scan(
+		&p.ID, &p.ChatID, &p.MessageID, &p.CreatedBy, &p.Question, &p.Anonymous,
+		&p.MultipleChoice, &p.Quiz, &correct, &p.Explanation, &p.ClosesAt, &p.ClosedAt, &p.TotalVoters, &p.CreatedAt,
+		&p.Closed)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if correct != nil {
+		c := int(*correct)
+		p.CorrectOption = &c
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT text, vote_count FROM poll_options WHERE poll_id=$1 ORDER BY idx`, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var o PollOption
+		if err := rows.Scan(&o.Text, &o.Votes); err != nil {
+			return nil, err
+		}
+		p.Options = append(p.Options, o)
+	}
+	return &p, rows.Err()
+}
+
+func validatePoll(in NewPoll) error {
+	if n := utf8.RuneCountInString(in.Question); n == 0 || n > maxPollQuestionRunes {
+		return ErrInvalidPoll
+	}
+	if len(in.Options) < 2 || len(in.Options) > MaxPollOptions {
+		return ErrInvalidPoll
+	}
+	for _, o := range in.Options {
+		if n := utf8.RuneCountInString(o); n == 0 || n > maxPollOptionRunes {
+			return ErrInvalidPoll
+		}
+	}
+	if in.Quiz {
+		if in.MultipleChoice || in.CorrectOption == nil || *in.CorrectOption < 0 || *in.CorrectOption >= len(in.Options) {
+			return ErrInvalidPoll
+		}
+	} else if in.CorrectOption != nil || in.Explanation != nil {
+		return ErrInvalidPoll
+	}
+	if in.Explanation != nil && utf8.RuneCountInString(*in.Explanation) > maxPollQuestionRunes {
+		return ErrInvalidPoll
+	}
+	if in.ClosesAt != nil && !in.ClosesAt.After(time.Now()) {
+		return ErrInvalidPoll
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package messages
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+const maxEmojiBytes = 32
+
+type ReactionCount struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	Mine  bool   `json:"mine,omitempty"`
+}
+
+// React stores the caller's single reaction on a message, replacing any
+// previous one. An empty emoji removes it.
+func (s *Service) React(ctx context.Context, userID, messageID uuid.UUID, emoji string) error {
+	emoji = strings.TrimSpace(emoji)
+	if len(emoji) > maxEmojiBytes || !utf8.ValidString(emoji) {
+		return ErrInvalidReaction
+	}
+
+	current, err := s.get(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.chats.Authorize(ctx, current.ChatID, userID, chats.ActionSend); err != nil {
+		return err
+	}
+	if current.DeletedAt != nil {
+		return ErrDeleted
+	}
+
+	if emoji == "" {
+		_, err = s.pool.Exec(ctx, `DELETE FROM message_reactions WHERE message_id=$1 AND user_id=$2`, messageID, userID)
+	} else {
+		_, err = s.pool.Exec(ctx, `INSERT INTO message_reactions (message_id, user_id, emoji) VALUES ($1, $2, $3)
+      ON CONFLICT (message_id, user_id) DO UPDATE SET emoji = EXCLUDED.emoji, created_at = now()`, messageID, userID, emoji)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.notifyChat(ctx, current.ChatID, EventReacted, map[string]any{
+		"chatId":    current.ChatID,
+		"messageId": messageID,
+		"userId":    userID,
+		"emoji":     emoji,
+	})
+	return nil
+}
+
+// Thread pages the replies of a thread root.
+func (s *Service) Thread(ctx context.Context, userID, rootID uuid.UUID, q HistoryQuery) ([]Message, error) {
+	root, err := s.get(ctx, rootID)
+	if err != nil {
+		return nil, err
+	}
+	q.UpdatedSince = nil
+	q.ThreadRootID = &root.ID
+	return s.History(ctx, userID, root.ChatID, q)
+}
+
+// validateReferences checks that reply and thread targets live in the same
+// chat, and that a thread root is not itself inside a thread.
+func (s *Service) validateReferences(ctx context.Context, chatID uuid.UUID, in NewMessage) error {
+	if in.ReplyToID != nil {
+		var refChat uuid.UUID
+		err := s.pool.QueryRow(ctx, `SELECT m.chat_id FROM messages m WHERE m.id=$1 AND `+notExpired, *in.ReplyToID).Scan(&refChat)
+		if err != nil || refChat != chatID {
+			return ErrInvalidReference
+		}
+	}
+	if in.ThreadRootID != nil {
+		var refChat uuid.UUID
+		var nested bool
+		err := s.pool.QueryRow(ctx, `SELECT m.chat_id, m.thread_root_id IS NOT NULL FROM messages m WHERE m.id=$1 AND `+notExpired, *in.ThreadRootID).Scan(&refChat, &nested)
+		if err != nil || refChat != chatID || nested {
+			return ErrInvalidReference
+		}
+	}
+	return nil
+}
+
+// attachAggregates fills reaction counts and thread reply counts in place.
+func (s *Service) attachAggregates(ctx context.Context, userID uuid.UUID, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(msgs))
+	index := make(map[uuid.UUID]int, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+		index[m.ID] = i
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT message_id, emoji, count(*), bool_or(user_id = $2)
+      FROM message_reactions
+      WHERE message_id = ANY($1)
+      GROUP BY message_id, emoji
+      ORDER BY count(*) DESC, emoji`, ids, userID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var rc ReactionCount
+		if err := rows.Scan(&id, &rc.Emoji, &rc.Count, &rc.Mine); err != nil {
+			rows.Close()
+			return err
+		}
+		i := index[id]
+		msgs[i].Reactions = append(msgs[i].Reactions, rc)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.pool.Query(ctx, `SELECT m.thread_root_id, count(*)
+      FROM messages m
+      WHERE m.thread_root_id = ANY($1) AND m.deleted_at IS NULL AND `+notExpired+`
+      GROUP BY m.thread_root_id`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return err
+		}
+		msgs[index[id]].ThreadReplies = n
+	}
+	return rows.Err()
+}
@@ -0,0 +1,151 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+const (
+	EventSavedTags = "saved.tags"
+
+	MaxTagsPerMessage = 10
+	maxTagRunes       = 32
+)
+
+var (
+	ErrNotSaved   = errors.New("messages: message is not in saved messages")
+	ErrInvalidTag = errors.New("messages: invalid tag")
+)
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// SetTags replaces the tags of a message in the caller's saved-messages chat.
+// Tags are case-insensitive and stored lowercased.
+func (s *Service) SetTags(ctx context.Context, userID, messageID uuid.UUID, tags []string) (out []string, err error) {
+	tags, err = normalizeTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.get(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	member, err := s.chats.Authorize(ctx, current.ChatID, userID, chats.ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if member.Kind != chats.KindSelf {
+		return nil, ErrNotSaved
+	}
+	if current.DeletedAt != nil {
+		return nil, ErrDeleted
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, `DELETE FROM saved_message_tags WHERE message_id=$1`, messageID); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, `INSERT INTO saved_message_tags (message_id, user_id, tag)
+      SELECT $1, $2, t FROM unnest($3::text[]) AS t`, messageID, userID, tags); err != nil {
+		return nil, err
+	}
+
+	s.notifyUsers(ctx, []uuid.UUID{userID}, EventSavedTags, map[string]any{
+		"chatId":    current.ChatID,
+		"messageId": messageID,
+		"tags":      tags,
+	})
+	return tags, nil
+}
+
+// SavedTags lists the caller's tags with how many live messages carry each.
+func (s *Service) SavedTags(ctx context.Context, userID uuid.UUID) ([]TagCount, error) {
+	rows, err := s.pool.Query(ctx, `SELECT t.tag, count(*)
+      FROM saved_message_tags t
+      JOIN messages m ON m.id = t.message_id
+      WHERE t.user_id=$1 AND m.deleted_at IS NULL AND `+notExpired+`
+      GROUP BY t.tag
+      ORDER BY count(*) DESC, t.tag`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []TagCount{}
+	for rows.Next() {
+		var tc TagCount
+		if err := rows.Scan(&tc.Tag, &tc.Count); err != nil {
+			return nil, err
+		}
+		out = append(out, tc)
+	}
+	return out, rows.Err()
+}
+
+func (s *Service) attachTags(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(msgs))
+	index := make(map[uuid.UUID]int, len(msgs))
+	for i, m := range msgs {
+		ids[i] = m.ID
+		index[m.ID] = i
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT message_id, tag FROM saved_message_tags WHERE message_id = ANY($1) ORDER BY tag`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		i := index[id]
+		msgs[i].Tags = append(msgs[i].Tags, tag)
+	}
+	return rows.Err()
+}
+
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > MaxTagsPerMessage {
+		return nil, ErrInvalidTag
+	}
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if n := utf8.RuneCountInString(t); n == 0 || n > maxTagRunes {
+			return nil, ErrInvalidTag
+		}
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,411 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+const (
+	EventScheduleSaved   = "scheduled.saved"
+	EventScheduleRemoved = "scheduled.removed"
+
+	MaxScheduleAhead    = 365 * 24 * time.Hour
+	MaxScheduledPerChat = 100
+
+	scheduleBatchSize = 100
+	// maxDeliveryAttempts is how many transient failures a scheduled message
+	// survives before it is set aside as failed. Retries back off from
+	// retryBackoff up to maxRetryBackoff.
+	maxDeliveryAttempts = 8
+	retryBackoff        = time.Minute
+	maxRetryBackoff     = time.Hour
+	// schedulerLockKey is the Postgres advisory lock that lets only one
+	// replica deliver scheduled messages at a time.
+	schedulerLockKey int64 = 0x6b656e7401
+)
+
+var (
+	ErrInvalidSendAt    = errors.New("messages: send time must be in the future")
+	ErrTooManyScheduled = errors.New("messages: too many scheduled messages in this chat")
+)
+
+// Scheduled is a message waiting for its send time. Rows that could not be
+// delivered stay behind with status "failed" until edited or cancelled;
+// transient failures are retried later first.
+type Scheduled struct {
+	ID             uuid.UUID       `json:"id"`
+	ChatID         uuid.UUID       `json:"chatId"`
+	SenderDeviceID *uuid.UUID      `json:"senderDeviceId,omitempty"`
+	Ciphertext     []byte          `json:"ciphertext,omitempty"`
+	Body           *string         `json:"body,omitempty"`
+	Headers        json.RawMessage `json:"headers,omitempty"`
+	ReplyToID      *uuid.UUID      `json:"replyToId,omitempty"`
+	ThreadRootID   *uuid.UUID      `json:"threadRootId,omitempty"`
+	Mentions       []uuid.UUID     `json:"mentions,omitempty"`
+	TTLSeconds     *int            `json:"ttlSeconds,omitempty"`
+	SendAt         time.Time       `json:"sendAt"`
+	Status         string          `json:"status"`
+	Failure        *string         `json:"failure,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+
+	senderID uuid.UUID
+	attempts int
+}
+
+const scheduledColumns = `id, chat_id, sender_id, sender_device_id, ciphertext, body, headers,
+      reply_to_id, thread_root_id, mentions, ttl_seconds, send_at, status, failure, attempts, created_at, updated_at`
+
+// Schedule checks the message as if it were sent now and stores it for
+// delivery at sendAt.
+func (s *Service) Schedule(ctx context.Context, userID, deviceID, chatID uuid.UUID, in NewMessage, sendAt time.Time) (*Scheduled, error) {
+	if err := validateSendAt(sendAt); err != nil {
+		return nil, err
+	}
+	if _, err := s.checkSend(ctx, userID, chatID, in); err != nil {
+		return nil, err
+	}
+
+	row := s.pool.QueryRow(ctx, `INSERT INTO scheduled_messages
+        (chat_id, sender_id, sender_device_id, ciphertext, body, headers, reply_to_id, thread_root_id, mentions, ttl_seconds, send_at)
+      SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+      WHERE (SELECT count(*) FROM scheduled_messages WHERE chat_id=$1 AND sender_id=$2) < $12
+      RETURNING `+scheduledColumns,
+		chatID, userID, deviceID, nilIfEmpty(in.Ciphertext), in.Body, jsonOrNil(in.Headers),
+		in.ReplyToID, in.ThreadRootID, mentionsOrNil(in.Mentions), ttlSecondsOf(in.TTL), sendAt, MaxScheduledPerChat)
+	sm, err := scanScheduled(row)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrTooManyScheduled
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyUsers(ctx, []uuid.UUID{userID}, EventScheduleSaved, sm)
+	return sm, nil
+}
+
+// ListScheduled returns the caller's pending and failed messages in a chat,
+// soonest first.
+func (s *Service) ListScheduled(ctx context.Context, userID, chatID uuid.UUID) ([]Scheduled, error) {
+	if _, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT `+scheduledColumns+` FROM scheduled_messages
+      WHERE chat_id=$1 AND sender_id=$2
+      ORDER BY send_at, id`, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Scheduled{}
+	for rows.Next() {
+		sm, err := scanScheduled(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *sm)
+	}
+	return out, rows.Err()
+}
+
+// EditScheduled replaces the content and/or send time of the caller's
+// scheduled message. A failed message goes back to pending.
+func (s *Service) EditScheduled(ctx context.Context, userID, scheduledID uuid.UUID, in NewMessage, sendAt *time.Time) (*Scheduled, error) {
+	current, err := s.getScheduled(ctx, userID, scheduledID)
+	if err != nil {
+		return nil, err
+	}
+	if sendAt != nil {
+		if err := validateSendAt(*sendAt); err != nil {
+			return nil, err
+		}
+	}
+
+	next := current.message()
+	if in.Ciphertext != nil || in.Body != nil {
+		next.Ciphertext, next.Body, next.Mentions = in.Ciphertext, in.Body, in.Mentions
+	}
+	if in.Headers != nil {
+		next.Headers = in.Headers
+	}
+	if _, err := s.checkSend(ctx, userID, current.ChatID, next); err != nil {
+		return nil, err
+	}
+
+	row := s.pool.QueryRow(ctx, `UPDATE scheduled_messages
+      SET ciphertext=$2, body=$3, headers=$4, mentions=$6, send_at=COALESCE($5, send_at),
+          status='pending', failure=NULL, attempts=0, updated_at=now()
+      WHERE id=$1
+      RETURNING `+scheduledColumns, scheduledID, nilIfEmpty(next.Ciphertext), next.Body, jsonOrNil(next.Headers), sendAt, mentionsOrNil(next.Mentions))
+	sm, err := scanScheduled(row)
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyUsers(ctx, []uuid.UUID{userID}, EventScheduleSaved, sm)
+	return sm, nil
+}
+
+// CancelScheduled drops the caller's scheduled message before it is sent.
+func (s *Service) CancelScheduled(ctx context.Context, userID, scheduledID uuid.UUID) error {
+	var chatID uuid.UUID
+	err := s.pool.QueryRow(ctx, `DELETE FROM scheduled_messages WHERE id=$1 AND sender_id=$2 RETURNING chat_id`, scheduledID, userID).Scan(&chatID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	s.notifyUsers(ctx, []uuid.UUID{userID}, EventScheduleRemoved, map[string]any{
+		"id":     scheduledID,
+		"chatId": chatID,
+		"reason": "cancelled",
+	})
+	return nil
+}
+
+// RunScheduler delivers due scheduled messages every interval until ctx is
+// cancelled. Every replica may run it; an advisory lock makes sure only one
+// of them delivers at a time.
+func (s *Service) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := s.DeliverDue(ctx)
+			if err != nil {
+				log.Printf("scheduled delivery failed: %v", err)
+				break
+			}
+			if n < scheduleBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue moves one batch of due scheduled messages into messages. Each
+// one is inserted and removed from the schedule in the same transaction, so
+// a crash never delivers a message twice.
+func (s *Service) DeliverDue(ctx context.Context) (int, error) {
+	sent, changed, err := s.deliverDue(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, d := range sent {
+		s.delivered(ctx, d.kind, d.msg)
+		s.notifyUsers(ctx, []uuid.UUID{d.msg.SenderID}, EventScheduleRemoved, map[string]any{
+			"id":        d.scheduledID,
+			"chatId":    d.msg.ChatID,
+			"reason":    "sent",
+			"messageId": d.msg.ID,
+		})
+	}
+	for _, sm := range changed {
+		s.notifyUsers(ctx, []uuid.UUID{sm.senderID}, EventScheduleSaved, sm)
+	}
+	return len(sent) + len(changed), nil
+}
+
+type scheduledDelivery struct {
+	scheduledID uuid.UUID
+	kind        chats.Kind
+	msg         *Message
+}
+
+// deliverDue returns the delivered messages and the ones that failed or were
+// put off for a retry.
+func (s *Service) deliverDue(ctx context.Context) (sent []scheduledDelivery, changed []*Scheduled, err error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	var locked bool
+	if err = tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1)`, schedulerLockKey).Scan(&locked); err != nil || !locked {
+		return nil, nil, err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT `+scheduledColumns+` FROM scheduled_messages
+      WHERE status='pending' AND send_at <= now()
+      ORDER BY send_at
+      LIMIT $1
+      FOR UPDATE SKIP LOCKED`, scheduleBatchSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	var due []*Scheduled
+	for rows.Next() {
+		sm, scanErr := scanScheduled(rows)
+		if scanErr != nil {
+			rows.Close()
+			err = scanErr
+			return nil, nil, err
+		}
+		due = append(due, sm)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, sm := range due {
+		d, deliverErr := s.deliverScheduled(ctx, tx, sm)
+		if deliverErr == nil {
+			sent = append(sent, *d)
+			continue
+		}
+		var row pgx.Row
+		reason := failureReason(deliverErr)
+		switch {
+		case reason != "":
+			row = tx.QueryRow(ctx, `UPDATE scheduled_messages SET status='failed', failure=$2, updated_at=now()
+              WHERE id=$1 RETURNING `+scheduledColumns, sm.ID, reason)
+		case sm.attempts+1 < maxDeliveryAttempts:
+			// Moving send_at keeps the message from holding up the ones
+			// behind it until the retry is due.
+			log.Printf("scheduled %s delivery failed, retrying: %v", sm.ID, deliverErr)
+			row = tx.QueryRow(ctx, `UPDATE scheduled_messages SET attempts=attempts+1, send_at=$2, updated_at=now()
+              WHERE id=$1 RETURNING `+scheduledColumns, sm.ID, time.Now().Add(retryDelay(sm.attempts)))
+		default:
+			log.Printf("scheduled %s delivery failed, giving up: %v", sm.ID, deliverErr)
+			row = tx.QueryRow(ctx, `UPDATE scheduled_messages SET status='failed', failure='delivery_failed', attempts=attempts+1, updated_at=now()
+              WHERE id=$1 RETURNING `+scheduledColumns, sm.ID)
+		}
+		if sm, err = scanScheduled(row); err != nil {
+			return nil, nil, err
+		}
+		changed = append(changed, sm)
+	}
+	return sent, changed, nil
+}
+
+// deliverScheduled sends one scheduled message under a savepoint, so that a
+// failure rolls back only this message and the batch carries on.
+func (s *Service) deliverScheduled(ctx context.Context, tx pgx.Tx, sm *Scheduled) (d *scheduledDelivery, err error) {
+	// The sender may have lost access or the referenced message may be
+	// gone since scheduling, so the send checks run again.
+	kind, err := s.checkSend(ctx, sm.senderID, sm.ChatID, sm.message())
+	if err != nil {
+		return nil, err
+	}
+
+	sp, err := tx.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = sp.Rollback(ctx)
+		} else {
+			err = sp.Commit(ctx)
+		}
+	}()
+
+	msg, err := insertMessage(ctx, sp, sm.senderID, sm.SenderDeviceID, sm.ChatID, sm.message())
+	if err != nil {
+		return nil, err
+	}
+	if _, err = sp.Exec(ctx, `DELETE FROM scheduled_messages WHERE id=$1`, sm.ID); err != nil {
+		return nil, err
+	}
+	return &scheduledDelivery{scheduledID: sm.ID, kind: kind, msg: msg}, nil
+}
+
+func (s *Service) getScheduled(ctx context.Context, userID, scheduledID uuid.UUID) (*Scheduled, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+scheduledColumns+` FROM scheduled_messages WHERE id=$1 AND sender_id=$2`, scheduledID, userID)
+	return scanScheduled(row)
+}
+
+func (sm *Scheduled) message() NewMessage {
+	in := NewMessage{
+		Ciphertext:   sm.Ciphertext,
+		Body:         sm.Body,
+		Headers:      sm.Headers,
+		ReplyToID:    sm.ReplyToID,
+		ThreadRootID: sm.ThreadRootID,
+		Mentions:     sm.Mentions,
+	}
+	if sm.TTLSeconds != nil {
+		in.TTL = time.Duration(*sm.TTLSeconds) * time.Second
+	}
+	return in
+}
+
+// retryDelay is the backoff before retrying a message that has already
+// failed attempts times.
+func retryDelay(attempts int) time.Duration {
+	return min(retryBackoff<<attempts, maxRetryBackoff)
+}
+
+// failureReason names the send checks that will keep failing on retry;
+// anything else is treated as transient.
+func failureReason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, chats.ErrNotParticipant), errors.Is(err, chats.ErrNotAllowed), errors.Is(err, chats.ErrBanned):
+		return "forbidden"
+	case errors.Is(err, chats.ErrRestricted):
+		return "restricted"
+	case errors.Is(err, ErrInvalidReference):
+		return "invalid_reference"
+	case errors.Is(err, ErrInvalidAttachments):
+		return "invalid_attachments"
+	case errors.Is(err, ErrInvalidBody), errors.Is(err, ErrInvalidTTL):
+		return "invalid_message"
+	default:
+		return ""
+	}
+}
+
+func validateSendAt(sendAt time.Time) error {
+	if until := time.Until(sendAt); until <= 0 || until > MaxScheduleAhead {
+		return ErrInvalidSendAt
+	}
+	return nil
+}
+
+func scanScheduled(row pgx.Row) (*Scheduled, error) {
+	var sm Scheduled
+	var headers []byte
+	err := row.Scan(&sm.ID, &sm.ChatID, &sm.senderID, &sm.SenderDeviceID, &sm.Ciphertext, &sm.Body, &headers,
+		&sm.ReplyToID, &sm.ThreadRootID, &sm.Mentions, &sm.TTLSeconds, &sm.SendAt, &sm.Status, &sm.Failure, &sm.attempts, &sm.CreatedAt, &sm.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if headers != nil {
+		sm.Headers = headers
+	}
+	return &sm, nil
+}
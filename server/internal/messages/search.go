@@ -0,0 +1,58 @@
+package messages
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+// SearchPosts full-text searches channel posts, the only cleartext message
+// bodies, in channels the caller subscribes to or public channels they are
+// not banned from. Setting chatID restricts the search to one channel.
+// Results rank by relevance, newest first among equals.
+func (s *Service) SearchPosts(ctx context.Context, userID uuid.UUID, chatID *uuid.UUID, text string, limit, offset int) ([]Message, error) {
+	if chatID != nil {
+		m, err := s.chats.Authorize(ctx, *chatID, userID, chats.ActionRead)
+		if err != nil {
+			return nil, err
+		}
+		if m.Kind != chats.KindChannel {
+			return nil, chats.ErrNotChannel
+		}
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT `+messageColumns+`
+      FROM messages m
+      JOIN chats c ON c.id = m.chat_id AND c.kind = 'channel'
+      LEFT JOIN chat_participants p ON p.chat_id = c.id AND p.user_id = $1
+      WHERE m.body IS NOT NULL
+        AND to_tsvector('simple', m.body) @@ websearch_to_tsquery('simple', $2)
+        AND ($3::uuid IS NULL OR m.chat_id = $3)
+        AND (p.user_id IS NOT NULL OR (c.is_public
+          AND NOT EXISTS (SELECT 1 FROM chat_bans b
+            WHERE b.chat_id = c.id AND b.user_id = $1 AND (b.until IS NULL OR b.until > now()))))
+        AND m.deleted_at IS NULL AND `+notExpired+`
+        AND NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id = $1)
+      ORDER BY ts_rank_cd(to_tsvector('simple', m.body), websearch_to_tsquery('simple', $2)) DESC,
+        m.created_at DESC, m.id DESC
+      LIMIT $4 OFFSET $5`, userID, text, chatID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Message, 0, limit)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, s.attachAggregates(ctx, userID, out)
+}
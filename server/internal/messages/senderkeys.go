@@ -0,0 +1,247 @@
+package messages
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/kentapp/kent/server/internal/chats"
+)
+
+const (
+	EventSenderKey = "senderkey.new"
+
+	MaxEnvelopes    = 1000
+	MaxEnvelopeSize = 4 << 10
+)
+
+var (
+	ErrInvalidEnvelopes = errors.New("messages: sender-key envelopes are invalid or address non-member devices")
+	ErrStaleSenderKey   = errors.New("messages: sender key epoch is not current")
+)
+
+// Envelope is a sender-key distribution message encrypted pairwise for one
+// device.
+type Envelope struct {
+	DeviceID   uuid.UUID `json:"deviceId"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// SenderKeyMessage is a queued envelope as its recipient device sees it.
+type SenderKeyMessage struct {
+	ID             uuid.UUID  `json:"id"`
+	ChatID         uuid.UUID  `json:"chatId"`
+	Epoch          int        `json:"epoch"`
+	SenderID       uuid.UUID  `json:"senderId"`
+	SenderDeviceID uuid.UUID  `json:"senderDeviceId"`
+	MessageID      *uuid.UUID `json:"messageId,omitempty"`
+	Ciphertext     []byte     `json:"ciphertext"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// MemberDevice is a device a group sender key must be distributed to.
+type MemberDevice struct {
+	UserID   uuid.UUID `json:"userId"`
+	DeviceID uuid.UUID `json:"deviceId"`
+}
+
+// SenderKeyDevices returns the group's current sender-key epoch and the
+// verified member devices that have published an identity key.
+func (s *Service) SenderKeyDevices(ctx context.Context, userID, chatID uuid.UUID) (int, []MemberDevice, error) {
+	m, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead)
+	if err != nil {
+		return 0, nil, err
+	}
+	if m.Kind != chats.KindGroup {
+		return 0, nil, ErrInvalidEnvelopes
+	}
+	epoch, err := s.senderKeyEpoch(ctx, chatID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT ik.user_id, ik.device_id
+      FROM chat_participants p
+      JOIN device_identity_keys ik ON ik.user_id = p.user_id
+      JOIN devices d ON d.id = ik.device_id AND d.verified
+      WHERE p.chat_id = $1
+      ORDER BY ik.user_id, ik.device_id`, chatID)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var out []MemberDevice
+	for rows.Next() {
+		var d MemberDevice
+		if err := rows.Scan(&d.UserID, &d.DeviceID); err != nil {
+			return 0, nil, err
+		}
+		out = append(out, d)
+	}
+	return epoch, out, rows.Err()
+}
+
+// DistributeSenderKey queues the caller's new sender key for member devices
+// without sending a message. It returns the member devices, other than the
+// calling one, that still lack an envelope for this epoch.
+func (s *Service) DistributeSenderKey(ctx context.Context, userID, deviceID, chatID uuid.UUID, epoch int, envelopes []Envelope) ([]uuid.UUID, error) {
+	m, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionSend)
+	if err != nil {
+		return nil, err
+	}
+	if len(envelopes) == 0 {
+		return nil, ErrInvalidEnvelopes
+	}
+	if err := s.checkSenderKey(ctx, chatID, m.Kind, NewMessage{SenderKeyEpoch: &epoch, Envelopes: envelopes}); err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, len(envelopes))
+	cts := make([][]byte, len(envelopes))
+	for i, e := range envelopes {
+		ids[i], cts[i] = e.DeviceID, e.Ciphertext
+	}
+	if _, err := s.pool.Exec(ctx, `INSERT INTO sender_key_messages (chat_id, epoch, sender_id, sender_device_id, device_id, ciphertext)
+      SELECT $1, $2, $3, $4, e.device_id, e.ciphertext FROM unnest($5::uuid[], $6::bytea[]) AS e(device_id, ciphertext)`,
+		chatID, epoch, userID, deviceID, ids, cts); err != nil {
+		return nil, err
+	}
+	s.senderKeysQueued(ctx, chatID, epoch, deviceID, nil, envelopes)
+
+	rows, err := s.pool.Query(ctx, `SELECT ik.device_id
+      FROM chat_participants p
+      JOIN device_identity_keys ik ON ik.user_id = p.user_id
+      JOIN devices d ON d.id = ik.device_id AND d.verified
+      WHERE p.chat_id = $1 AND ik.device_id <> $2
+        AND NOT EXISTS (SELECT 1 FROM sender_key_messages k
+          WHERE k.chat_id = $1 AND k.epoch = $3 AND k.sender_device_id = $2 AND k.device_id = ik.device_id)`,
+		chatID, deviceID, epoch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		missing = append(missing, id)
+	}
+	return missing, rows.Err()
+}
+
+// PendingSenderKeys returns envelopes queued for the device, oldest first.
+// They stay queued until acknowledged.
+func (s *Service) PendingSenderKeys(ctx context.Context, deviceID uuid.UUID, limit int) ([]SenderKeyMessage, error) {
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+	rows, err := s.pool.Query(ctx, `SELECT id, chat_id, epoch, sender_id, sender_device_id, message_id, ciphertext, created_at
+      FROM sender_key_messages
+      WHERE device_id = $1
+      ORDER BY created_at, id
+      LIMIT $2`, deviceID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]SenderKeyMessage, 0, limit)
+	for rows.Next() {
+		var k SenderKeyMessage
+		if err := rows.Scan(&k.ID, &k.ChatID, &k.Epoch, &k.SenderID, &k.SenderDeviceID, &k.MessageID, &k.Ciphertext, &k.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, k)
+	}
+	return out, rows.Err()
+}
+
+// AckSenderKeys drops envelopes the device has processed.
+func (s *Service) AckSenderKeys(ctx context.Context, deviceID uuid.UUID, ids []uuid.UUID) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM sender_key_messages WHERE device_id = $1 AND id = ANY($2)`, deviceID, ids)
+	return err
+}
+
+// checkSenderKey accepts sender-key ciphertext only in groups and only under
+// the current epoch, so every membership change forces senders to rotate.
+func (s *Service) checkSenderKey(ctx context.Context, chatID uuid.UUID, kind chats.Kind, in NewMessage) error {
+	if in.SenderKeyEpoch == nil {
+		if len(in.Envelopes) > 0 {
+			return ErrInvalidEnvelopes
+		}
+		return nil
+	}
+	if kind != chats.KindGroup {
+		return ErrInvalidEnvelopes
+	}
+	epoch, err := s.senderKeyEpoch(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if *in.SenderKeyEpoch != epoch {
+		return ErrStaleSenderKey
+	}
+	if len(in.Envelopes) == 0 {
+		return nil
+	}
+	return s.validateEnvelopes(ctx, chatID, in.Envelopes)
+}
+
+// validateEnvelopes requires one envelope per device, each for a verified
+// device of a current member.
+func (s *Service) validateEnvelopes(ctx context.Context, chatID uuid.UUID, envelopes []Envelope) error {
+	if len(envelopes) > MaxEnvelopes {
+		return ErrInvalidEnvelopes
+	}
+	seen := make(map[uuid.UUID]bool, len(envelopes))
+	ids := make([]uuid.UUID, 0, len(envelopes))
+	for _, e := range envelopes {
+		if seen[e.DeviceID] || len(e.Ciphertext) == 0 || len(e.Ciphertext) > MaxEnvelopeSize {
+			return ErrInvalidEnvelopes
+		}
+		seen[e.DeviceID] = true
+		ids = append(ids, e.DeviceID)
+	}
+
+	var n int
+	err := s.pool.QueryRow(ctx, `SELECT count(*)
+      FROM devices d
+      JOIN chat_participants p ON p.user_id = d.user_id AND p.chat_id = $1
+      WHERE d.id = ANY($2) AND d.verified`, chatID, ids).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n != len(ids) {
+		return ErrInvalidEnvelopes
+	}
+	return nil
+}
+
+func (s *Service) senderKeyEpoch(ctx context.Context, chatID uuid.UUID) (int, error) {
+	var epoch int
+	err := s.pool.QueryRow(ctx, `SELECT sender_key_epoch FROM chats WHERE id = $1`, chatID).Scan(&epoch)
+	return epoch, err
+}
+
+// senderKeysQueued tells recipient devices to fetch their new envelopes.
+func (s *Service) senderKeysQueued(ctx context.Context, chatID uuid.UUID, epoch int, senderDeviceID uuid.UUID, messageID *uuid.UUID, envelopes []Envelope) {
+	ids := make([]uuid.UUID, len(envelopes))
+	for i, e := range envelopes {
+		ids[i] = e.DeviceID
+	}
+	if err := s.hub.PublishToDevices(ctx, ids, EventSenderKey, map[string]any{
+		"chatId":         chatID,
+		"epoch":          epoch,
+		"senderDeviceId": senderDeviceID,
+		"messageId":      messageID,
+	}); err != nil {
+		log.Printf("sender key fan-out failed: %v", err)
+	}
+}
@@ -0,0 +1,528 @@
+package messages
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/push"
+	"github.com/kentapp/kent/server/internal/realtime"
+)
+
+const (
+	EventNew     = "message.new"
+	EventEdited  = "message.edited"
+	EventDeleted = "message.deleted"
+	EventHidden  = "message.hidden"
+	EventReacted = "message.reaction"
+	EventExpired = "message.expired"
+
+	DefaultPageSize   = 50
+	MaxPageSize       = 200
+	MaxCiphertextSize = 64 << 10
+	MaxPostSize       = 16 << 10
+	MaxMentions       = 50
+
+	MinTTL = 5 * time.Second
+	MaxTTL = 366 * 24 * time.Hour
+)
+
+var (
+	ErrNotFound          = errors.New("messages: message not found")
+	ErrForbidden         = errors.New("messages: action not allowed")
+	ErrEditWindowExpired = errors.New("messages: edit window has expired")
+	ErrDeleted           = errors.New("messages: message was deleted")
+	ErrInvalidBody       = errors.New("messages: body is missing, too large or of the wrong kind for this chat")
+	ErrInvalidReference  = errors.New("messages: referenced message is not in this chat")
+	ErrInvalidReaction   = errors.New("messages: invalid reaction")
+	ErrInvalidTTL        = errors.New("messages: ttl out of range")
+	ErrInvalidMentions   = errors.New("messages: too many mentions or mentions not allowed here")
+)
+
+// Message is a stored message. Deleted messages are tombstones with no
+// ciphertext or headers so that every device can sync the deletion.
+type Message struct {
+	ID             uuid.UUID       `json:"id"`
+	ChatID         uuid.UUID       `json:"chatId"`
+	SenderID       uuid.UUID       `json:"senderId"`
+	SenderDeviceID *uuid.UUID      `json:"senderDeviceId,omitempty"`
+	SenderKeyEpoch *int            `json:"senderKeyEpoch,omitempty"`
+	Ciphertext     []byte          `json:"ciphertext,omitempty"`
+	Body           *string         `json:"body,omitempty"`
+	Headers        json.RawMessage `json:"headers,omitempty"`
+	EditCount      int             `json:"editCount"`
+	EditedAt       *time.Time      `json:"editedAt,omitempty"`
+	DeletedAt      *time.Time      `json:"deletedAt,omitempty"`
+	DeletedBy      *uuid.UUID      `json:"deletedBy,omitempty"`
+	ReplyToID      *uuid.UUID      `json:"replyToId,omitempty"`
+	ThreadRootID   *uuid.UUID      `json:"threadRootId,omitempty"`
+	Mentions       []uuid.UUID     `json:"mentions,omitempty"`
+	ForwardedFrom  *Forwarded      `json:"forwardedFrom,omitempty"`
+	ExpiresAt      *time.Time      `json:"expiresAt,omitempty"`
+	ViewCount      int64           `json:"viewCount,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	UpdatedAt      time.Time       `json:"updatedAt"`
+
+	Reactions     []ReactionCount `json:"reactions,omitempty"`
+	ThreadReplies int             `json:"threadReplies,omitempty"`
+	Tags          []string        `json:"tags,omitempty"`
+}
+
+// NewMessage is the client-supplied part of a message being sent. Channel
+// posts carry a cleartext Body; every other chat carries Ciphertext.
+type NewMessage struct {
+	Ciphertext   []byte
+	Body         *string
+	Headers      json.RawMessage
+	ReplyToID    *uuid.UUID
+	ThreadRootID *uuid.UUID
+	// Mentions lists mentioned participants in cleartext; the author of
+	// ReplyToID is notified as well.
+	Mentions []uuid.UUID
+	// ForwardedFrom is set by Forward, never by clients.
+	ForwardedFrom *Forwarded
+	// TTL overrides the chat's disappearing-messages timer when non-zero.
+	TTL time.Duration
+	// SenderKeyEpoch is set when Ciphertext is encrypted with the sender's
+	// group sender key, and must be the chat's current epoch. Envelopes
+	// carry the sender key to member devices that do not have it yet.
+	SenderKeyEpoch *int
+	Envelopes      []Envelope
+}
+
+// HistoryQuery pages backwards from Before, forwards from After, or returns
+// everything changed after UpdatedSince in change order when that is set.
+// Thread replies are only paged when ThreadRootID is set. Tag filters
+// saved messages.
+type HistoryQuery struct {
+	Before       *uuid.UUID
+	After        *uuid.UUID
+	UpdatedSince *time.Time
+	ThreadRootID *uuid.UUID
+	Tag          string
+	Limit        int
+}
+
+type Service struct {
+	pool       *pgxpool.Pool
+	chats      *chats.Repository
+	hub        *realtime.Hub
+	push       *push.Dispatcher
+	editWindow time.Duration
+}
+
+func NewService(pool *pgxpool.Pool, chatRepo *chats.Repository, hub *realtime.Hub, pusher *push.Dispatcher, editWindow time.Duration) *Service {
+	return &Service{pool: pool, chats: chatRepo, hub: hub, push: pusher, editWindow: editWindow}
+}
+
+const messageColumns = `m.id, m.chat_id, m.sender_id, m.sender_device_id, m.sender_key_epoch, m.ciphertext, m.body, m.headers,
+      m.edit_count, m.edited_at, m.deleted_at, m.deleted_by, m.reply_to_id, m.thread_root_id, m.mentions,
+      m.forwarded_from, m.expires_at, m.view_count, m.created_at, m.updated_at`
+
+// notExpired must be part of every read so that expired messages are never
+// served, even before the sweeper removes them.
+const notExpired = `(m.expires_at IS NULL OR m.expires_at > now())`
+
+func (s *Service) Send(ctx context.Context, userID, deviceID, chatID uuid.UUID, in NewMessage) (*Message, error) {
+	kind, err := s.checkSend(ctx, userID, chatID, in)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := insertMessage(ctx, s.pool, userID, &deviceID, chatID, in)
+	if err != nil {
+		return nil, err
+	}
+	s.delivered(ctx, kind, msg)
+	if len(in.Envelopes) > 0 {
+		s.senderKeysQueued(ctx, msg.ChatID, *msg.SenderKeyEpoch, deviceID, &msg.ID, in.Envelopes)
+	}
+	s.clearDraft(ctx, userID, chatID)
+	return msg, nil
+}
+
+// clearDraft drops the sender's draft once a message went out from any of
+// their devices and tells the other devices.
+func (s *Service) clearDraft(ctx context.Context, userID, chatID uuid.UUID) {
+	draft, err := s.chats.ClearDraft(ctx, chatID, userID)
+	if err != nil {
+		log.Printf("draft clear failed: %v", err)
+		return
+	}
+	if draft != nil {
+		s.notifyUsers(ctx, []uuid.UUID{userID}, chats.EventDraft, map[string]any{"chatId": chatID, "draft": draft})
+	}
+}
+
+// checkSend runs every check a new message must pass before it is stored
+// and returns the kind of the target chat.
+func (s *Service) checkSend(ctx context.Context, userID, chatID uuid.UUID, in NewMessage) (chats.Kind, error) {
+	member, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionSend)
+	if err != nil {
+		return "", err
+	}
+	if err := validateContent(member.Kind, in); err != nil {
+		return "", err
+	}
+	if err := s.checkSenderKey(ctx, chatID, member.Kind, in); err != nil {
+		return "", err
+	}
+	if err := s.validateReferences(ctx, chatID, in); err != nil {
+		return "", err
+	}
+	if err := s.validateAttachments(ctx, userID, member.Kind, in); err != nil {
+		return "", err
+	}
+	if in.TTL != 0 && (in.TTL < MinTTL || in.TTL > MaxTTL) {
+		return "", ErrInvalidTTL
+	}
+	return member.Kind, nil
+}
+
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// insertMessage stores an already checked message through q, which may be
+// the pool or a transaction. Mentioned participants and the author of the
+// replied-to message get a message_mentions row, and sender-key envelopes
+// are queued, in the same statement. Sender-key ciphertext is checked
+// against the chat's epoch again under a share lock on the chat, so a
+// membership change cannot slip in after checkSend; it then returns
+// ErrStaleSenderKey.
+func insertMessage(ctx context.Context, q rowQuerier, userID uuid.UUID, deviceID *uuid.UUID, chatID uuid.UUID, in NewMessage) (*Message, error) {
+	envelopeDevices := make([]uuid.UUID, len(in.Envelopes))
+	envelopeCiphertexts := make([][]byte, len(in.Envelopes))
+	for i, e := range in.Envelopes {
+		envelopeDevices[i], envelopeCiphertexts[i] = e.DeviceID, e.Ciphertext
+	}
+	row := q.QueryRow(ctx, `WITH epoch AS (
+        SELECT 1 FROM chats WHERE id = $1 AND sender_key_epoch = $12 FOR SHARE
+      ), m AS (
+        INSERT INTO messages (chat_id, sender_id, sender_device_id, ciphertext, body, headers, reply_to_id, thread_root_id, mentions, forwarded_from,
+          sender_key_epoch, expires_at)
+        SELECT $1::uuid, $2::uuid, $3::uuid, $4::bytea, $5::text, $6::jsonb, $7::uuid, $8::uuid, $9::uuid[], $11::jsonb, $12::int,
+          now() + make_interval(secs => COALESCE($10::int, (SELECT message_ttl_seconds FROM chats WHERE id = $1)))
+        WHERE $12::int IS NULL OR EXISTS (SELECT 1 FROM epoch)
+        RETURNING *
+      ), targets AS (
+        SELECT t.user_id, 'mention' AS kind FROM unnest($9::uuid[]) AS t(user_id)
+        UNION ALL
+        SELECT r.sender_id, 'reply' FROM messages r WHERE r.id = $7
+      ), mentioned AS (
+        INSERT INTO message_mentions (message_id, chat_id, user_id, kind)
+        SELECT DISTINCT ON (t.user_id) m.id, m.chat_id, t.user_id, t.kind
+        FROM m, targets t
+        JOIN chat_participants p ON p.chat_id = $1 AND p.user_id = t.user_id
+        WHERE t.user_id <> $2
+        ORDER BY t.user_id, t.kind
+      ), envelopes AS (
+        INSERT INTO sender_key_messages (chat_id, epoch, sender_id, sender_device_id, device_id, message_id, ciphertext)
+        SELECT m.chat_id, m.sender_key_epoch, m.sender_id, m.sender_device_id, e.device_id, m.id, e.ciphertext
+        FROM m, unnest($13::uuid[], $14::bytea[]) AS e(device_id, ciphertext)
+      )
+      SELECT `+messageColumns+` FROM m`,
+		chatID, userID, deviceID, nilIfEmpty(in.Ciphertext), in.Body, jsonOrNil(in.Headers), in.ReplyToID, in.ThreadRootID, mentionsOrNil(in.Mentions), ttlSecondsOf(in.TTL), in.ForwardedFrom,
+		in.SenderKeyEpoch, envelopeDevices, envelopeCiphertexts)
+	msg, err := scanMessage(row)
+	if errors.Is(err, ErrNotFound) && in.SenderKeyEpoch != nil {
+		return nil, ErrStaleSenderKey
+	}
+	return msg, err
+}
+
+// delivered fans a stored message out to the chat. Channels get no pushes.
+func (s *Service) delivered(ctx context.Context, kind chats.Kind, msg *Message) {
+	s.notifyChat(ctx, msg.ChatID, EventNew, msg)
+	if kind != chats.KindChannel {
+		go s.pushNew(context.WithoutCancel(ctx), msg)
+	}
+}
+
+func (s *Service) History(ctx context.Context, userID, chatID uuid.UUID, q HistoryQuery) ([]Message, error) {
+	member, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionRead)
+	if err != nil {
+		return nil, err
+	}
+	if member.Kind != chats.KindSelf {
+		q.Tag = ""
+	}
+	list, err := s.history(ctx, userID, chatID, q)
+	if err != nil || member.Kind != chats.KindSelf {
+		return list, err
+	}
+	return list, s.attachTags(ctx, list)
+}
+
+// history runs the query behind History; callers must authorize first.
+func (s *Service) history(ctx context.Context, userID, chatID uuid.UUID, q HistoryQuery) ([]Message, error) {
+	limit := q.Limit
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+
+	conds := []string{
+		"m.chat_id = $1",
+		"NOT EXISTS (SELECT 1 FROM message_hidden h WHERE h.message_id = m.id AND h.user_id = $2)",
+		notExpired,
+	}
+	args := []any{chatID, userID}
+	order := "m.created_at DESC, m.id DESC"
+
+	if q.UpdatedSince != nil {
+		args = append(args, *q.UpdatedSince)
+		conds = append(conds, fmt.Sprintf("m.updated_at > $%d", len(args)))
+		order = "m.updated_at ASC, m.id ASC"
+	} else if q.ThreadRootID != nil {
+		args = append(args, *q.ThreadRootID)
+		conds = append(conds, fmt.Sprintf("m.thread_root_id = $%d", len(args)))
+	} else {
+		conds = append(conds, "m.thread_root_id IS NULL")
+	}
+	if q.UpdatedSince == nil && q.Before != nil {
+		args = append(args, *q.Before)
+		conds = append(conds, fmt.Sprintf("(m.created_at, m.id) < (SELECT created_at, id FROM messages WHERE id = $%d)", len(args)))
+	} else if q.UpdatedSince == nil && q.After != nil {
+		args = append(args, *q.After)
+		conds = append(conds, fmt.Sprintf("(m.created_at, m.id) > (SELECT created_at, id FROM messages WHERE id = $%d)", len(args)))
+		order = "m.created_at ASC, m.id ASC"
+	}
+	if q.Tag != "" {
+		args = append(args, q.Tag)
+		conds = append(conds, fmt.Sprintf("EXISTS (SELECT 1 FROM saved_message_tags t WHERE t.message_id = m.id AND t.tag = $%d)", len(args)))
+	}
+	args = append(args, limit)
+
+	rows, err := s.pool.Query(ctx, `SELECT `+messageColumns+`
+      FROM messages m
+      WHERE `+strings.Join(conds, " AND ")+`
+      ORDER BY `+order+fmt.Sprintf(" LIMIT $%d", len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Message, 0, limit)
+	for rows.Next() {
+		msg, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return out, s.attachAggregates(ctx, userID, out)
+}
+
+// Edit replaces the body of the caller's own message while the edit window
+// is open. Only Ciphertext, Body, Headers and SenderKeyEpoch of in are used.
+func (s *Service) Edit(ctx context.Context, userID, messageID uuid.UUID, in NewMessage) (*Message, error) {
+	current, err := s.get(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	member, err := s.chats.Authorize(ctx, current.ChatID, userID, chats.ActionSend)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateContent(member.Kind, in); err != nil {
+		return nil, err
+	}
+	in.Envelopes = nil
+	if err := s.checkSenderKey(ctx, current.ChatID, member.Kind, in); err != nil {
+		return nil, err
+	}
+	if err := s.validateAttachments(ctx, userID, member.Kind, in); err != nil {
+		return nil, err
+	}
+	if current.SenderID != userID {
+		return nil, ErrForbidden
+	}
+	if current.DeletedAt != nil {
+		return nil, ErrDeleted
+	}
+	if time.Since(current.CreatedAt) > s.editWindow {
+		return nil, ErrEditWindowExpired
+	}
+
+	// The epoch is checked again under a share lock, as in insertMessage.
+	row := s.pool.QueryRow(ctx, `WITH epoch AS (
+        SELECT 1 FROM chats WHERE id = $6 AND sender_key_epoch = $5 FOR SHARE
+      )
+      UPDATE messages AS m
+      SET ciphertext=$2, body=$3, headers=COALESCE($4, headers), sender_key_epoch=$5, edit_count=edit_count+1, edited_at=now(), updated_at=now()
+      WHERE id=$1 AND deleted_at IS NULL AND ($5::int IS NULL OR EXISTS (SELECT 1 FROM epoch))
+      RETURNING `+messageColumns, messageID, nilIfEmpty(in.Ciphertext), in.Body, jsonOrNil(in.Headers), in.SenderKeyEpoch, current.ChatID)
+	msg, err := scanMessage(row)
+	if errors.Is(err, ErrNotFound) && in.SenderKeyEpoch != nil {
+		return nil, ErrStaleSenderKey
+	}
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrDeleted
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyChat(ctx, msg.ChatID, EventEdited, msg)
+	return msg, nil
+}
+
+// DeleteForEveryone turns the message into a tombstone. Senders may delete
+// their own messages; group admins with the delete right may delete any.
+func (s *Service) DeleteForEveryone(ctx context.Context, userID, messageID uuid.UUID) (*Message, error) {
+	current, err := s.get(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	action := chats.ActionRead
+	if current.SenderID != userID {
+		action = chats.ActionDeleteMessages
+	}
+	if _, err := s.chats.Authorize(ctx, current.ChatID, userID, action); err != nil {
+		return nil, err
+	}
+	if current.DeletedAt != nil {
+		return current, nil
+	}
+
+	row := s.pool.QueryRow(ctx, `UPDATE messages AS m
+      SET ciphertext=NULL, body=NULL, headers=NULL, mentions=NULL, forwarded_from=NULL, sender_key_epoch=NULL, deleted_at=now(), deleted_by=$2, updated_at=now()
+      WHERE id=$1 AND deleted_at IS NULL
+      RETURNING `+messageColumns, messageID, userID)
+	msg, err := scanMessage(row)
+	if errors.Is(err, ErrNotFound) {
+		return s.get(ctx, messageID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyChat(ctx, msg.ChatID, EventDeleted, msg)
+	return msg, nil
+}
+
+// HideForMe removes the message from the caller's own history only.
+func (s *Service) HideForMe(ctx context.Context, userID, messageID uuid.UUID) error {
+	current, err := s.get(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.chats.Authorize(ctx, current.ChatID, userID, chats.ActionRead); err != nil {
+		return err
+	}
+
+	if _, err := s.pool.Exec(ctx, `INSERT INTO message_hidden (message_id, user_id) VALUES ($1, $2)
+      ON CONFLICT DO NOTHING`, messageID, userID); err != nil {
+		return err
+	}
+
+	s.notifyUsers(ctx, []uuid.UUID{userID}, EventHidden, map[string]any{
+		"chatId":    current.ChatID,
+		"messageId": messageID,
+	})
+	return nil
+}
+
+func (s *Service) get(ctx context.Context, messageID uuid.UUID) (*Message, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+messageColumns+` FROM messages m WHERE m.id=$1 AND `+notExpired, messageID)
+	return scanMessage(row)
+}
+
+func (s *Service) notifyChat(ctx context.Context, chatID uuid.UUID, eventType string, data any) {
+	recipients, err := s.chats.RecipientIDs(ctx, chatID)
+	if err != nil {
+		log.Printf("message fan-out failed: %v", err)
+		return
+	}
+	s.notifyUsers(ctx, recipients, eventType, data)
+}
+
+func (s *Service) notifyUsers(ctx context.Context, userIDs []uuid.UUID, eventType string, data any) {
+	if err := s.hub.PublishToUsers(ctx, userIDs, eventType, data); err != nil {
+		log.Printf("message fan-out failed: %v", err)
+	}
+}
+
+func (s *Service) pushNew(ctx context.Context, msg *Message) {
+	if err := s.push.MessageCreated(ctx, msg.ChatID, msg.ID, msg.SenderID); err != nil {
+		log.Printf("push dispatch failed: %v", err)
+	}
+}
+
+func scanMessage(row pgx.Row) (*Message, error) {
+	var m Message
+	var headers []byte
+	err := row.Scan(&m.ID, &m.ChatID, &m.SenderID, &m.SenderDeviceID, &m.SenderKeyEpoch, &m.Ciphertext, &m.Body, &headers,
+		&m.EditCount, &m.EditedAt, &m.DeletedAt, &m.DeletedBy, &m.ReplyToID, &m.ThreadRootID, &m.Mentions,
+		&m.ForwardedFrom, &m.ExpiresAt, &m.ViewCount, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if len(headers) > 0 {
+		m.Headers = json.RawMessage(headers)
+	}
+	return &m, nil
+}
+
+// validateContent requires a cleartext body for channel posts and an
+// encrypted body everywhere else.
+func validateContent(kind chats.Kind, in NewMessage) error {
+	if len(in.Mentions) > MaxMentions || (kind == chats.KindChannel && len(in.Mentions) > 0) {
+		return ErrInvalidMentions
+	}
+	if kind == chats.KindChannel {
+		if len(in.Ciphertext) != 0 || in.Body == nil || *in.Body == "" || len(*in.Body) > MaxPostSize {
+			return ErrInvalidBody
+		}
+		return nil
+	}
+	if in.Body != nil || len(in.Ciphertext) == 0 || len(in.Ciphertext) > MaxCiphertextSize {
+		return ErrInvalidBody
+	}
+	return nil
+}
+
+func ttlSecondsOf(ttl time.Duration) *int {
+	if ttl == 0 {
+		return nil
+	}
+	secs := int(ttl / time.Second)
+	return &secs
+}
+
+func mentionsOrNil(ids []uuid.UUID) []uuid.UUID {
+	if len(ids) == 0 {
+		return nil
+	}
+	return ids
+}
+
+func nilIfEmpty(b []byte) []byte {
+	if len(b) == 0 {
+		return nil
+	}
+	return b
+}
+
+func jsonOrNil(raw json.RawMessage) []byte {
+	trimmed := strings.TrimSpace(string(raw))
+	if trimmed == "" || trimmed == "null" {
+		return nil
+	}
+	return []byte(trimmed)
+}
@@ -0,0 +1,426 @@
+package mls
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/realtime"
+)
+
+const (
+	EventHandshake      = "mls.message"
+	EventWelcome        = "mls.welcome"
+	EventKeyPackagesLow = "mls.keypackages.low"
+
+	MaxKeyPackageSize    = 16 << 10
+	MaxKeyPackageBatch   = 100
+	MaxStoredKeyPackages = 500
+	// LowKeyPackageThreshold is the remaining key package count at which a
+	// device is asked to upload more.
+	LowKeyPackageThreshold = 10
+
+	MaxHandshakeSize = 256 << 10
+	MaxWelcomeSize   = 256 << 10
+
+	DefaultPageSize = 100
+	MaxPageSize     = 500
+)
+
+var (
+	ErrInvalidKeyPackage = errors.New("mls: key package is empty or too large")
+	ErrTooManyPackages   = errors.New("mls: too many key packages")
+	ErrNoKeyPackages     = errors.New("mls: no device of the user has key packages")
+	ErrNotGroup          = errors.New("mls: chat is not a group")
+	ErrInvalidHandshake  = errors.New("mls: handshake message is invalid")
+	ErrInvalidWelcome    = errors.New("mls: welcome is invalid or addresses non-member devices")
+	ErrStaleEpoch        = errors.New("mls: handshake is not for the current epoch")
+)
+
+type Kind string
+
+const (
+	KindProposal Kind = "proposal"
+	KindCommit   Kind = "commit"
+)
+
+func (k Kind) Valid() bool {
+	return k == KindProposal || k == KindCommit
+}
+
+// KeyPackage is a device's published MLS KeyPackage. LastResort is set when
+// the device had no others left; it is served again to later requests.
+type KeyPackage struct {
+	DeviceID   uuid.UUID `json:"deviceId"`
+	KeyPackage []byte    `json:"keyPackage"`
+	LastResort bool      `json:"lastResort,omitempty"`
+}
+
+// Handshake is a stored Proposal or Commit. Seq is the delivery order within
+// the group; Epoch is the epoch it was sent in.
+type Handshake struct {
+	Seq            int64     `json:"seq"`
+	ChatID         uuid.UUID `json:"chatId"`
+	Epoch          int64     `json:"epoch"`
+	Kind           Kind      `json:"kind"`
+	SenderID       uuid.UUID `json:"senderId"`
+	SenderDeviceID uuid.UUID `json:"senderDeviceId"`
+	Payload        []byte    `json:"payload"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// NewHandshake is a client-submitted handshake message. A commit that adds
+// members carries the Welcome for the added devices.
+type NewHandshake struct {
+	Epoch          int64       `json:"epoch"`
+	Kind           Kind        `json:"kind"`
+	Payload        []byte      `json:"payload"`
+	Welcome        []byte      `json:"welcome"`
+	WelcomeDevices []uuid.UUID `json:"welcomeDeviceIds"`
+}
+
+// Welcome is a queued MLS Welcome for one device.
+type Welcome struct {
+	ID        uuid.UUID `json:"id"`
+	ChatID    uuid.UUID `json:"chatId"`
+	Epoch     int64     `json:"epoch"`
+	Payload   []byte    `json:"payload"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Service is the MLS Delivery Service: it stores KeyPackages, orders
+// handshake messages per group epoch and delivers Welcomes. Group
+// membership is taken from chats; application messages use the regular
+// message routes.
+type Service struct {
+	pool  *pgxpool.Pool
+	chats *chats.Repository
+	hub   *realtime.Hub
+}
+
+func NewService(pool *pgxpool.Pool, chatRepo *chats.Repository, hub *realtime.Hub) *Service {
+	return &Service{pool: pool, chats: chatRepo, hub: hub}
+}
+
+// AddKeyPackages stores KeyPackages for the device and, when lastResort is
+// set, replaces its last-resort package. It returns how many consumable
+// packages the device now has.
+func (s *Service) AddKeyPackages(ctx context.Context, userID, deviceID uuid.UUID, packages [][]byte, lastResort []byte) (count int, err error) {
+	if len(lastResort) == 0 {
+		lastResort = nil
+	}
+	if len(packages) > MaxKeyPackageBatch || (len(packages) == 0 && lastResort == nil) || len(lastResort) > MaxKeyPackageSize {
+		return 0, ErrInvalidKeyPackage
+	}
+	for _, kp := range packages {
+		if len(kp) == 0 || len(kp) > MaxKeyPackageSize {
+			return 0, ErrInvalidKeyPackage
+		}
+	}
+
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	if len(packages) > 0 {
+		if _, err = tx.Exec(ctx, `INSERT INTO mls_key_packages (device_id, user_id, key_package)
+          SELECT $1, $2, kp FROM unnest($3::bytea[]) AS kp`, deviceID, userID, packages); err != nil {
+			return 0, err
+		}
+	}
+	if lastResort != nil {
+		if _, err = tx.Exec(ctx, `INSERT INTO mls_key_packages (device_id, user_id, key_package, last_resort) VALUES ($1, $2, $3, TRUE)
+          ON CONFLICT (device_id) WHERE last_resort DO UPDATE SET key_package = EXCLUDED.key_package, created_at = now()`,
+			deviceID, userID, lastResort); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.QueryRow(ctx, `SELECT count(*) FROM mls_key_packages WHERE device_id=$1 AND NOT last_resort`, deviceID).Scan(&count); err != nil {
+		return 0, err
+	}
+	if count > MaxStoredKeyPackages {
+		err = ErrTooManyPackages
+		return 0, err
+	}
+	return count, nil
+}
+
+// KeyPackageCount returns how many consumable key packages the device has.
+func (s *Service) KeyPackageCount(ctx context.Context, deviceID uuid.UUID) (int, error) {
+	var n int
+	err := s.pool.QueryRow(ctx, `SELECT count(*) FROM mls_key_packages WHERE device_id=$1 AND NOT last_resort`, deviceID).Scan(&n)
+	return n, err
+}
+
+// ClaimKeyPackages returns one KeyPackage for each of the user's verified
+// devices, or only for deviceID when set, skipping the requesting device.
+// Each claim consumes a package unless only the last-resort one is left.
+func (s *Service) ClaimKeyPackages(ctx context.Context, requesterDeviceID, userID uuid.UUID, deviceID *uuid.UUID) ([]KeyPackage, error) {
+	rows, err := s.pool.Query(ctx, `WITH targets AS (
+        SELECT DISTINCT k.device_id FROM mls_key_packages k
+        JOIN devices d ON d.id = k.device_id AND d.verified
+        WHERE k.user_id = $1 AND k.device_id <> $2 AND ($3::uuid IS NULL OR k.device_id = $3)),
+      claimed AS (
+        DELETE FROM mls_key_packages k
+        USING (SELECT pk.id FROM targets t
+          CROSS JOIN LATERAL (
+            SELECT id FROM mls_key_packages
+            WHERE device_id = t.device_id AND NOT last_resort
+            ORDER BY created_at
+            LIMIT 1
+            FOR UPDATE SKIP LOCKED) pk) pick
+        WHERE k.id = pick.id
+        RETURNING k.device_id, k.key_package)
+      SELECT t.device_id, COALESCE(c.key_package, lr.key_package), c.device_id IS NULL
+      FROM targets t
+      LEFT JOIN claimed c ON c.device_id = t.device_id
+      LEFT JOIN mls_key_packages lr ON lr.device_id = t.device_id AND lr.last_resort
+      WHERE c.device_id IS NOT NULL OR lr.id IS NOT NULL
+      ORDER BY t.device_id`, userID, requesterDeviceID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []KeyPackage
+	for rows.Next() {
+		var kp KeyPackage
+		if err := rows.Scan(&kp.DeviceID, &kp.KeyPackage, &kp.LastResort); err != nil {
+			return nil, err
+		}
+		out = append(out, kp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, ErrNoKeyPackages
+	}
+
+	s.signalLow(ctx, out)
+	return out, nil
+}
+
+// Submit appends a Proposal or Commit to the group's handshake log. It must
+// be sent in the current epoch; a Commit moves the group to the next epoch
+// and queues its Welcome for the added devices. Of two commits racing for
+// the same epoch, the second is rejected with ErrStaleEpoch.
+func (s *Service) Submit(ctx context.Context, userID, deviceID, chatID uuid.UUID, in NewHandshake) (*Handshake, error) {
+	if !in.Kind.Valid() || len(in.Payload) == 0 || len(in.Payload) > MaxHandshakeSize {
+		return nil, ErrInvalidHandshake
+	}
+	if (in.Welcome == nil) != (len(in.WelcomeDevices) == 0) || len(in.Welcome) > MaxWelcomeSize ||
+		(in.Welcome != nil && in.Kind != KindCommit) {
+		return nil, ErrInvalidWelcome
+	}
+	if err := s.authorizeGroup(ctx, chatID, userID, chats.ActionSend); err != nil {
+		return nil, err
+	}
+	if err := s.validateWelcomeDevices(ctx, chatID, in.WelcomeDevices); err != nil {
+		return nil, err
+	}
+
+	h, err := s.appendHandshake(ctx, userID, deviceID, chatID, in)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients, err := s.chats.RecipientIDs(ctx, chatID)
+	if err != nil {
+		log.Printf("mls fan-out failed: %v", err)
+	} else if err := s.hub.PublishToUsers(ctx, recipients, EventHandshake, map[string]any{
+		"chatId": chatID, "seq": h.Seq, "epoch": h.Epoch, "kind": h.Kind,
+	}); err != nil {
+		log.Printf("mls fan-out failed: %v", err)
+	}
+	if len(in.WelcomeDevices) > 0 {
+		if err := s.hub.PublishToDevices(ctx, in.WelcomeDevices, EventWelcome, map[string]any{
+			"chatId": chatID, "epoch": h.Epoch + 1,
+		}); err != nil {
+			log.Printf("mls welcome fan-out failed: %v", err)
+		}
+	}
+	return h, nil
+}
+
+func (s *Service) appendHandshake(ctx context.Context, userID, deviceID, chatID uuid.UUID, in NewHandshake) (h *Handshake, err error) {
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, `INSERT INTO mls_groups (chat_id) VALUES ($1) ON CONFLICT DO NOTHING`, chatID); err != nil {
+		return nil, err
+	}
+	var epoch int64
+	if err = tx.QueryRow(ctx, `SELECT epoch FROM mls_groups WHERE chat_id=$1 FOR UPDATE`, chatID).Scan(&epoch); err != nil {
+		return nil, err
+	}
+	if in.Epoch != epoch {
+		err = ErrStaleEpoch
+		return nil, err
+	}
+
+	h = &Handshake{ChatID: chatID, Epoch: epoch, Kind: in.Kind, SenderID: userID, SenderDeviceID: deviceID, Payload: in.Payload}
+	err = tx.QueryRow(ctx, `INSERT INTO mls_messages (chat_id, epoch, kind, sender_id, sender_device_id, payload)
+      VALUES ($1, $2, $3, $4, $5, $6) RETURNING seq, created_at`,
+		chatID, epoch, in.Kind, userID, deviceID, in.Payload).Scan(&h.Seq, &h.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if in.Kind != KindCommit {
+		return h, nil
+	}
+
+	if _, err = tx.Exec(ctx, `UPDATE mls_groups SET epoch = epoch + 1, updated_at = now() WHERE chat_id=$1`, chatID); err != nil {
+		return nil, err
+	}
+	if len(in.WelcomeDevices) > 0 {
+		_, err = tx.Exec(ctx, `INSERT INTO mls_welcomes (chat_id, device_id, epoch, payload)
+          SELECT $1, d, $2, $3 FROM unnest($4::uuid[]) AS d`, chatID, epoch+1, in.Welcome, in.WelcomeDevices)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Handshakes pages the group's handshake log after the given seq and
+// returns the group's current epoch.
+func (s *Service) Handshakes(ctx context.Context, userID, chatID uuid.UUID, afterSeq int64, limit int) ([]Handshake, int64, error) {
+	if err := s.authorizeGroup(ctx, chatID, userID, chats.ActionRead); err != nil {
+		return nil, 0, err
+	}
+	if limit <= 0 || limit > MaxPageSize {
+		limit = DefaultPageSize
+	}
+
+	var epoch int64
+	err := s.pool.QueryRow(ctx, `SELECT COALESCE((SELECT epoch FROM mls_groups WHERE chat_id=$1), 0)`, chatID).Scan(&epoch)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT seq, chat_id, epoch, kind, sender_id, sender_device_id, payload, created_at
+      FROM mls_messages
+      WHERE chat_id = $1 AND seq > $2
+      ORDER BY seq
+      LIMIT $3`, chatID, afterSeq, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	out := make([]Handshake, 0, limit)
+	for rows.Next() {
+		var h Handshake
+		if err := rows.Scan(&h.Seq, &h.ChatID, &h.Epoch, &h.Kind, &h.SenderID, &h.SenderDeviceID, &h.Payload, &h.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		out = append(out, h)
+	}
+	return out, epoch, rows.Err()
+}
+
+// PendingWelcomes returns Welcomes queued for the device, oldest first. They
+// stay queued until acknowledged.
+func (s *Service) PendingWelcomes(ctx context.Context, deviceID uuid.UUID) ([]Welcome, error) {
+	rows, err := s.pool.Query(ctx, `SELECT id, chat_id, epoch, payload, created_at
+      FROM mls_welcomes
+      WHERE device_id = $1
+      ORDER BY created_at, id
+      LIMIT $2`, deviceID, MaxPageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Welcome
+	for rows.Next() {
+		var w Welcome
+		if err := rows.Scan(&w.ID, &w.ChatID, &w.Epoch, &w.Payload, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+// AckWelcomes drops Welcomes the device has processed.
+func (s *Service) AckWelcomes(ctx context.Context, deviceID uuid.UUID, ids []uuid.UUID) error {
+	_, err := s.pool.Exec(ctx, `DELETE FROM mls_welcomes WHERE device_id = $1 AND id = ANY($2)`, deviceID, ids)
+	return err
+}
+
+func (s *Service) authorizeGroup(ctx context.Context, chatID, userID uuid.UUID, action chats.Action) error {
+	m, err := s.chats.Authorize(ctx, chatID, userID, action)
+	if err != nil {
+		return err
+	}
+	if m.Kind != chats.KindGroup {
+		return ErrNotGroup
+	}
+	return nil
+}
+
+// validateWelcomeDevices requires every Welcome recipient to be a verified
+// device of a current chat member; members are added through the chat
+// routes first.
+func (s *Service) validateWelcomeDevices(ctx context.Context, chatID uuid.UUID, deviceIDs []uuid.UUID) error {
+	if len(deviceIDs) == 0 {
+		return nil
+	}
+	seen := make(map[uuid.UUID]bool, len(deviceIDs))
+	for _, id := range deviceIDs {
+		if seen[id] {
+			return ErrInvalidWelcome
+		}
+		seen[id] = true
+	}
+
+	var n int
+	err := s.pool.QueryRow(ctx, `SELECT count(*)
+      FROM devices d
+      JOIN chat_participants p ON p.user_id = d.user_id AND p.chat_id = $1
+      WHERE d.id = ANY($2) AND d.verified`, chatID, deviceIDs).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n != len(deviceIDs) {
+		return ErrInvalidWelcome
+	}
+	return nil
+}
+
+// signalLow tells devices that are running out of key packages how many
+// they have left.
+func (s *Service) signalLow(ctx context.Context, claimed []KeyPackage) {
+	ids := make([]uuid.UUID, len(claimed))
+	for i, kp := range claimed {
+		ids[i] = kp.DeviceID
+	}
+	s.hub.SignalLow(ctx, s.pool, `SELECT device_id, count(*) FROM mls_key_packages
+      WHERE device_id = ANY($1) AND NOT last_resort GROUP BY device_id`, ids, LowKeyPackageThreshold, EventKeyPackagesLow)
+}
@@ -0,0 +1,225 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/kentapp/kent/server/internal/chats"
+	"github.com/kentapp/kent/server/internal/realtime"
+	"github.com/kentapp/kent/server/internal/users"
+)
+
+const (
+	EventPresence = "presence"
+	EventActivity = "chat.activity"
+
+	ActivityTyping         = "typing"
+	ActivityRecordingAudio = "recording_audio"
+	ActivityCancel         = "cancel"
+
+	lastSeenTTL = 30 * 24 * time.Hour
+)
+
+var ErrInvalidActivity = errors.New("presence: unknown activity")
+
+type Status struct {
+	UserID     uuid.UUID  `json:"userId"`
+	Online     bool       `json:"online"`
+	LastSeenAt *time.Time `json:"lastSeenAt,omitempty"`
+}
+
+// Service keeps online state and chat activity in Redis only. Postgres is
+// touched solely through devices.last_seen_at.
+type Service struct {
+	rdb       *redis.Client
+	users     *users.Repository
+	chats     *chats.Repository
+	hub       *realtime.Hub
+	ttl       time.Duration
+	typingTTL time.Duration
+}
+
+func NewService(rdb *redis.Client, userRepo *users.Repository, chatRepo *chats.Repository, hub *realtime.Hub, ttl, typingTTL time.Duration) *Service {
+	return &Service{
+		rdb:       rdb,
+		users:     userRepo,
+		chats:     chatRepo,
+		hub:       hub,
+		ttl:       ttl,
+		typingTTL: typingTTL,
+	}
+}
+
+// TTL is how long a device stays online without a heartbeat.
+func (s *Service) TTL() time.Duration {
+	return s.ttl
+}
+
+// Heartbeat marks the device online and notifies peers when the user
+// transitions from offline.
+func (s *Service) Heartbeat(ctx context.Context, userID, deviceID uuid.UUID) error {
+	now := time.Now()
+	key := onlineKey(userID)
+
+	wasOnline, err := s.isOnline(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.rdb.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Unix(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(s.ttl).Unix()), Member: deviceID.String()})
+	pipe.Expire(ctx, key, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	if err := s.users.UpdateDeviceLastSeen(ctx, deviceID); err != nil {
+		return err
+	}
+
+	if wasOnline {
+		return nil
+	}
+	return s.broadcast(ctx, Status{UserID: userID, Online: true})
+}
+
+// Disconnect drops the device and, if it was the last one, records
+// last-seen and notifies peers.
+func (s *Service) Disconnect(ctx context.Context, userID, deviceID uuid.UUID) error {
+	now := time.Now()
+	if err := s.rdb.ZRem(ctx, onlineKey(userID), deviceID.String()).Err(); err != nil {
+		return err
+	}
+	if err := s.users.UpdateDeviceLastSeen(ctx, deviceID); err != nil {
+		return err
+	}
+
+	online, err := s.isOnline(ctx, userID, now)
+	if err != nil || online {
+		return err
+	}
+
+	if err := s.rdb.Set(ctx, lastSeenKey(userID), now.Unix(), lastSeenTTL).Err(); err != nil {
+		return err
+	}
+	seen := now.UTC()
+	return s.broadcast(ctx, Status{UserID: userID, Online: false, LastSeenAt: &seen})
+}
+
+// Lookup returns presence for the requested users the viewer shares a chat with.
+func (s *Service) Lookup(ctx context.Context, viewerID uuid.UUID, userIDs []uuid.UUID) ([]Status, error) {
+	visible, err := s.chats.FilterPeers(ctx, viewerID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	out := make([]Status, 0, len(visible))
+	for _, id := range visible {
+		st := Status{UserID: id}
+		st.Online, err = s.isOnline(ctx, id, now)
+		if err != nil {
+			return nil, err
+		}
+		if !st.Online {
+			st.LastSeenAt, err = s.lastSeen(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// SetActivity publishes a short-lived typing or recording signal to the
+// other participants of the chat.
+func (s *Service) SetActivity(ctx context.Context, userID, chatID uuid.UUID, activity string) error {
+	switch activity {
+	case ActivityTyping, ActivityRecordingAudio, ActivityCancel:
+	default:
+		return ErrInvalidActivity
+	}
+
+	if _, err := s.chats.Authorize(ctx, chatID, userID, chats.ActionSend); err != nil {
+		return err
+	}
+
+	key := activityKey(chatID, userID)
+	var err error
+	if activity == ActivityCancel {
+		err = s.rdb.Del(ctx, key).Err()
+	} else {
+		err = s.rdb.Set(ctx, key, activity, s.typingTTL).Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	recipients, err := s.chats.RecipientIDs(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	return s.hub.PublishToUsers(ctx, without(recipients, userID), EventActivity, map[string]any{
+		"chatId":    chatID,
+		"userId":    userID,
+		"activity":  activity,
+		"expiresIn": int(s.typingTTL / time.Second),
+	})
+}
+
+func (s *Service) isOnline(ctx context.Context, userID uuid.UUID, now time.Time) (bool, error) {
+	n, err := s.rdb.ZCount(ctx, onlineKey(userID), strconv.FormatInt(now.Unix(), 10), "+inf").Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *Service) lastSeen(ctx context.Context, userID uuid.UUID) (*time.Time, error) {
+	raw, err := s.rdb.Get(ctx, lastSeenKey(userID)).Int64()
+	if err == nil {
+		t := time.Unix(raw, 0).UTC()
+		return &t, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	return s.users.LastSeen(ctx, userID)
+}
+
+func (s *Service) broadcast(ctx context.Context, st Status) error {
+	peers, err := s.chats.PeerIDs(ctx, st.UserID)
+	if err != nil {
+		return err
+	}
+	return s.hub.PublishToUsers(ctx, peers, EventPresence, st)
+}
+
+func without(ids []uuid.UUID, skip uuid.UUID) []uuid.UUID {
+	out := ids[:0]
+	for _, id := range ids {
+		if id != skip {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func onlineKey(userID uuid.UUID) string {
+	return "presence:online:" + userID.String()
+}
+
+func lastSeenKey(userID uuid.UUID) string {
+	return "presence:last:" + userID.String()
+}
+
+func activityKey(chatID, userID uuid.UUID) string {
+	return "activity:" + chatID.String() + ":" + userID.String()
+}
@@ -0,0 +1,87 @@
+package push
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Notification is a content-free wake-up; message bodies are end-to-end
+// encrypted and never leave the server in a push.
+type Notification struct {
+	Token     string
+	ChatID    uuid.UUID
+	MessageID uuid.UUID
+	// Mention is set when the recipient was mentioned or replied to.
+	Mention bool
+}
+
+type Sender interface {
+	Send(ctx context.Context, n Notification) error
+}
+
+// Noop drops notifications; it is used until a real push provider is
+// configured.
+type Noop struct{}
+
+func (Noop) Send(context.Context, Notification) error {
+	return nil
+}
+
+// LogSender logs one line per notification, for debugging push routing
+// locally. It is too noisy for production.
+type LogSender struct{}
+
+func (LogSender) Send(_ context.Context, n Notification) error {
+	log.Printf("push: chat=%s message=%s mention=%t", n.ChatID, n.MessageID, n.Mention)
+	return nil
+}
+
+type Dispatcher struct {
+	pool   *pgxpool.Pool
+	sender Sender
+}
+
+func NewDispatcher(pool *pgxpool.Pool, sender Sender) *Dispatcher {
+	return &Dispatcher{pool: pool, sender: sender}
+}
+
+// MessageCreated pushes to every device of every other participant whose
+// chat is not muted. Mentions and replies get through a mute when the
+// recipient's notification settings say so.
+func (d *Dispatcher) MessageCreated(ctx context.Context, chatID, messageID, senderID uuid.UUID) error {
+	rows, err := d.pool.Query(ctx, `SELECT d.push_token, mm.kind IS NOT NULL
+      FROM chat_participants p
+      JOIN devices d ON d.user_id = p.user_id
+      JOIN users u ON u.id = p.user_id
+      LEFT JOIN message_mentions mm ON mm.message_id = $3 AND mm.user_id = p.user_id
+      WHERE p.chat_id=$1 AND p.user_id<>$2 AND d.push_token IS NOT NULL
+        AND (p.muted_until IS NULL OR p.muted_until <= now()
+          OR (mm.kind = 'mention' AND u.mentions_override_mute)
+          OR (mm.kind = 'reply' AND u.replies_override_mute))`, chatID, senderID, messageID)
+	if err != nil {
+		return err
+	}
+	var batch []Notification
+	for rows.Next() {
+		n := Notification{ChatID: chatID, MessageID: messageID}
+		if err := rows.Scan(&n.Token, &n.Mention); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, n := range batch {
+		if err := d.sender.Send(ctx, n); err != nil {
+			log.Printf("push send failed: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Limit allows N hits per Window.
+type Limit struct {
+	N      int
+	Window time.Duration
+}
+
+// Limiter counts hits in fixed windows shared by every replica through
+// Redis.
+type Limiter struct {
+	rdb *redis.Client
+}
+
+func New(rdb *redis.Client) *Limiter {
+	return &Limiter{rdb: rdb}
+}
+
+// Allow counts one hit against key and reports whether it is within the
+// limit; when it is not, retryAfter is how long until the window resets.
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error) {
+	now := time.Now().UnixNano()
+	window := int64(limit.Window)
+	k := "ratelimit:" + key + ":" + strconv.FormatInt(now/window, 10)
+
+	pipe := l.rdb.TxPipeline()
+	incr := pipe.Incr(ctx, k)
+	pipe.Expire(ctx, k, limit.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, 0, err
+	}
+	if incr.Val() <= int64(limit.N) {
+		return true, 0, nil
+	}
+	return false, time.Duration(window - now%window), nil
+}
@@ -0,0 +1,74 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Event is the envelope written to every real-time subscriber.
+type Event struct {
+	Type string    `json:"type"`
+	Data any       `json:"data,omitempty"`
+	At   time.Time `json:"at"`
+}
+
+// Hub fans events out over Redis pub/sub so that every API replica can
+// deliver them to the connections it holds.
+type Hub struct {
+	rdb *redis.Client
+}
+
+func NewHub(rdb *redis.Client) *Hub {
+	return &Hub{rdb: rdb}
+}
+
+func (h *Hub) PublishToUsers(ctx context.Context, userIDs []uuid.UUID, eventType string, data any) error {
+	channels := make([]string, 0, len(userIDs))
+	for _, id := range userIDs {
+		channels = append(channels, userChannel(id))
+	}
+	return h.publish(ctx, channels, eventType, data)
+}
+
+func (h *Hub) PublishToDevices(ctx context.Context, deviceIDs []uuid.UUID, eventType string, data any) error {
+	channels := make([]string, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		channels = append(channels, deviceChannel(id))
+	}
+	return h.publish(ctx, channels, eventType, data)
+}
+
+// Subscribe listens to both the user-wide and the device-specific channel.
+// The caller owns the returned PubSub and must close it.
+func (h *Hub) Subscribe(ctx context.Context, userID, deviceID uuid.UUID) *redis.PubSub {
+	return h.rdb.Subscribe(ctx, userChannel(userID), deviceChannel(deviceID))
+}
+
+func (h *Hub) publish(ctx context.Context, channels []string, eventType string, data any) error {
+	if len(channels) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(Event{Type: eventType, Data: data, At: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	pipe := h.rdb.Pipeline()
+	for _, ch := range channels {
+		pipe.Publish(ctx, ch, payload)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func userChannel(id uuid.UUID) string {
+	return "rt:user:" + id.String()
+}
+
+func deviceChannel(id uuid.UUID) string {
+	return "rt:device:" + id.String()
+}
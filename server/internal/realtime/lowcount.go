@@ -0,0 +1,46 @@
+package realtime
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SignalLow tells devices that are running out of keys they upload, such as
+// one-time prekeys or MLS key packages, how many they have left. countQuery
+// takes the device IDs as $1 and returns (device_id, count) rows; a device
+// without a row has none left. Devices under threshold get eventType with
+// { count }.
+func (h *Hub) SignalLow(ctx context.Context, pool *pgxpool.Pool, countQuery string, deviceIDs []uuid.UUID, threshold int, eventType string) {
+	counts := make(map[uuid.UUID]int, len(deviceIDs))
+	rows, err := pool.Query(ctx, countQuery, deviceIDs)
+	if err != nil {
+		log.Printf("%s count failed: %v", eventType, err)
+		return
+	}
+	for rows.Next() {
+		var id uuid.UUID
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			rows.Close()
+			log.Printf("%s count failed: %v", eventType, err)
+			return
+		}
+		counts[id] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("%s count failed: %v", eventType, err)
+		return
+	}
+
+	for _, id := range deviceIDs {
+		if n := counts[id]; n < threshold {
+			if err := h.PublishToDevices(ctx, []uuid.UUID{id}, eventType, map[string]any{"count": n}); err != nil {
+				log.Printf("publish %s failed: %v", eventType, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const clamChunkSize = 64 << 10
+
+var ErrScannerFailed = errors.New("scan: scanner failed")
+
+// ClamAV streams files to clamd over TCP with the INSTREAM command. Files
+// larger than clamd's StreamMaxLength fail to scan rather than pass.
+type ClamAV struct {
+	addr    string
+	timeout time.Duration
+}
+
+func NewClamAV(addr string, timeout time.Duration) *ClamAV {
+	return &ClamAV{addr: addr, timeout: timeout}
+}
+
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	dialer := net.Dialer{Timeout: c.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return Verdict{}, err
+	}
+
+	if err := stream(conn, r); err != nil {
+		// clamd drops the connection once a stream exceeds its limit, and
+		// its reply says so.
+		var re readError
+		if !errors.As(err, &re) {
+			if reply, rerr := readReply(conn); rerr == nil {
+				return parseReply(reply)
+			}
+		}
+		return Verdict{}, err
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return Verdict{}, err
+	}
+	return parseReply(reply)
+}
+
+// stream sends r in chunks, each prefixed by its length; a zero length ends
+// the stream.
+func stream(conn net.Conn, r io.Reader) error {
+	w := bufio.NewWriterSize(conn, clamChunkSize+4)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return err
+	}
+	buf := make([]byte, clamChunkSize)
+	var size [4]byte
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			w.Write(size[:])
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return readError{err}
+		}
+	}
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	return w.Flush()
+}
+
+// readError is a failure reading the file rather than talking to clamd.
+type readError struct{ error }
+
+func (e readError) Unwrap() error { return e.error }
+
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && !(errors.Is(err, io.EOF) && reply != "") {
+		return "", err
+	}
+	return strings.TrimRight(reply, "\x00\n"), nil
+}
+
+// parseReply reads "stream: OK", "stream: <signature> FOUND" or
+// "<message> ERROR".
+func parseReply(reply string) (Verdict, error) {
+	_, result, _ := strings.Cut(reply, ": ")
+	switch {
+	case result == "OK":
+		return Verdict{Clean: true}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return Verdict{Reason: strings.TrimSuffix(result, " FOUND")}, nil
+	}
+	return Verdict{}, fmt.Errorf("%w: %s", ErrScannerFailed, reply)
+}
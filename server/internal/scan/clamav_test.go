@@ -0,0 +1,220 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestParseReply(t *testing.T) {
+	for _, tc := range []struct {
+		reply   string
+		want    Verdict
+		failure bool
+	}{
+		{"stream: OK", Verdict{Clean: true}, false},
+		{"stream: Eicar-Test-Signature FOUND", Verdict{Reason: "Eicar-Test-Signature"}, false},
+		{"stream: Win.Test.EICAR_HDB-1 FOUND", Verdict{Reason: "Win.Test.EICAR_HDB-1"}, false},
+		{"INSTREAM size limit exceeded. ERROR", Verdict{}, true},
+		{"stream: Can't allocate memory ERROR", Verdict{}, true},
+		{"UNKNOWN COMMAND", Verdict{}, true},
+		{"stream: OKAY", Verdict{}, true},
+		{"stream: FOUND", Verdict{}, true},
+		{"", Verdict{}, true},
+	} {
+		got, err := parseReply(tc.reply)
+		if tc.failure {
+			if !errors.Is(err, ErrScannerFailed) {
+				t.Errorf("parseReply(%q): err = %v, want ErrScannerFailed", tc.reply, err)
+			}
+			if got.Clean {
+				t.Errorf("parseReply(%q) reported clean on failure", tc.reply)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("parseReply(%q) = %+v, %v, want %+v", tc.reply, got, err, tc.want)
+		}
+	}
+}
+
+// readStream decodes an INSTREAM request, returning the chunk sizes and the
+// reassembled data.
+func readStream(r io.Reader) ([]int, []byte, error) {
+	br := bufio.NewReader(r)
+	cmd, err := br.ReadString(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cmd != "zINSTREAM\x00" {
+		return nil, nil, errors.New("unexpected command " + cmd)
+	}
+	var sizes []int
+	var data bytes.Buffer
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(br, size[:]); err != nil {
+			return nil, nil, err
+		}
+		n := int(binary.BigEndian.Uint32(size[:]))
+		sizes = append(sizes, n)
+		if n == 0 {
+			return sizes, data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, br, int64(n)); err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+func TestStreamFraming(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		size  int
+		sizes []int
+	}{
+		{"empty", 0, []int{0}},
+		{"small", 10, []int{10, 0}},
+		{"one chunk", clamChunkSize, []int{clamChunkSize, 0}},
+		{"chunks and a tail", 2*clamChunkSize + 7, []int{clamChunkSize, clamChunkSize, 7, 0}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte("kent"), tc.size/4+1)[:tc.size]
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			errc := make(chan error, 1)
+			// Short reads must not produce short chunks.
+			go func() { errc <- stream(client, iotest.HalfReader(bytes.NewReader(data))) }()
+
+			sizes, got, err := readStream(server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errc; err != nil {
+				t.Fatalf("stream: %v", err)
+			}
+			if !slices.Equal(sizes, tc.sizes) {
+				t.Errorf("chunk sizes = %v, want %v", sizes, tc.sizes)
+			}
+			if !bytes.Equal(got, data) {
+				t.Error("reassembled data differs")
+			}
+		})
+	}
+}
+
+func TestStreamReadError(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, server)
+
+	boom := errors.New("disk on fire")
+	err := stream(client, io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(boom)))
+	var re readError
+	if !errors.As(err, &re) || !errors.Is(err, boom) {
+		t.Fatalf("err = %v, want a readError wrapping the reader's error", err)
+	}
+}
+
+// fakeClamd answers each INSTREAM on a local port. Streams over limit bytes
+// get clamd's size-limit error.
+func fakeClamd(t *testing.T, limit int, reply func(data []byte) string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, data, err := readStream(conn)
+				if err != nil {
+					return
+				}
+				if reply == nil {
+					// Never answer, as a hung clamd would.
+					time.Sleep(time.Second)
+					return
+				}
+				msg := reply(data)
+				if len(data) > limit {
+					msg = "INSTREAM size limit exceeded. ERROR"
+				}
+				conn.Write([]byte(msg + "\x00"))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamAVScan(t *testing.T) {
+	addr := fakeClamd(t, 1<<20, func(data []byte) string {
+		if bytes.Contains(data, []byte("EICAR")) {
+			return "stream: Eicar-Test-Signature FOUND"
+		}
+		return "stream: OK"
+	})
+	c := NewClamAV(addr, 5*time.Second)
+	ctx := context.Background()
+
+	v, err := c.Scan(ctx, bytes.NewReader(bytes.Repeat([]byte("clean "), 30000)))
+	if err != nil || !v.Clean {
+		t.Errorf("clean file: %+v, %v", v, err)
+	}
+
+	v, err = c.Scan(ctx, strings.NewReader(strings.Repeat("x", clamChunkSize)+"EICAR"))
+	if err != nil || v.Clean || v.Reason != "Eicar-Test-Signature" {
+		t.Errorf("infected file: %+v, %v", v, err)
+	}
+
+	v, err = c.Scan(ctx, bytes.NewReader(make([]byte, 1<<20+1)))
+	if !errors.Is(err, ErrScannerFailed) || v.Clean {
+		t.Errorf("file over the stream limit: %+v, %v, want ErrScannerFailed", v, err)
+	}
+
+	boom := errors.New("disk on fire")
+	v, err = c.Scan(ctx, iotest.ErrReader(boom))
+	if !errors.Is(err, boom) || v.Clean {
+		t.Errorf("unreadable file: %+v, %v", v, err)
+	}
+}
+
+func TestClamAVTimeout(t *testing.T) {
+	c := NewClamAV(fakeClamd(t, 1<<20, nil), 100*time.Millisecond)
+	v, err := c.Scan(context.Background(), strings.NewReader("data"))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() || v.Clean {
+		t.Errorf("hung clamd: %+v, %v, want a timeout", v, err)
+	}
+}
+
+func TestClamAVUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	v, err := NewClamAV(addr, time.Second).Scan(context.Background(), strings.NewReader("data"))
+	if err == nil || v.Clean {
+		t.Errorf("unreachable clamd: %+v, %v, want an error", v, err)
+	}
+}
@@ -0,0 +1,107 @@
+package scan
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kentapp/kent/server/internal/blob"
+)
+
+// Status is where a scanned upload stands. Only clean and released files
+// are served.
+type Status string
+
+const (
+	StatusPending     Status = "pending"
+	StatusClean       Status = "clean"
+	StatusQuarantined Status = "quarantined"
+	StatusReleased    Status = "released"
+)
+
+const (
+	// MaxAttempts is how many times a file is tried before it is
+	// quarantined as unscannable.
+	MaxAttempts = 5
+	// ClaimTTL is how long a worker holds a claimed file; a failed scan is
+	// retried once it lapses.
+	ClaimTTL  = 5 * time.Minute
+	batchSize = 20
+)
+
+// Servable reports whether files with the status may be served.
+func (s Status) Servable() bool {
+	return s == StatusClean || s == StatusReleased
+}
+
+// Item is a stored file awaiting a scan. Attempts counts this one.
+type Item struct {
+	ID       string
+	Key      string
+	Attempts int
+}
+
+// Queue is a table of pending files. Claim hands out up to limit files no
+// other worker holds; Resolve records the outcome of one.
+type Queue interface {
+	Claim(ctx context.Context, limit int) ([]Item, error)
+	Resolve(ctx context.Context, item Item, status Status, reason string) error
+}
+
+// Run scans the queue's files every interval, or sooner when wake fires,
+// until ctx is cancelled. Several replicas may run it concurrently.
+func Run(ctx context.Context, q Queue, store blob.Store, scanner Scanner, interval time.Duration, wake <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			items, err := q.Claim(ctx, batchSize)
+			if err != nil {
+				log.Printf("scan claim failed: %v", err)
+				break
+			}
+			for _, item := range items {
+				scanItem(ctx, q, store, scanner, item)
+			}
+			if len(items) < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-wake:
+		}
+	}
+}
+
+func scanItem(ctx context.Context, q Queue, store blob.Store, scanner Scanner, item Item) {
+	v, err := scanBlob(ctx, store, scanner, item.Key)
+	status, reason := StatusClean, v.Reason
+	switch {
+	case err != nil && item.Attempts < MaxAttempts:
+		log.Printf("scan %s failed (attempt %d): %v", item.ID, item.Attempts, err)
+		return
+	case err != nil:
+		status, reason = StatusQuarantined, "scan failed: "+err.Error()
+	case !v.Clean:
+		status = StatusQuarantined
+	}
+	if err := q.Resolve(ctx, item, status, reason); err != nil {
+		log.Printf("scan %s resolve failed: %v", item.ID, err)
+	}
+}
+
+func scanBlob(ctx context.Context, store blob.Store, scanner Scanner, key string) (Verdict, error) {
+	ctx, cancel := context.WithTimeout(ctx, ClaimTTL)
+	defer cancel()
+	rc, err := store.Get(ctx, key, 0, -1)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer rc.Close()
+	return scanner.Scan(ctx, rc)
+}
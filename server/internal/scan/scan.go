@@ -0,0 +1,28 @@
+// Package scan checks uploads the server can read for malware or other
+// unwanted content before they are served.
+package scan
+
+import (
+	"context"
+	"io"
+)
+
+// Verdict is a scanner's finding about one file.
+type Verdict struct {
+	Clean bool
+	// Reason names what was found, such as a malware signature.
+	Reason string
+}
+
+// Scanner inspects a file. An error means no verdict was reached and the
+// scan should be retried.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Verdict, error)
+}
+
+// Noop passes everything, for deployments without a scanner.
+type Noop struct{}
+
+func (Noop) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	return Verdict{Clean: true}, nil
+}
@@ -0,0 +1,259 @@
+package transparency
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	leafVersion = 0
+	// headContext prefixes signed tree heads so the signing key cannot be
+	// used to sign anything else.
+	headContext = "kent-kt-v1"
+	// appendLockKey serializes appends so that log indexes stay dense.
+	appendLockKey = 0x6b74
+
+	MaxAuditPage = 500
+)
+
+var (
+	ErrNotFound    = errors.New("transparency: no such entry")
+	ErrInvalidSize = errors.New("transparency: tree size out of range")
+)
+
+// Entry is one logged binding of a device identity key to a user.
+type Entry struct {
+	Index       int64     `json:"index"`
+	UserID      uuid.UUID `json:"userId"`
+	DeviceID    uuid.UUID `json:"deviceId"`
+	IdentityKey []byte    `json:"identityKey"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// TreeHead is a signed tree head. Signature is Ed25519 over
+// "kent-kt-v1" || 0x00 || uint64 treeSize || uint64 timestamp || rootHash,
+// big-endian, with the timestamp in Unix milliseconds.
+type TreeHead struct {
+	TreeSize  int64  `json:"treeSize"`
+	RootHash  []byte `json:"rootHash"`
+	Timestamp int64  `json:"timestamp"`
+	Signature []byte `json:"signature"`
+}
+
+// Proven is an entry with its inclusion proof in a tree of the given size.
+type Proven struct {
+	Entry
+	TreeSize int64    `json:"treeSize"`
+	Proof    [][]byte `json:"proof"`
+}
+
+// Service serves the key transparency log. Leaf hashes are mirrored in
+// memory and caught up from the database on each request, so several API
+// instances serve the same tree.
+type Service struct {
+	pool *pgxpool.Pool
+	key  ed25519.PrivateKey
+
+	mu   sync.Mutex
+	tree *tree
+}
+
+func NewService(pool *pgxpool.Pool, key ed25519.PrivateKey) *Service {
+	return &Service{pool: pool, key: key, tree: newTree()}
+}
+
+func (s *Service) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+// Append logs a new binding within tx, which must also be the transaction
+// that publishes the key, so that no key is served without being logged.
+func Append(ctx context.Context, tx pgx.Tx, userID, deviceID uuid.UUID, identityKey []byte) error {
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1)`, appendLockKey); err != nil {
+		return err
+	}
+	var idx int64
+	if err := tx.QueryRow(ctx, `SELECT COALESCE(max(idx) + 1, 0) FROM kt_entries`).Scan(&idx); err != nil {
+		return err
+	}
+	at := time.Now().UTC().Truncate(time.Millisecond)
+	_, err := tx.Exec(ctx, `INSERT INTO kt_entries (idx, user_id, device_id, identity_key, created_at, leaf_hash)
+      VALUES ($1, $2, $3, $4, $5, $6)`,
+		idx, userID, deviceID, identityKey, at, leafHash(leafData(userID, deviceID, at, identityKey)))
+	return err
+}
+
+// Head signs the current tree head.
+func (s *Service) Head(ctx context.Context) (*TreeHead, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	return s.head(), nil
+}
+
+// Inclusion proves the latest binding of the user's device logged within
+// the tree of treeSize leaves, or the current tree when treeSize is zero.
+// Entries are only served by lookup key, so the log cannot be walked by
+// index to enumerate accounts.
+func (s *Service) Inclusion(ctx context.Context, userID, deviceID uuid.UUID, treeSize int64) (*Proven, *TreeHead, error) {
+	entries, err := s.entries(ctx, `idx = (
+        SELECT max(idx) FROM kt_entries
+        WHERE user_id = $1 AND device_id = $2 AND ($3 = 0 OR idx < $3))`, userID, deviceID, treeSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil, ErrNotFound
+	}
+	proven, head, err := s.prove(ctx, entries, treeSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &proven[0], head, nil
+}
+
+// Consistency proves the tree of from leaves is a prefix of the tree of to
+// leaves, or of the current tree when to is zero.
+func (s *Service) Consistency(ctx context.Context, from, to int64) ([][]byte, *TreeHead, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sync(ctx); err != nil {
+		return nil, nil, err
+	}
+	n := int64(s.tree.size())
+	if to == 0 {
+		to = n
+	}
+	if from < 0 || from > to || to > n {
+		return nil, nil, ErrInvalidSize
+	}
+	return s.tree.consistency(int(from), int(to)), s.head(), nil
+}
+
+// Lookup returns the latest logged binding of each of the user's current
+// devices, proven against the current tree head.
+func (s *Service) Lookup(ctx context.Context, userID uuid.UUID) ([]Proven, *TreeHead, error) {
+	entries, err := s.entries(ctx, `idx IN (
+        SELECT DISTINCT ON (e.device_id) e.idx FROM kt_entries e
+        JOIN device_identity_keys ik ON ik.device_id = e.device_id
+        WHERE e.user_id = $1
+        ORDER BY e.device_id, e.idx DESC)`, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.prove(ctx, entries, 0)
+}
+
+// Audit returns the bindings logged for the user after the given index,
+// MaxAuditPage at a time, so their own clients can spot keys they never
+// published.
+func (s *Service) Audit(ctx context.Context, userID uuid.UUID, after int64) ([]Proven, *TreeHead, error) {
+	entries, err := s.entries(ctx, `user_id = $1 AND idx > $2`, userID, after)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.prove(ctx, entries, 0)
+}
+
+func (s *Service) prove(ctx context.Context, entries []Entry, treeSize int64) ([]Proven, *TreeHead, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.sync(ctx); err != nil {
+		return nil, nil, err
+	}
+	n := int64(s.tree.size())
+	if treeSize == 0 {
+		treeSize = n
+	}
+	if treeSize < 0 || treeSize > n {
+		return nil, nil, ErrInvalidSize
+	}
+
+	out := make([]Proven, 0, len(entries))
+	for _, e := range entries {
+		if e.Index >= treeSize {
+			return nil, nil, ErrInvalidSize
+		}
+		out = append(out, Proven{Entry: e, TreeSize: treeSize, Proof: s.tree.inclusion(int(e.Index), int(treeSize))})
+	}
+	return out, s.head(), nil
+}
+
+func (s *Service) entries(ctx context.Context, cond string, args ...any) ([]Entry, error) {
+	rows, err := s.pool.Query(ctx, `SELECT idx, user_id, device_id, identity_key, created_at FROM kt_entries
+      WHERE `+cond+`
+      ORDER BY idx
+      LIMIT `+strconv.Itoa(MaxAuditPage), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.Index, &e.UserID, &e.DeviceID, &e.IdentityKey, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// sync appends leaves logged since the last call. The caller holds s.mu.
+func (s *Service) sync(ctx context.Context) error {
+	rows, err := s.pool.Query(ctx, `SELECT idx, leaf_hash FROM kt_entries WHERE idx >= $1 ORDER BY idx`, s.tree.size())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var idx int64
+		var h []byte
+		if err := rows.Scan(&idx, &h); err != nil {
+			return err
+		}
+		if idx != int64(s.tree.size()) {
+			break
+		}
+		s.tree.leaves = append(s.tree.leaves, h)
+	}
+	return rows.Err()
+}
+
+// head signs the tree as mirrored now. The caller holds s.mu.
+func (s *Service) head() *TreeHead {
+	n := s.tree.size()
+	h := &TreeHead{TreeSize: int64(n), RootHash: s.tree.root(n), Timestamp: time.Now().UnixMilli()}
+	msg := make([]byte, 0, len(headContext)+1+16+len(h.RootHash))
+	msg = append(msg, headContext...)
+	msg = append(msg, 0)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(h.TreeSize))
+	msg = binary.BigEndian.AppendUint64(msg, uint64(h.Timestamp))
+	msg = append(msg, h.RootHash...)
+	h.Signature = ed25519.Sign(s.key, msg)
+	return h
+}
+
+// leafData encodes a binding as version || userId || deviceId ||
+// uint64 createdAt (Unix ms, big-endian) || identityKey.
+func leafData(userID, deviceID uuid.UUID, at time.Time, identityKey []byte) []byte {
+	b := make([]byte, 0, 1+16+16+8+len(identityKey))
+	b = append(b, leafVersion)
+	b = append(b, userID[:]...)
+	b = append(b, deviceID[:]...)
+	b = binary.BigEndian.AppendUint64(b, uint64(at.UnixMilli()))
+	return append(b, identityKey...)
+}
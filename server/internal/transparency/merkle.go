@@ -0,0 +1,119 @@
+package transparency
+
+import (
+	"crypto/sha256"
+	"math/bits"
+)
+
+// The tree follows RFC 6962: leaves are hashed with a 0x00 prefix and
+// interior nodes with 0x01, and proofs use the algorithms of section 2.1.
+
+func leafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+var emptyRoot = sha256.New().Sum(nil)
+
+type span struct{ lo, size int }
+
+// tree holds leaf hashes in log order. Hashes of complete, aligned subtrees
+// never change once the subtree is full, so they are cached and a root or
+// proof costs O(log² n) hashes after the first computation.
+type tree struct {
+	leaves [][]byte
+	nodes  map[span][]byte
+}
+
+func newTree() *tree {
+	return &tree{nodes: make(map[span][]byte)}
+}
+
+func (t *tree) size() int {
+	return len(t.leaves)
+}
+
+// root returns the Merkle tree hash of the first n leaves.
+func (t *tree) root(n int) []byte {
+	if n == 0 {
+		return emptyRoot
+	}
+	return t.hash(0, n)
+}
+
+// hash returns the Merkle tree hash of leaves[lo:hi].
+func (t *tree) hash(lo, hi int) []byte {
+	n := hi - lo
+	if n == 1 {
+		return t.leaves[lo]
+	}
+	perfect := n&(n-1) == 0
+	if perfect {
+		if h, ok := t.nodes[span{lo, n}]; ok {
+			return h
+		}
+	}
+	k := splitPoint(n)
+	h := nodeHash(t.hash(lo, lo+k), t.hash(lo+k, hi))
+	if perfect {
+		t.nodes[span{lo, n}] = h
+	}
+	return h
+}
+
+// inclusion returns the audit path of leaf m in the tree of the first n
+// leaves.
+func (t *tree) inclusion(m, n int) [][]byte {
+	return t.path(m, 0, n)
+}
+
+func (t *tree) path(m, lo, hi int) [][]byte {
+	n := hi - lo
+	if n == 1 {
+		return nil
+	}
+	k := splitPoint(n)
+	if m < k {
+		return append(t.path(m, lo, lo+k), t.hash(lo+k, hi))
+	}
+	return append(t.path(m-k, lo+k, hi), t.hash(lo, lo+k))
+}
+
+// consistency proves that the tree of the first m leaves is a prefix of the
+// tree of the first n leaves.
+func (t *tree) consistency(m, n int) [][]byte {
+	if m == 0 || m == n {
+		return nil
+	}
+	return t.subproof(m, 0, n, true)
+}
+
+func (t *tree) subproof(m, lo, hi int, whole bool) [][]byte {
+	n := hi - lo
+	if m == n {
+		if whole {
+			return nil
+		}
+		return [][]byte{t.hash(lo, hi)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(t.subproof(m, lo, lo+k, whole), t.hash(lo+k, hi))
+	}
+	return append(t.subproof(m-k, lo+k, hi, false), t.hash(lo, lo+k))
+}
+
+// splitPoint is the largest power of two smaller than n, for n > 1.
+func splitPoint(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
@@ -0,0 +1,195 @@
+package transparency
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// refRoot is the RFC 6962 Merkle tree hash computed directly from the
+// definition, without the subtree cache.
+func refRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		return emptyRoot
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(len(leaves))
+	return nodeHash(refRoot(leaves[:k]), refRoot(leaves[k:]))
+}
+
+// verifyInclusion checks an audit path as a client would, following
+// RFC 9162 section 2.1.3.2.
+func verifyInclusion(index, size int, leaf []byte, proof [][]byte, root []byte) bool {
+	if index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
+
+// verifyConsistency checks a consistency proof as a client would, following
+// RFC 9162 section 2.1.4.2.
+func verifyConsistency(m, n int, proof [][]byte, oldRoot, newRoot []byte) bool {
+	if m == n {
+		return len(proof) == 0 && bytes.Equal(oldRoot, newRoot)
+	}
+	if m == 0 || m > n || len(proof) == 0 {
+		return false
+	}
+	if m&(m-1) == 0 {
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+	fn, sn := m-1, n-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, oldRoot) && bytes.Equal(sr, newRoot)
+}
+
+func testTree(n int) *tree {
+	t := newTree()
+	for i := 0; i < n; i++ {
+		t.leaves = append(t.leaves, leafHash([]byte(fmt.Sprintf("leaf %d", i))))
+	}
+	return t
+}
+
+func TestEmptyRoot(t *testing.T) {
+	want := "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if got := hex.EncodeToString(newTree().root(0)); got != want {
+		t.Fatalf("empty root = %s, want %s", got, want)
+	}
+}
+
+func TestRootMatchesDefinition(t *testing.T) {
+	tr := testTree(70)
+	for n := 1; n <= 70; n++ {
+		if !bytes.Equal(tr.root(n), refRoot(tr.leaves[:n])) {
+			t.Fatalf("root(%d) differs from the RFC 6962 definition", n)
+		}
+	}
+	// Cached subtrees must not change the roots of smaller trees.
+	for n := 70; n >= 1; n-- {
+		if !bytes.Equal(tr.root(n), refRoot(tr.leaves[:n])) {
+			t.Fatalf("root(%d) differs after caching", n)
+		}
+	}
+}
+
+func TestInclusion(t *testing.T) {
+	tr := testTree(40)
+	for n := 1; n <= 40; n++ {
+		root := tr.root(n)
+		for m := 0; m < n; m++ {
+			proof := tr.inclusion(m, n)
+			if !verifyInclusion(m, n, tr.leaves[m], proof, root) {
+				t.Fatalf("inclusion(%d, %d) does not verify", m, n)
+			}
+		}
+	}
+}
+
+func TestInclusionRejectsTampering(t *testing.T) {
+	tr := testTree(13)
+	root := tr.root(13)
+	proof := tr.inclusion(5, 13)
+
+	if verifyInclusion(6, 13, tr.leaves[5], proof, root) {
+		t.Error("proof verified at the wrong index")
+	}
+	if verifyInclusion(5, 13, tr.leaves[6], proof, root) {
+		t.Error("proof verified for the wrong leaf")
+	}
+	if verifyInclusion(5, 12, tr.leaves[5], proof, tr.root(12)) {
+		t.Error("proof verified against the wrong tree size")
+	}
+	if verifyInclusion(5, 13, tr.leaves[5], proof[:len(proof)-1], root) {
+		t.Error("truncated proof verified")
+	}
+	bad := append([][]byte(nil), proof...)
+	bad[0] = leafHash([]byte("forged"))
+	if verifyInclusion(5, 13, tr.leaves[5], bad, root) {
+		t.Error("altered proof verified")
+	}
+}
+
+func TestConsistency(t *testing.T) {
+	tr := testTree(40)
+	for n := 1; n <= 40; n++ {
+		for m := 1; m <= n; m++ {
+			proof := tr.consistency(m, n)
+			if !verifyConsistency(m, n, proof, tr.root(m), tr.root(n)) {
+				t.Fatalf("consistency(%d, %d) does not verify", m, n)
+			}
+		}
+	}
+	if p := tr.consistency(0, 10); p != nil {
+		t.Errorf("consistency from the empty tree = %d hashes, want none", len(p))
+	}
+}
+
+func TestConsistencyRejectsForks(t *testing.T) {
+	tr := testTree(20)
+	fork := testTree(20)
+	fork.leaves[3] = leafHash([]byte("rewritten"))
+
+	proof := tr.consistency(7, 20)
+	if verifyConsistency(7, 20, proof, fork.root(7), tr.root(20)) {
+		t.Error("proof verified for a rewritten old tree")
+	}
+	if verifyConsistency(7, 20, proof, tr.root(7), fork.root(20)) {
+		t.Error("proof verified for a rewritten new tree")
+	}
+	if verifyConsistency(7, 20, fork.consistency(7, 20), tr.root(7), tr.root(20)) {
+		t.Error("proof from a fork verified")
+	}
+}
+
+func TestSplitPoint(t *testing.T) {
+	for _, tc := range []struct{ n, want int }{
+		{2, 1}, {3, 2}, {4, 2}, {5, 4}, {8, 4}, {9, 8}, {1024, 512}, {1025, 1024},
+	} {
+		if got := splitPoint(tc.n); got != tc.want {
+			t.Errorf("splitPoint(%d) = %d, want %d", tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// EventDevicesChanged tells a user's contacts that the devices or
+	// identity keys they encrypt to have changed, and with them the safety
+	// number.
+	EventDevicesChanged = "devices.changed"
+	// EventDeviceAdded asks the user's own devices to approve a new login.
+	EventDeviceAdded = "devices.added"
+	// EventDeviceListReset tells a user's own devices that the primary's
+	// identity key changed, so every device waits for it to sign a new list.
+	EventDeviceListReset = "devices.reset"
+
+	// deviceListContext prefixes signed device lists so that identity keys
+	// signing them cannot be tricked into signing anything else.
+	deviceListContext = "kent-devices-v1"
+)
+
+var (
+	ErrNotPrimary        = errors.New("users: only the primary device may sign the device list")
+	ErrStaleDeviceList   = errors.New("users: device list version must increase")
+	ErrInvalidDeviceList = errors.New("users: device list must include the primary and only devices with identity keys")
+	ErrBadListSignature  = errors.New("users: device list signature does not verify")
+	ErrNoDeviceList      = errors.New("users: account has no signed device list")
+)
+
+// DeviceInfo is what the account owner sees about one of their devices.
+type DeviceInfo struct {
+	ID         uuid.UUID `json:"id"`
+	Label      *string   `json:"label,omitempty"`
+	Verified   bool      `json:"verified"`
+	Primary    bool      `json:"primary"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+type ListedDevice struct {
+	DeviceID    uuid.UUID `json:"deviceId"`
+	IdentityKey []byte    `json:"identityKey"`
+}
+
+// DeviceList is an account's signed device list. Signature is Ed25519, by the
+// primary device's identity key, over
+// "kent-devices-v1" || 0x00 || userId || uint64 version (big-endian) ||
+// deviceId || identityKey for each device in ascending deviceId order.
+type DeviceList struct {
+	UserID    uuid.UUID      `json:"userId"`
+	Version   int64          `json:"version"`
+	Devices   []ListedDevice `json:"devices"`
+	SignedBy  uuid.UUID      `json:"signedBy"`
+	Signature []byte         `json:"signature"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// Devices lists the user's devices, pending ones included.
+func (r *Repository) Devices(ctx context.Context, userID uuid.UUID) ([]DeviceInfo, error) {
+	rows, err := r.pool.Query(ctx, `SELECT id, label, verified, is_primary, created_at, last_seen_at FROM devices
+      WHERE user_id=$1
+      ORDER BY created_at, id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []DeviceInfo
+	for rows.Next() {
+		var d DeviceInfo
+		if err := rows.Scan(&d.ID, &d.Label, &d.Verified, &d.Primary, &d.CreatedAt, &d.LastSeenAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// SignedDeviceList returns the user's latest signed device list with the
+// identity keys exactly as they were signed, not as currently published.
+func (r *Repository) SignedDeviceList(ctx context.Context, userID uuid.UUID) (*DeviceList, error) {
+	l := DeviceList{UserID: userID}
+	var ids []uuid.UUID
+	var keys [][]byte
+	err := r.pool.QueryRow(ctx, `SELECT version, device_ids, identity_keys, signed_by, signature, updated_at FROM device_lists WHERE user_id=$1`, userID).
+		Scan(&l.Version, &ids, &keys, &l.SignedBy, &l.Signature, &l.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNoDeviceList
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != len(ids) {
+		return nil, fmt.Errorf("users: device list of %s has %d devices but %d keys", userID, len(ids), len(keys))
+	}
+
+	l.Devices = make([]ListedDevice, len(ids))
+	for i, id := range ids {
+		l.Devices[i] = ListedDevice{DeviceID: id, IdentityKey: keys[i]}
+	}
+	return &l, nil
+}
+
+// SignDeviceList stores a device list signed by the primary device. Listed
+// devices become verified and all others pending; verified devices with
+// identity keys that are left off the list are revoked and signed out. It
+// returns the revoked device IDs.
+func (r *Repository) SignDeviceList(ctx context.Context, userID, deviceID uuid.UUID, version int64, deviceIDs []uuid.UUID, signature []byte) (revoked []uuid.UUID, err error) {
+	if len(signature) != ed25519.SignatureSize {
+		return nil, ErrBadListSignature
+	}
+
+	tx, err := r.pool.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback(ctx)
+		} else {
+			err = tx.Commit(ctx)
+		}
+	}()
+
+	// Locking the devices serializes signing with logins adding devices.
+	rows, err := tx.Query(ctx, `SELECT d.id, d.verified, d.is_primary, ik.identity_key FROM devices d
+      LEFT JOIN device_identity_keys ik ON ik.device_id = d.id
+      WHERE d.user_id=$1
+      FOR UPDATE OF d`, userID)
+	if err != nil {
+		return nil, err
+	}
+	type current struct {
+		verified bool
+		key      []byte
+	}
+	devices := make(map[uuid.UUID]current)
+	var primaryKey []byte
+	isPrimary := false
+	for rows.Next() {
+		var id uuid.UUID
+		var c current
+		var primary bool
+		if err = rows.Scan(&id, &c.verified, &primary, &c.key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		devices[id] = c
+		if primary && id == deviceID {
+			isPrimary, primaryKey = true, c.key
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if !isPrimary {
+		err = ErrNotPrimary
+		return nil, err
+	}
+
+	listed := make([]ListedDevice, 0, len(deviceIDs))
+	seen := make(map[uuid.UUID]bool, len(deviceIDs))
+	for _, id := range deviceIDs {
+		c, ok := devices[id]
+		if !ok || seen[id] || c.key == nil {
+			err = ErrInvalidDeviceList
+			return nil, err
+		}
+		seen[id] = true
+		listed = append(listed, ListedDevice{DeviceID: id, IdentityKey: c.key})
+	}
+	if !seen[deviceID] {
+		err = ErrInvalidDeviceList
+		return nil, err
+	}
+	sortListed(listed)
+	if !ed25519.Verify(primaryKey, deviceListMessage(userID, version, listed), signature) {
+		err = ErrBadListSignature
+		return nil, err
+	}
+
+	var stored int64
+	err = tx.QueryRow(ctx, `SELECT version FROM device_lists WHERE user_id=$1`, userID).Scan(&stored)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		err = nil
+	case err != nil:
+		return nil, err
+	case version <= stored:
+		err = ErrStaleDeviceList
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, len(listed))
+	keys := make([][]byte, len(listed))
+	for i, d := range listed {
+		ids[i], keys[i] = d.DeviceID, d.IdentityKey
+	}
+	for id, c := range devices {
+		if c.verified && c.key != nil && !seen[id] {
+			revoked = append(revoked, id)
+		}
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM devices WHERE id = ANY($1)`, revoked); err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec(ctx, `UPDATE devices SET verified = (id = ANY($2)) WHERE user_id=$1`, userID, ids); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `INSERT INTO device_lists (user_id, version, device_ids, identity_keys, signature, signed_by) VALUES ($1, $2, $3, $4, $5, $6)
+      ON CONFLICT (user_id) DO UPDATE
+        SET version = EXCLUDED.version, device_ids = EXCLUDED.device_ids, identity_keys = EXCLUDED.identity_keys,
+            signature = EXCLUDED.signature, signed_by = EXCLUDED.signed_by, updated_at = now()`,
+		userID, version, ids, keys, signature, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return revoked, nil
+}
+
+func deviceListMessage(userID uuid.UUID, version int64, devices []ListedDevice) []byte {
+	msg := make([]byte, 0, len(deviceListContext)+1+16+8+len(devices)*(16+ed25519.PublicKeySize))
+	msg = append(msg, deviceListContext...)
+	msg = append(msg, 0)
+	msg = append(msg, userID[:]...)
+	msg = binary.BigEndian.AppendUint64(msg, uint64(version))
+	for _, d := range devices {
+		msg = append(msg, d.DeviceID[:]...)
+		msg = append(msg, d.IdentityKey...)
+	}
+	return msg
+}
+
+func sortListed(devices []ListedDevice) {
+	sort.Slice(devices, func(i, j int) bool {
+		return bytes.Compare(devices[i].DeviceID[:], devices[j].DeviceID[:]) < 0
+	})
+}
@@ -13,7 +13,9 @@ import (
 type User struct {
 	ID          uuid.UUID
 	Phone       string
+	Username    *string
 	DisplayName *string
+	AvatarID    *uuid.UUID
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
@@ -25,6 +27,10 @@ type Device struct {
 	PushToken  *string
 	CreatedAt  time.Time
 	LastSeenAt time.Time
+	// Verified devices are on the account's signed device list; Primary is
+	// the device that signs it.
+	Verified bool
+	Primary  bool
 }
 
 type Repository struct {
@@ -36,9 +42,9 @@ func NewRepository(pool *pgxpool.Pool) *Repository {
 }
 
 func (r *Repository) GetByID(ctx context.Context, id uuid.UUID) (*User, error) {
-	row := r.pool.QueryRow(ctx, `SELECT id, phone, display_name, created_at, updated_at FROM users WHERE id=$1`, id)
+	row := r.pool.QueryRow(ctx, `SELECT id, phone, username, display_name, avatar_id, created_at, updated_at FROM users WHERE id=$1`, id)
 	var u User
-	if err := row.Scan(&u.ID, &u.Phone, &u.DisplayName, &u.CreatedAt, &u.UpdatedAt); err != nil {
+	if err := row.Scan(&u.ID, &u.Phone, &u.Username, &u.DisplayName, &u.AvatarID, &u.CreatedAt, &u.UpdatedAt); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -48,9 +54,9 @@ func (r *Repository) GetByID(ctx context.Context, id uuid.UUID) (*User, error) {
 }
 
 func (r *Repository) GetByPhone(ctx context.Context, phone string) (*User, error) {
-	row := r.pool.QueryRow(ctx, `SELECT id, phone, display_name, created_at, updated_at FROM users WHERE phone=$1`, phone)
+	row := r.pool.QueryRow(ctx, `SELECT id, phone, username, display_name, avatar_id, created_at, updated_at FROM users WHERE phone=$1`, phone)
 	var u User
-	if err := row.Scan(&u.ID, &u.Phone, &u.DisplayName, &u.CreatedAt, &u.UpdatedAt); err != nil {
+	if err := row.Scan(&u.ID, &u.Phone, &u.Username, &u.DisplayName, &u.AvatarID, &u.CreatedAt, &u.UpdatedAt); err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil
 		}
@@ -78,10 +84,13 @@ func (r *Repository) UpsertUserAndDevice(ctx context.Context, phone string, labe
 		return nil, nil, err
 	}
 
-	device, err = upsertDevice(ctx, tx, user.ID, label, pushToken)
+	device, err = insertDevice(ctx, tx, user.ID, label, pushToken)
 	if err != nil {
 		return nil, nil, err
 	}
+	if err = pruneDevices(ctx, tx, device); err != nil {
+		return nil, nil, err
+	}
 
 	return user, device, nil
 }
@@ -91,28 +100,106 @@ func (r *Repository) UpdateDeviceLastSeen(ctx context.Context, deviceID uuid.UUI
 	return err
 }
 
+// LastSeen returns the most recent last_seen_at across the user's devices.
+func (r *Repository) LastSeen(ctx context.Context, userID uuid.UUID) (*time.Time, error) {
+	var seen *time.Time
+	err := r.pool.QueryRow(ctx, `SELECT max(last_seen_at) FROM devices WHERE user_id=$1`, userID).Scan(&seen)
+	return seen, err
+}
+
+// NotificationSettings decide which notifications get through a muted chat.
+type NotificationSettings struct {
+	MentionsOverrideMute bool `json:"mentionsOverrideMute"`
+	RepliesOverrideMute  bool `json:"repliesOverrideMute"`
+}
+
+func (r *Repository) NotificationSettings(ctx context.Context, userID uuid.UUID) (NotificationSettings, error) {
+	var ns NotificationSettings
+	err := r.pool.QueryRow(ctx, `SELECT mentions_override_mute, replies_override_mute FROM users WHERE id=$1`, userID).
+		Scan(&ns.MentionsOverrideMute, &ns.RepliesOverrideMute)
+	return ns, err
+}
+
+func (r *Repository) SetNotificationSettings(ctx context.Context, userID uuid.UUID, ns NotificationSettings) error {
+	_, err := r.pool.Exec(ctx, `UPDATE users SET mentions_override_mute=$2, replies_override_mute=$3, updated_at=now() WHERE id=$1`,
+		userID, ns.MentionsOverrideMute, ns.RepliesOverrideMute)
+	return err
+}
+
+// Visibility says who may see a piece of account information.
+type Visibility string
+
+const (
+	VisibilityEveryone Visibility = "everyone"
+	VisibilityNobody   Visibility = "nobody"
+)
+
+func (v Visibility) Valid() bool {
+	return v == VisibilityEveryone || v == VisibilityNobody
+}
+
+// PrivacySettings control what other users learn about the account.
+// ForwardAttribution decides whether forwards of the user's messages link
+// back to them.
+type PrivacySettings struct {
+	ForwardAttribution Visibility `json:"forwardAttribution"`
+}
+
+func (r *Repository) PrivacySettings(ctx context.Context, userID uuid.UUID) (PrivacySettings, error) {
+	var ps PrivacySettings
+	err := r.pool.QueryRow(ctx, `SELECT forward_attribution FROM users WHERE id=$1`, userID).Scan(&ps.ForwardAttribution)
+	return ps, err
+}
+
+func (r *Repository) SetPrivacySettings(ctx context.Context, userID uuid.UUID, ps PrivacySettings) error {
+	_, err := r.pool.Exec(ctx, `UPDATE users SET forward_attribution=$2, updated_at=now() WHERE id=$1`, userID, ps.ForwardAttribution)
+	return err
+}
+
 func upsertUser(ctx context.Context, q pgx.Tx, phone string) (*User, error) {
 	var user User
 	err := q.QueryRow(ctx, `INSERT INTO users (phone) VALUES ($1)
       ON CONFLICT (phone) DO UPDATE SET updated_at = now()
-      RETURNING id, phone, display_name, created_at, updated_at`, phone).Scan(&user.ID, &user.Phone, &user.DisplayName, &user.CreatedAt, &user.UpdatedAt)
+      RETURNING id, phone, username, display_name, avatar_id, created_at, updated_at`, phone).Scan(&user.ID, &user.Phone, &user.Username, &user.DisplayName, &user.AvatarID, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func upsertDevice(ctx context.Context, q pgx.Tx, userID uuid.UUID, label, pushToken *string) (*Device, error) {
+// insertDevice creates a device for a login. The account's first device is
+// its primary; every later login gets a new device that waits for the
+// primary to approve it, even if it reuses a label, so that a login never
+// inherits another device's trust.
+func insertDevice(ctx context.Context, q pgx.Tx, userID uuid.UUID, label, pushToken *string) (*Device, error) {
 	var device Device
-	err := q.QueryRow(ctx, `INSERT INTO devices (user_id, label, push_token)
-      VALUES ($1, $2, $3)
-      ON CONFLICT (user_id, COALESCE(label, '')) DO UPDATE
-        SET push_token = EXCLUDED.push_token, last_seen_at = now()
-      RETURNING id, user_id, label, push_token, created_at, last_seen_at`,
+	err := q.QueryRow(ctx, `INSERT INTO devices (user_id, label, push_token, verified, is_primary)
+      SELECT $1, $2, $3, f.first, f.first
+      FROM (SELECT NOT EXISTS (SELECT 1 FROM devices WHERE user_id = $1) AS first) f
+      RETURNING id, user_id, label, push_token, created_at, last_seen_at, verified, is_primary`,
 		userID, label, pushToken,
-	).Scan(&device.ID, &device.UserID, &device.Label, &device.PushToken, &device.CreatedAt, &device.LastSeenAt)
+	).Scan(&device.ID, &device.UserID, &device.Label, &device.PushToken, &device.CreatedAt, &device.LastSeenAt,
+		&device.Verified, &device.Primary)
 	if err != nil {
 		return nil, err
 	}
 	return &device, nil
 }
+
+// pruneDevices cleans up after the logins that preceded device. The push
+// token moves to the new device, so a handset that logged in again is not
+// pushed once per past login, and pending devices left without a live
+// session are removed, as no one can sign in to them any more.
+func pruneDevices(ctx context.Context, q pgx.Tx, device *Device) error {
+	if device.PushToken != nil {
+		if _, err := q.Exec(ctx, `UPDATE devices SET push_token = NULL
+          WHERE user_id=$1 AND push_token=$2 AND id<>$3`, device.UserID, *device.PushToken, device.ID); err != nil {
+			return err
+		}
+	}
+	_, err := q.Exec(ctx, `DELETE FROM devices d
+      WHERE d.user_id=$1 AND d.id<>$2 AND NOT d.verified AND NOT d.is_primary
+        AND NOT EXISTS (SELECT 1 FROM sessions s WHERE s.device_id = d.id AND s.expires_at > now())`,
+		device.UserID, device.ID)
+	return err
+}
@@ -0,0 +1,87 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/kentapp/kent/server/internal/db"
+)
+
+var (
+	ErrInvalidUsername = errors.New("users: invalid username")
+	ErrUsernameTaken   = errors.New("users: username is taken")
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{4,31}$`)
+
+// Contact is what search reveals about another user.
+type Contact struct {
+	ID          uuid.UUID  `json:"id"`
+	Username    *string    `json:"username,omitempty"`
+	DisplayName *string    `json:"displayName,omitempty"`
+	AvatarID    *uuid.UUID `json:"avatarId,omitempty"`
+}
+
+// SetUsername claims a public handle, or releases it when username is empty.
+// Handles are case-insensitive and stored lowercased.
+func (r *Repository) SetUsername(ctx context.Context, userID uuid.UUID, username string) (*string, error) {
+	var value *string
+	if username = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@")); username != "" {
+		if !usernamePattern.MatchString(username) {
+			return nil, ErrInvalidUsername
+		}
+		value = &username
+	}
+
+	_, err := r.pool.Exec(ctx, `UPDATE users SET username=$2, updated_at=now() WHERE id=$1`, userID, value)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return nil, ErrUsernameTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Search finds users by username, and by display name among people the
+// caller already shares a direct chat or group with. Exact username matches
+// rank first, then by trigram word similarity.
+func (r *Repository) Search(ctx context.Context, userID uuid.UUID, text string, limit, offset int) ([]Contact, error) {
+	rows, err := r.pool.Query(ctx, `WITH known AS (
+        SELECT DISTINCT p2.user_id
+        FROM chat_participants p1
+        JOIN chats c ON c.id = p1.chat_id AND c.kind IN ('direct', 'group')
+        JOIN chat_participants p2 ON p2.chat_id = p1.chat_id AND p2.user_id <> p1.user_id
+        WHERE p1.user_id = $1)
+      SELECT u.id, u.username, u.display_name, u.avatar_id
+      FROM users u
+      LEFT JOIN known k ON k.user_id = u.id
+      WHERE u.id <> $1
+        AND (u.username ILIKE $3 OR $2 <% u.username
+          OR (k.user_id IS NOT NULL AND (u.display_name ILIKE $3 OR $2 <% u.display_name)))
+      ORDER BY u.username = lower($2) DESC NULLS LAST,
+        GREATEST(word_similarity($2, u.username),
+          CASE WHEN k.user_id IS NOT NULL THEN word_similarity($2, u.display_name) END) DESC NULLS LAST,
+        u.id
+      LIMIT $4 OFFSET $5`, userID, text, db.ContainsPattern(text), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]Contact, 0, limit)
+	for rows.Next() {
+		var ct Contact
+		if err := rows.Scan(&ct.ID, &ct.Username, &ct.DisplayName, &ct.AvatarID); err != nil {
+			return nil, err
+		}
+		out = append(out, ct)
+	}
+	return out, rows.Err()
+}
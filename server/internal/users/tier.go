@@ -0,0 +1,35 @@
+package users
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// Tier is the account's subscription tier, which sets its storage quota.
+type Tier string
+
+const (
+	TierFree    Tier = "free"
+	TierPremium Tier = "premium"
+)
+
+var ErrInvalidTier = errors.New("users: unknown tier")
+
+func (t Tier) Valid() bool {
+	return t == TierFree || t == TierPremium
+}
+
+// SetTier changes the user's subscription tier and reports whether the
+// user exists.
+func (r *Repository) SetTier(ctx context.Context, userID uuid.UUID, tier Tier) (bool, error) {
+	if !tier.Valid() {
+		return false, ErrInvalidTier
+	}
+	tag, err := r.pool.Exec(ctx, `UPDATE users SET tier=$2, updated_at=now() WHERE id=$1`, userID, tier)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,23 @@
+ALTER TABLE chats
+  ADD COLUMN IF NOT EXISTS title TEXT,
+  ADD COLUMN IF NOT EXISTS created_by UUID REFERENCES users(id) ON DELETE SET NULL;
+
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS sender_device_id UUID REFERENCES devices(id) ON DELETE SET NULL,
+  ADD COLUMN IF NOT EXISTS edit_count INT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS deleted_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Tombstones keep the row but drop the encrypted body.
+ALTER TABLE messages ALTER COLUMN ciphertext DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS messages_chat_updated_idx ON messages(chat_id, updated_at);
+
+CREATE TABLE IF NOT EXISTS message_hidden (
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  hidden_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (message_id, user_id)
+);
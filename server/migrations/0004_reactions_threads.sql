@@ -0,0 +1,15 @@
+-- Reply and thread linkage is cleartext metadata next to messages.headers.
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS reply_to_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+  ADD COLUMN IF NOT EXISTS thread_root_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS messages_thread_idx ON messages(thread_root_id, created_at DESC)
+  WHERE thread_root_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS message_reactions (
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  emoji TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (message_id, user_id)
+);
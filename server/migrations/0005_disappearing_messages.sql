@@ -0,0 +1,8 @@
+ALTER TABLE chats
+  ADD COLUMN IF NOT EXISTS message_ttl_seconds INT CHECK (message_ttl_seconds > 0);
+
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS messages_expires_idx ON messages(expires_at)
+  WHERE expires_at IS NOT NULL;
@@ -0,0 +1,15 @@
+-- Per-user chat state, synced across the user's devices.
+ALTER TABLE chat_participants
+  ADD COLUMN IF NOT EXISTS muted_until TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS archived BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN IF NOT EXISTS pin_order INT;
+
+CREATE INDEX IF NOT EXISTS chat_participants_user_idx ON chat_participants(user_id);
+
+CREATE TABLE IF NOT EXISTS chat_pinned_messages (
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  pinned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  pinned_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (chat_id, message_id)
+);
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS chat_invite_links (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  token TEXT UNIQUE NOT NULL,
+  created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  expires_at TIMESTAMPTZ,
+  max_uses INT CHECK (max_uses > 0),
+  use_count INT NOT NULL DEFAULT 0,
+  requires_approval BOOLEAN NOT NULL DEFAULT FALSE,
+  revoked_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS chat_invite_links_chat_idx ON chat_invite_links(chat_id);
+
+CREATE TABLE IF NOT EXISTS chat_join_requests (
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  link_id UUID NOT NULL REFERENCES chat_invite_links(id) ON DELETE CASCADE,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'approved', 'rejected')),
+  requested_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  decided_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  decided_at TIMESTAMPTZ,
+  PRIMARY KEY (chat_id, user_id)
+);
+
+-- Audit trail of who joined through which link.
+CREATE TABLE IF NOT EXISTS chat_invite_joins (
+  id BIGSERIAL PRIMARY KEY,
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  link_id UUID REFERENCES chat_invite_links(id) ON DELETE SET NULL,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  approved_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  joined_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS chat_invite_joins_chat_idx ON chat_invite_joins(chat_id, joined_at DESC);
@@ -0,0 +1,26 @@
+UPDATE chat_participants SET role = 'member' WHERE role NOT IN ('owner', 'admin', 'member');
+
+ALTER TABLE chat_participants DROP CONSTRAINT IF EXISTS chat_participants_role_check;
+ALTER TABLE chat_participants ADD CONSTRAINT chat_participants_role_check
+  CHECK (role IN ('owner', 'admin', 'member'));
+
+-- Admin rights bitmask (see chats.Permission). Admins that predate it get
+-- every right once, when the column is added; later admins may hold none.
+DO $$ BEGIN
+  IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'chat_participants' AND column_name = 'permissions') THEN
+    ALTER TABLE chat_participants ADD COLUMN permissions INT NOT NULL DEFAULT 0;
+    UPDATE chat_participants SET permissions = 31 WHERE role = 'admin';
+  END IF;
+END $$;
+
+-- Member mute.
+ALTER TABLE chat_participants ADD COLUMN IF NOT EXISTS restricted_until TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS chat_bans (
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  banned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  until TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (chat_id, user_id)
+);
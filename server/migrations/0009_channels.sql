@@ -0,0 +1,52 @@
+DO $$ BEGIN
+  CREATE TYPE chat_kind AS ENUM ('direct', 'group', 'channel');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+ALTER TABLE chats
+  ADD COLUMN IF NOT EXISTS kind chat_kind NOT NULL DEFAULT 'direct',
+  ADD COLUMN IF NOT EXISTS is_public BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- chats.is_group is replaced by chats.kind.
+DO $$ BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'chats' AND column_name = 'is_group') THEN
+    UPDATE chats SET kind = 'group' WHERE is_group;
+    ALTER TABLE chats DROP COLUMN is_group;
+  END IF;
+END $$;
+
+-- member_count is kept by trigger so channel subscriber counts stay cheap.
+-- It is backfilled and its trigger installed only when the column is added:
+-- 0029 replaces the trigger function, and recounting on a later run would
+-- add the deltas still queued in chat_member_deltas a second time.
+DO $$ BEGIN
+  IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'chats' AND column_name = 'member_count') THEN
+    ALTER TABLE chats ADD COLUMN member_count INT NOT NULL DEFAULT 0;
+    UPDATE chats c SET member_count = (SELECT count(*) FROM chat_participants p WHERE p.chat_id = c.id);
+
+    CREATE OR REPLACE FUNCTION chat_member_count() RETURNS trigger AS $fn$
+    BEGIN
+      IF TG_OP = 'INSERT' THEN
+        UPDATE chats SET member_count = member_count + 1 WHERE id = NEW.chat_id;
+      ELSE
+        UPDATE chats SET member_count = member_count - 1 WHERE id = OLD.chat_id;
+      END IF;
+      RETURN NULL;
+    END $fn$ LANGUAGE plpgsql;
+
+    DROP TRIGGER IF EXISTS chat_participants_count ON chat_participants;
+    CREATE TRIGGER chat_participants_count AFTER INSERT OR DELETE ON chat_participants
+      FOR EACH ROW EXECUTE FUNCTION chat_member_count();
+  END IF;
+END $$;
+
+-- Channel posts are public and stored in cleartext.
+ALTER TABLE messages
+  ADD COLUMN IF NOT EXISTS body TEXT,
+  ADD COLUMN IF NOT EXISTS view_count BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS channel_post_views (
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  PRIMARY KEY (message_id, user_id)
+);
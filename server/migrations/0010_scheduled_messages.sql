@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS scheduled_messages (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  sender_device_id UUID REFERENCES devices(id) ON DELETE SET NULL,
+  ciphertext BYTEA,
+  body TEXT,
+  headers JSONB,
+  reply_to_id UUID,
+  thread_root_id UUID,
+  ttl_seconds INT,
+  send_at TIMESTAMPTZ NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'failed')),
+  failure TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Delivered rows are deleted, so the scheduler only ever scans pending ones.
+CREATE INDEX IF NOT EXISTS scheduled_messages_due_idx ON scheduled_messages(send_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS scheduled_messages_sender_idx ON scheduled_messages(sender_id, chat_id, send_at);
@@ -0,0 +1,38 @@
+-- Polls are tallied by the server, so question and options are cleartext.
+-- The poll is announced by a message whose headers carry { "pollId": ... }.
+CREATE TABLE IF NOT EXISTS polls (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  message_id UUID UNIQUE REFERENCES messages(id) ON DELETE CASCADE,
+  created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  question TEXT NOT NULL,
+  anonymous BOOLEAN NOT NULL DEFAULT TRUE,
+  multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+  quiz BOOLEAN NOT NULL DEFAULT FALSE,
+  correct_option SMALLINT,
+  explanation TEXT,
+  closes_at TIMESTAMPTZ,
+  closed_at TIMESTAMPTZ,
+  voter_count INT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  CHECK (NOT quiz OR (correct_option IS NOT NULL AND NOT multiple_choice))
+);
+
+CREATE TABLE IF NOT EXISTS poll_options (
+  poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+  idx SMALLINT NOT NULL,
+  text TEXT NOT NULL,
+  vote_count INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (poll_id, idx)
+);
+
+-- One row per voter enforces one vote per member, even with multiple choice.
+CREATE TABLE IF NOT EXISTS poll_votes (
+  poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  options SMALLINT[] NOT NULL,
+  voted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (poll_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS poll_votes_options_idx ON poll_votes USING GIN (options);
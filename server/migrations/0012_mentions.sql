@@ -0,0 +1,19 @@
+-- Mentions are sent in cleartext next to the encrypted body so the server
+-- can target notifications.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS mentions UUID[];
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS mentions UUID[];
+
+CREATE TABLE IF NOT EXISTS message_mentions (
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  kind TEXT NOT NULL CHECK (kind IN ('mention', 'reply')),
+  read_at TIMESTAMPTZ,
+  PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_mentions_unread_idx ON message_mentions(user_id, chat_id) WHERE read_at IS NULL;
+
+ALTER TABLE users
+  ADD COLUMN IF NOT EXISTS mentions_override_mute BOOLEAN NOT NULL DEFAULT TRUE,
+  ADD COLUMN IF NOT EXISTS replies_override_mute BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,11 @@
+-- One encrypted draft per user and chat. A cleared draft keeps its row with
+-- a NULL ciphertext so that last-writer-wins still applies to the clear.
+CREATE TABLE IF NOT EXISTS chat_drafts (
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  ciphertext BYTEA,
+  device_id UUID REFERENCES devices(id) ON DELETE SET NULL,
+  client_updated_at TIMESTAMPTZ NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, chat_id)
+);
@@ -0,0 +1,15 @@
+ALTER TYPE chat_kind ADD VALUE IF NOT EXISTS 'self';
+
+-- Each user has at most one saved-messages chat, created on first use.
+CREATE UNIQUE INDEX IF NOT EXISTS chats_self_idx ON chats(created_by) WHERE kind = 'self';
+
+-- Tags are cleartext so the server can filter by them.
+CREATE TABLE IF NOT EXISTS saved_message_tags (
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  tag TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (message_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS saved_message_tags_user_idx ON saved_message_tags(user_id, tag);
@@ -0,0 +1,9 @@
+-- Provenance of forwarded messages: { userId?, chatId?, messageId?, hidden?, sentAt }.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS forwarded_from JSONB;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS forward_attribution TEXT NOT NULL DEFAULT 'everyone';
+
+DO $$ BEGIN
+  ALTER TABLE users ADD CONSTRAINT users_forward_attribution_check CHECK (forward_attribution IN ('everyone', 'nobody'));
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
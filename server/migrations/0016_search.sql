@@ -0,0 +1,20 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Usernames are public handles: anyone can find a user by username, while
+-- display names only match people the searcher already shares a chat with.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username TEXT;
+
+DO $$ BEGIN
+  ALTER TABLE users ADD CONSTRAINT users_username_check CHECK (username ~ '^[a-z][a-z0-9_]{4,31}$');
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_idx ON users(username);
+
+CREATE INDEX IF NOT EXISTS users_username_trgm_idx ON users USING GIN (username gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS users_display_name_trgm_idx ON users USING GIN (display_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS chats_title_trgm_idx ON chats USING GIN (title gin_trgm_ops);
+
+-- Only channel posts have a cleartext body.
+CREATE INDEX IF NOT EXISTS messages_body_fts_idx ON messages USING GIN (to_tsvector('simple', body))
+  WHERE body IS NOT NULL;
@@ -0,0 +1,25 @@
+-- X3DH key material, per device. Identity keys are Ed25519; prekeys X25519.
+CREATE TABLE IF NOT EXISTS device_identity_keys (
+  device_id UUID PRIMARY KEY REFERENCES devices(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  identity_key BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS device_identity_keys_user_idx ON device_identity_keys(user_id);
+
+CREATE TABLE IF NOT EXISTS signed_prekeys (
+  device_id UUID PRIMARY KEY REFERENCES device_identity_keys(device_id) ON DELETE CASCADE,
+  key_id INT NOT NULL,
+  public_key BYTEA NOT NULL,
+  signature BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS one_time_prekeys (
+  device_id UUID NOT NULL REFERENCES device_identity_keys(device_id) ON DELETE CASCADE,
+  key_id INT NOT NULL,
+  public_key BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (device_id, key_id)
+);
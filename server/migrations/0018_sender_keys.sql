@@ -0,0 +1,41 @@
+-- Group sender keys. The epoch moves on every membership change so that a
+-- removed member cannot read, and a new member cannot read back, under an
+-- old sender key.
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS sender_key_epoch INT NOT NULL DEFAULT 0;
+
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS sender_key_epoch INT;
+
+-- Encrypted sender-key distribution messages queued per recipient device.
+-- message_id is set when the envelope travelled with a message.
+CREATE TABLE IF NOT EXISTS sender_key_messages (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  epoch INT NOT NULL,
+  sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  sender_device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+  device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+  message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+  ciphertext BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS sender_key_messages_device_idx ON sender_key_messages(device_id, created_at);
+
+CREATE OR REPLACE FUNCTION chat_sender_key_rotate() RETURNS trigger AS $$
+DECLARE
+  changed chat_participants;
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    changed := NEW;
+  ELSE
+    changed := OLD;
+    DELETE FROM sender_key_messages
+      WHERE chat_id = OLD.chat_id AND device_id IN (SELECT id FROM devices WHERE user_id = OLD.user_id);
+  END IF;
+  UPDATE chats SET sender_key_epoch = sender_key_epoch + 1 WHERE id = changed.chat_id AND kind = 'group';
+  RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS chat_participants_sender_key ON chat_participants;
+CREATE TRIGGER chat_participants_sender_key AFTER INSERT OR DELETE ON chat_participants
+  FOR EACH ROW EXECUTE FUNCTION chat_sender_key_rotate();
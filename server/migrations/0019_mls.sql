@@ -0,0 +1,47 @@
+-- MLS (RFC 9420) delivery service. The server stores and orders opaque MLS
+-- messages; it never holds group secrets.
+CREATE TABLE IF NOT EXISTS mls_key_packages (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  key_package BYTEA NOT NULL,
+  -- A last-resort package is handed out when the others run out and is
+  -- never consumed.
+  last_resort BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS mls_key_packages_device_idx ON mls_key_packages(device_id, last_resort, created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS mls_key_packages_last_resort_idx ON mls_key_packages(device_id) WHERE last_resort;
+
+CREATE TABLE IF NOT EXISTS mls_groups (
+  chat_id UUID PRIMARY KEY REFERENCES chats(id) ON DELETE CASCADE,
+  epoch BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Handshake messages in delivery order. A commit at epoch n moves the group
+-- to n + 1.
+CREATE TABLE IF NOT EXISTS mls_messages (
+  seq BIGSERIAL PRIMARY KEY,
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  epoch BIGINT NOT NULL,
+  kind TEXT NOT NULL CHECK (kind IN ('proposal', 'commit')),
+  sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  sender_device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+  payload BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS mls_messages_chat_idx ON mls_messages(chat_id, seq);
+
+CREATE TABLE IF NOT EXISTS mls_welcomes (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  chat_id UUID NOT NULL REFERENCES chats(id) ON DELETE CASCADE,
+  device_id UUID NOT NULL REFERENCES devices(id) ON DELETE CASCADE,
+  epoch BIGINT NOT NULL,
+  payload BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS mls_welcomes_device_idx ON mls_welcomes(device_id, created_at);
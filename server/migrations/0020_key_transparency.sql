@@ -0,0 +1,25 @@
+-- Append-only log of (user, device, identity key) bindings, the leaves of
+-- the key transparency Merkle tree. idx is dense: appends are serialized.
+CREATE TABLE IF NOT EXISTS kt_entries (
+  idx BIGINT PRIMARY KEY,
+  user_id UUID NOT NULL,
+  device_id UUID NOT NULL,
+  identity_key BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  leaf_hash BYTEA NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS kt_entries_user_idx ON kt_entries(user_id, idx);
+
+CREATE OR REPLACE RULE kt_entries_no_update AS ON UPDATE TO kt_entries DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE kt_entries_no_delete AS ON DELETE TO kt_entries DO INSTEAD NOTHING;
+
+-- Log the identity keys published before the log existed. The leaf encoding
+-- must match transparency.leafData.
+INSERT INTO kt_entries (idx, user_id, device_id, identity_key, created_at, leaf_hash)
+SELECT row_number() OVER (ORDER BY k.created_at, k.device_id) - 1, k.user_id, k.device_id, k.identity_key, k.ts,
+  digest('\x0000'::bytea || uuid_send(k.user_id) || uuid_send(k.device_id)
+    || int8send(floor(extract(epoch FROM k.ts) * 1000)::bigint) || k.identity_key, 'sha256')
+FROM (SELECT user_id, device_id, identity_key, created_at, date_trunc('milliseconds', created_at) AS ts
+      FROM device_identity_keys) k
+WHERE NOT EXISTS (SELECT 1 FROM kt_entries);
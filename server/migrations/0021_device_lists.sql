@@ -0,0 +1,26 @@
+-- Devices start unverified until the account's primary device signs a device
+-- list including them. Devices that predate signed lists are trusted, and
+-- the oldest one of each account becomes its primary.
+DO $$ BEGIN
+  IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'devices' AND column_name = 'verified') THEN
+    ALTER TABLE devices
+      ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE,
+      ADD COLUMN is_primary BOOLEAN NOT NULL DEFAULT FALSE;
+    UPDATE devices SET verified = TRUE;
+    UPDATE devices d SET is_primary = TRUE
+      WHERE d.id = (SELECT id FROM devices WHERE user_id = d.user_id ORDER BY created_at, id LIMIT 1);
+  END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS devices_primary_idx ON devices(user_id) WHERE is_primary;
+
+-- The latest device list signed by the primary device. version only grows so
+-- that an old list cannot be replayed.
+CREATE TABLE IF NOT EXISTS device_lists (
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  version BIGINT NOT NULL,
+  device_ids UUID[] NOT NULL,
+  signature BYTEA NOT NULL,
+  signed_by UUID NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
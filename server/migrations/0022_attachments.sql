@@ -0,0 +1,60 @@
+-- Client-encrypted attachments, uploaded in chunks. received counts the bytes
+-- stored so far as parts; once it reaches size the parts are joined into
+-- storage_key, digest is set and the parts are dropped.
+CREATE TABLE IF NOT EXISTS attachments (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  size BIGINT NOT NULL CHECK (size > 0),
+  received BIGINT NOT NULL DEFAULT 0,
+  expected_digest BYTEA,
+  digest BYTEA,
+  storage_key TEXT,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS attachments_owner_idx ON attachments(owner_id, created_at);
+
+CREATE TABLE IF NOT EXISTS attachment_parts (
+  attachment_id UUID NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+  "offset" BIGINT NOT NULL,
+  size BIGINT NOT NULL,
+  storage_key TEXT NOT NULL,
+  PRIMARY KEY (attachment_id, "offset")
+);
+
+-- Messages reference attachments with { "attachments": [id, ...] } in their
+-- headers. The links are kept in sync with the headers so that access checks
+-- and garbage collection do not have to parse JSON.
+CREATE TABLE IF NOT EXISTS message_attachments (
+  message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+  attachment_id UUID NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+  PRIMARY KEY (message_id, attachment_id)
+);
+
+CREATE INDEX IF NOT EXISTS message_attachments_attachment_idx ON message_attachments(attachment_id);
+
+CREATE OR REPLACE FUNCTION message_attachments_sync() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    DELETE FROM message_attachments WHERE message_id = NEW.id;
+  END IF;
+  INSERT INTO message_attachments (message_id, attachment_id)
+  SELECT NEW.id, a.id FROM attachments a
+  WHERE a.id IN (
+    SELECT e::uuid FROM jsonb_array_elements_text(
+      CASE WHEN jsonb_typeof(NEW.headers->'attachments') = 'array' THEN NEW.headers->'attachments' ELSE '[]'::jsonb END) e
+    WHERE e ~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$')
+  ON CONFLICT DO NOTHING;
+  RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS messages_attachments_insert ON messages;
+CREATE TRIGGER messages_attachments_insert AFTER INSERT ON messages
+  FOR EACH ROW WHEN (NEW.headers ? 'attachments')
+  EXECUTE FUNCTION message_attachments_sync();
+
+DROP TRIGGER IF EXISTS messages_attachments_update ON messages;
+CREATE TRIGGER messages_attachments_update AFTER UPDATE OF headers ON messages
+  FOR EACH ROW WHEN (OLD.headers IS DISTINCT FROM NEW.headers)
+  EXECUTE FUNCTION message_attachments_sync();
@@ -0,0 +1,96 @@
+-- Subscription tier, which decides the user's storage quota.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tier TEXT NOT NULL DEFAULT 'free';
+
+DO $$ BEGIN
+  ALTER TABLE users ADD CONSTRAINT users_tier_check CHECK (tier IN ('free', 'premium'));
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+-- unreferenced_at is when the attachment was last left without messages
+-- referencing it, or last received a chunk; garbage collection waits a grace
+-- period after it.
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS unreferenced_at TIMESTAMPTZ;
+ALTER TABLE attachments ALTER COLUMN unreferenced_at SET DEFAULT now();
+UPDATE attachments a SET unreferenced_at = a.created_at
+  WHERE unreferenced_at IS NULL AND NOT EXISTS (SELECT 1 FROM message_attachments ma WHERE ma.attachment_id = a.id);
+CREATE INDEX IF NOT EXISTS attachments_unreferenced_idx ON attachments(unreferenced_at) WHERE unreferenced_at IS NOT NULL;
+
+-- Links carry the chat and size so that usage can be adjusted after the
+-- message or attachment row is already gone.
+ALTER TABLE message_attachments
+  ADD COLUMN IF NOT EXISTS chat_id UUID,
+  ADD COLUMN IF NOT EXISTS size BIGINT;
+UPDATE message_attachments ma SET chat_id = m.chat_id, size = a.size
+  FROM messages m, attachments a
+  WHERE m.id = ma.message_id AND a.id = ma.attachment_id AND ma.chat_id IS NULL;
+
+CREATE OR REPLACE FUNCTION message_attachments_sync() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    DELETE FROM message_attachments WHERE message_id = NEW.id;
+  END IF;
+  INSERT INTO message_attachments (message_id, attachment_id, chat_id, size)
+  SELECT NEW.id, a.id, NEW.chat_id, a.size FROM attachments a
+  WHERE a.id IN (
+    SELECT e::uuid FROM jsonb_array_elements_text(
+      CASE WHEN jsonb_typeof(NEW.headers->'attachments') = 'array' THEN NEW.headers->'attachments' ELSE '[]'::jsonb END) e
+    WHERE e ~* '^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$')
+  ON CONFLICT DO NOTHING;
+  RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+-- Bytes stored per user (every upload they own, unfinished ones at their
+-- full size) and per chat (every attachment reference in its messages).
+-- Both are kept up to date by triggers; POST /v1/admin/storage/recompute
+-- rebuilds them.
+CREATE TABLE IF NOT EXISTS user_storage (
+  user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  bytes BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS chat_storage (
+  chat_id UUID PRIMARY KEY REFERENCES chats(id) ON DELETE CASCADE,
+  bytes BIGINT NOT NULL DEFAULT 0
+);
+
+INSERT INTO user_storage (user_id, bytes)
+SELECT owner_id, sum(size) FROM attachments GROUP BY owner_id
+ON CONFLICT DO NOTHING;
+
+INSERT INTO chat_storage (chat_id, bytes)
+SELECT ma.chat_id, sum(ma.size) FROM message_attachments ma JOIN chats c ON c.id = ma.chat_id GROUP BY ma.chat_id
+ON CONFLICT DO NOTHING;
+
+CREATE OR REPLACE FUNCTION attachments_usage() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    INSERT INTO user_storage (user_id, bytes) VALUES (NEW.owner_id, NEW.size)
+      ON CONFLICT (user_id) DO UPDATE SET bytes = user_storage.bytes + EXCLUDED.bytes;
+  ELSE
+    UPDATE user_storage SET bytes = bytes - OLD.size WHERE user_id = OLD.owner_id;
+  END IF;
+  RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS attachments_usage ON attachments;
+CREATE TRIGGER attachments_usage AFTER INSERT OR DELETE ON attachments
+  FOR EACH ROW EXECUTE FUNCTION attachments_usage();
+
+CREATE OR REPLACE FUNCTION message_attachments_usage() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    INSERT INTO chat_storage (chat_id, bytes) VALUES (NEW.chat_id, NEW.size)
+      ON CONFLICT (chat_id) DO UPDATE SET bytes = chat_storage.bytes + EXCLUDED.bytes;
+    UPDATE attachments SET unreferenced_at = NULL WHERE id = NEW.attachment_id AND unreferenced_at IS NOT NULL;
+  ELSE
+    UPDATE chat_storage SET bytes = bytes - OLD.size WHERE chat_id = OLD.chat_id;
+    UPDATE attachments a SET unreferenced_at = now()
+      WHERE a.id = OLD.attachment_id
+        AND NOT EXISTS (SELECT 1 FROM message_attachments ma WHERE ma.attachment_id = a.id);
+  END IF;
+  RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS message_attachments_usage ON message_attachments;
+CREATE TRIGGER message_attachments_usage AFTER INSERT OR DELETE ON message_attachments
+  FOR EACH ROW EXECUTE FUNCTION message_attachments_usage();
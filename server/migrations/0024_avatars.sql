@@ -0,0 +1,52 @@
+-- Processed public images, stored once per distinct content under
+-- images/<hex digest>. unreferenced_at is set while no avatar uses the image;
+-- garbage collection waits a grace period after it.
+CREATE TABLE IF NOT EXISTS images (
+  digest BYTEA PRIMARY KEY,
+  content_type TEXT NOT NULL,
+  size BIGINT NOT NULL,
+  width INT NOT NULL,
+  height INT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  unreferenced_at TIMESTAMPTZ DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS images_unreferenced_idx ON images(unreferenced_at) WHERE unreferenced_at IS NOT NULL;
+
+-- An avatar is one upload rendered at several sizes. Replacing a user's or
+-- chat's avatar deletes the old one.
+CREATE TABLE IF NOT EXISTS avatars (
+  id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+  uploaded_by UUID REFERENCES users(id) ON DELETE SET NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS avatar_images (
+  avatar_id UUID NOT NULL REFERENCES avatars(id) ON DELETE CASCADE,
+  size INT NOT NULL,
+  digest BYTEA NOT NULL REFERENCES images(digest),
+  PRIMARY KEY (avatar_id, size)
+);
+
+CREATE INDEX IF NOT EXISTS avatar_images_digest_idx ON avatar_images(digest);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_id UUID REFERENCES avatars(id) ON DELETE SET NULL;
+ALTER TABLE chats ADD COLUMN IF NOT EXISTS avatar_id UUID REFERENCES avatars(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS users_avatar_idx ON users(avatar_id) WHERE avatar_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS chats_avatar_idx ON chats(avatar_id) WHERE avatar_id IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION avatar_images_refs() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    UPDATE images SET unreferenced_at = NULL WHERE digest = NEW.digest AND unreferenced_at IS NOT NULL;
+  ELSE
+    UPDATE images i SET unreferenced_at = now()
+      WHERE i.digest = OLD.digest
+        AND NOT EXISTS (SELECT 1 FROM avatar_images ai WHERE ai.digest = i.digest);
+  END IF;
+  RETURN NULL;
+END $$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS avatar_images_refs ON avatar_images;
+CREATE TRIGGER avatar_images_refs AFTER INSERT OR DELETE ON avatar_images
+  FOR EACH ROW EXECUTE FUNCTION avatar_images_refs();
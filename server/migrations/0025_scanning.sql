@@ -0,0 +1,44 @@
+-- Uploads the server can read (public attachments for channel posts, and
+-- avatar images) are scanned after upload and quarantined until then.
+-- scan_status is pending, clean, quarantined (flagged, or unscannable after
+-- repeated failures) or released (cleared by an admin); encrypted
+-- attachments are never scanned and keep it NULL.
+ALTER TABLE attachments
+  ADD COLUMN IF NOT EXISTS public BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN IF NOT EXISTS scan_status TEXT,
+  ADD COLUMN IF NOT EXISTS scan_result TEXT,
+  ADD COLUMN IF NOT EXISTS scan_attempts INT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS scan_claimed_until TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMPTZ;
+
+DO $$ BEGIN
+  ALTER TABLE attachments ADD CONSTRAINT attachments_scan_status_check
+    CHECK (scan_status IN ('pending', 'clean', 'quarantined', 'released'));
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE INDEX IF NOT EXISTS attachments_scan_pending_idx ON attachments(completed_at) WHERE scan_status = 'pending';
+CREATE INDEX IF NOT EXISTS attachments_quarantined_idx ON attachments(scanned_at) WHERE scan_status = 'quarantined';
+
+-- Images stored before scanning existed are treated as clean.
+DO $$ BEGIN
+  IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'images' AND column_name = 'scan_status') THEN
+    ALTER TABLE images ADD COLUMN scan_status TEXT NOT NULL DEFAULT 'clean';
+    ALTER TABLE images ALTER COLUMN scan_status SET DEFAULT 'pending';
+  END IF;
+END $$;
+
+ALTER TABLE images
+  ADD COLUMN IF NOT EXISTS scan_result TEXT,
+  ADD COLUMN IF NOT EXISTS scan_attempts INT NOT NULL DEFAULT 0,
+  ADD COLUMN IF NOT EXISTS scan_claimed_until TIMESTAMPTZ,
+  ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMPTZ;
+
+DO $$ BEGIN
+  ALTER TABLE images ADD CONSTRAINT images_scan_status_check
+    CHECK (scan_status IN ('pending', 'clean', 'quarantined', 'released'));
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE INDEX IF NOT EXISTS images_scan_pending_idx ON images(created_at) WHERE scan_status = 'pending';
+CREATE INDEX IF NOT EXISTS images_quarantined_idx ON images(scanned_at) WHERE scan_status = 'quarantined';
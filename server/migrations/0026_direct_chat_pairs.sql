@@ -0,0 +1,20 @@
+-- A direct chat records its two members in UUID order so that a unique
+-- index can stop concurrent requests from opening the same pair twice.
+ALTER TABLE chats
+  ADD COLUMN IF NOT EXISTS direct_low UUID,
+  ADD COLUMN IF NOT EXISTS direct_high UUID;
+
+-- Existing duplicates keep the pair on their oldest chat only.
+UPDATE chats c SET direct_low = p.lo, direct_high = p.hi
+  FROM (
+    SELECT DISTINCT ON (least(pa.user_id, pb.user_id), greatest(pa.user_id, pb.user_id))
+      pa.chat_id, least(pa.user_id, pb.user_id) AS lo, greatest(pa.user_id, pb.user_id) AS hi
+    FROM chat_participants pa
+    JOIN chat_participants pb ON pb.chat_id = pa.chat_id AND pa.user_id < pb.user_id
+    JOIN chats d ON d.id = pa.chat_id AND d.kind = 'direct'
+    ORDER BY least(pa.user_id, pb.user_id), greatest(pa.user_id, pb.user_id), d.created_at, d.id
+  ) p
+  WHERE c.id = p.chat_id AND c.direct_low IS NULL
+    AND NOT EXISTS (SELECT 1 FROM chats o WHERE o.kind = 'direct' AND o.direct_low = p.lo AND o.direct_high = p.hi);
+
+CREATE UNIQUE INDEX IF NOT EXISTS chats_direct_pair_idx ON chats(direct_low, direct_high) WHERE kind = 'direct';
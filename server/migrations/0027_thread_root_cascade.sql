@@ -0,0 +1,6 @@
+-- Deleting a thread root deletes its replies rather than moving them into
+-- the main history. Deleting for everyone only tombstones a message, so
+-- this applies to hard deletes such as disappearing-message expiry.
+ALTER TABLE messages
+  DROP CONSTRAINT IF EXISTS messages_thread_root_id_fkey,
+  ADD CONSTRAINT messages_thread_root_id_fkey FOREIGN KEY (thread_root_id) REFERENCES messages(id) ON DELETE CASCADE;
@@ -0,0 +1,13 @@
+-- Invite links keep only the SHA-256 of their token, which is shown once
+-- when the link is created.
+ALTER TABLE chat_invite_links ADD COLUMN IF NOT EXISTS token_sha256 BYTEA;
+
+DO $$ BEGIN
+  IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'chat_invite_links' AND column_name = 'token') THEN
+    UPDATE chat_invite_links SET token_sha256 = sha256(convert_to(token, 'UTF8')) WHERE token_sha256 IS NULL;
+    ALTER TABLE chat_invite_links DROP COLUMN token;
+  END IF;
+END $$;
+
+ALTER TABLE chat_invite_links ALTER COLUMN token_sha256 SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS chat_invite_links_token_idx ON chat_invite_links(token_sha256);
@@ -0,0 +1,37 @@
+-- Channel view and subscriber counts are folded in by a background job
+-- instead of updating the post or chat row on every view or join, which
+-- would serialize all of a popular channel's viewers on one row.
+
+-- Views recorded before this are already in messages.view_count.
+ALTER TABLE channel_post_views ADD COLUMN IF NOT EXISTS counted BOOLEAN NOT NULL DEFAULT TRUE;
+ALTER TABLE channel_post_views ALTER COLUMN counted SET DEFAULT FALSE;
+CREATE INDEX IF NOT EXISTS channel_post_views_uncounted_idx ON channel_post_views(message_id) WHERE NOT counted;
+
+-- No foreign key: deleting a channel removes its participants, whose
+-- trigger still queues deltas for it.
+CREATE TABLE IF NOT EXISTS chat_member_deltas (
+  id BIGSERIAL PRIMARY KEY,
+  chat_id UUID NOT NULL,
+  delta INT NOT NULL
+);
+
+-- Direct and group chats are small, so their count is still kept exactly.
+CREATE OR REPLACE FUNCTION chat_member_count() RETURNS trigger AS $$
+DECLARE
+  cid UUID;
+  d INT;
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    cid := NEW.chat_id;
+    d := 1;
+  ELSE
+    cid := OLD.chat_id;
+    d := -1;
+  END IF;
+  IF EXISTS (SELECT 1 FROM chats WHERE id = cid AND kind = 'channel') THEN
+    INSERT INTO chat_member_deltas (chat_id, delta) VALUES (cid, d);
+  ELSE
+    UPDATE chats SET member_count = member_count + d WHERE id = cid;
+  END IF;
+  RETURN NULL;
+END $$ LANGUAGE plpgsql;
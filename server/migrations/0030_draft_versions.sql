@@ -0,0 +1,4 @@
+-- Drafts are ordered by a version the server assigns on every save or
+-- clear, rather than by device clocks, which may disagree by minutes.
+ALTER TABLE chat_drafts ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE chat_drafts DROP COLUMN IF EXISTS client_updated_at;
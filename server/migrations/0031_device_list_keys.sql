@@ -0,0 +1,18 @@
+-- Every login creates its own pending device. Reusing a device by label let
+-- a new login inherit an approved device's trust without the primary seeing
+-- it.
+DROP INDEX IF EXISTS devices_user_label_idx;
+
+-- A device list stores the identity keys it was signed over, in the order of
+-- device_ids, so that it is served exactly as signed even after a listed
+-- device changes its key.
+ALTER TABLE device_lists ADD COLUMN IF NOT EXISTS identity_keys BYTEA[];
+
+UPDATE device_lists l SET identity_keys = ARRAY(
+    SELECT COALESCE(ik.identity_key, ''::bytea)
+    FROM unnest(l.device_ids) WITH ORDINALITY AS u(device_id, n)
+    LEFT JOIN device_identity_keys ik ON ik.device_id = u.device_id
+    ORDER BY u.n)
+  WHERE l.identity_keys IS NULL;
+
+ALTER TABLE device_lists ALTER COLUMN identity_keys SET NOT NULL;
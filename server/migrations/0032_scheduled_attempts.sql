@@ -0,0 +1,3 @@
+-- Delivery attempts that failed with a transient error; the scheduler backs
+-- off between them and gives up after messages.maxDeliveryAttempts.
+ALTER TABLE scheduled_messages ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0;